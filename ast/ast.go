@@ -2,6 +2,7 @@ package ast
 
 import (
 	"bytes"
+	"math/big"
 	"monkey/token"
 	"strings"
 )
@@ -9,6 +10,7 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position
 }
 
 type Statement interface {
@@ -23,6 +25,7 @@ type Expression interface {
 
 type Program struct {
 	Statements []Statement
+	Comments   []*Comment // in source order, if the lexer scanned them
 }
 
 func (p *Program) TokenLiteral() string {
@@ -33,6 +36,14 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -55,6 +66,8 @@ func (ls *LetStatement) TokenLiteral() string {
 	return ls.Token.Literal
 }
 
+func (ls *LetStatement) Pos() token.Position { return ls.Token.Pos }
+
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -71,16 +84,31 @@ func (ls *LetStatement) String() string {
 }
 
 type Identifier struct {
-	Token token.Token
-	Value string
+	Token   token.Token
+	Value   string
+	Binding Binding
+}
+
+// Binding records where the resolver found the variable an identifier
+// names: slot Slot of the environment Depth scopes out from the one the
+// identifier appears in.
+type Binding struct {
+	Resolved bool
+	Depth    int
+	Slot     int
 }
 
+// Frame lists the variables of a scope in slot order.
+type Frame []string
+
 func (i *Identifier) expressionNode() {}
 
 func (i *Identifier) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+func (i *Identifier) Pos() token.Position { return i.Token.Pos }
+
 func (i *Identifier) String() string { return i.Value }
 
 type ReturnStatement struct {
@@ -106,6 +134,8 @@ func (r *ReturnStatement) TokenLiteral() string {
 	return r.Token.Literal
 }
 
+func (r *ReturnStatement) Pos() token.Position { return r.Token.Pos }
+
 func (r *ReturnStatement) statementNode() {}
 
 type ExpressionStatement struct {
@@ -117,6 +147,8 @@ func (es *ExpressionStatement) TokenLiteral() string {
 	return es.Token.Literal
 }
 
+func (es *ExpressionStatement) Pos() token.Position { return es.Token.Pos }
+
 func (es *ExpressionStatement) statementNode() {}
 
 func (es *ExpressionStatement) String() string {
@@ -136,12 +168,49 @@ func (il *IntegerLiteral) TokenLiteral() string {
 	return il.Token.Literal
 }
 
+func (il *IntegerLiteral) Pos() token.Position { return il.Token.Pos }
+
 func (il *IntegerLiteral) String() string {
 	return il.Token.Literal
 }
 
 func (il *IntegerLiteral) expressionNode() {}
 
+// BigIntegerLiteral is an integer literal too large for an int64.
+type BigIntegerLiteral struct {
+	Token token.Token
+	Value *big.Int
+}
+
+func (bl *BigIntegerLiteral) TokenLiteral() string {
+	return bl.Token.Literal
+}
+
+func (bl *BigIntegerLiteral) Pos() token.Position { return bl.Token.Pos }
+
+func (bl *BigIntegerLiteral) String() string {
+	return bl.Token.Literal
+}
+
+func (bl *BigIntegerLiteral) expressionNode() {}
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) TokenLiteral() string {
+	return fl.Token.Literal
+}
+
+func (fl *FloatLiteral) Pos() token.Position { return fl.Token.Pos }
+
+func (fl *FloatLiteral) String() string {
+	return fl.Token.Literal
+}
+
+func (fl *FloatLiteral) expressionNode() {}
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -151,12 +220,34 @@ func (s *StringLiteral) TokenLiteral() string {
 	return s.Token.Literal
 }
 
+func (s *StringLiteral) Pos() token.Position { return s.Token.Pos }
+
 func (s *StringLiteral) String() string {
 	return s.Token.Literal
 }
 
 func (s *StringLiteral) expressionNode() {}
 
+// InterpolatedString is a string literal containing `${...}` expressions.
+// Parts alternates between the literal text, as *StringLiteral, and the
+// embedded expressions, in source order.
+type InterpolatedString struct {
+	Token token.Token
+	Parts []Expression
+}
+
+func (is *InterpolatedString) TokenLiteral() string {
+	return is.Token.Literal
+}
+
+func (is *InterpolatedString) Pos() token.Position { return is.Token.Pos }
+
+func (is *InterpolatedString) String() string {
+	return is.Token.Literal
+}
+
+func (is *InterpolatedString) expressionNode() {}
+
 type PrefixExpression struct {
 	Token    token.Token
 	Operator string
@@ -167,6 +258,8 @@ func (p *PrefixExpression) TokenLiteral() string {
 	return p.Token.Literal
 }
 
+func (p *PrefixExpression) Pos() token.Position { return p.Token.Pos }
+
 func (p *PrefixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -189,6 +282,8 @@ func (ie *InfixExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+func (ie *InfixExpression) Pos() token.Position { return ie.Token.Pos }
+
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -201,6 +296,28 @@ func (ie *InfixExpression) String() string {
 
 func (ie *InfixExpression) expressionNode() {}
 
+// AssignExpression stores Value into Target, an *Identifier or an
+// *IndexExpression. For a compound operator such as += the current value of
+// Target is combined with Value first.
+type AssignExpression struct {
+	Token    token.Token // the assignment operator
+	Target   Expression
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) TokenLiteral() string {
+	return ae.Token.Literal
+}
+
+func (ae *AssignExpression) Pos() token.Position { return ae.Token.Pos }
+
+func (ae *AssignExpression) String() string {
+	return ae.Target.String() + " " + ae.Operator + " " + ae.Value.String()
+}
+
+func (ae *AssignExpression) expressionNode() {}
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -208,6 +325,8 @@ type Boolean struct {
 
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 
+func (b *Boolean) Pos() token.Position { return b.Token.Pos }
+
 func (b *Boolean) String() string { return b.Token.Literal }
 
 func (b *Boolean) expressionNode() {}
@@ -223,6 +342,8 @@ func (ie *IfExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+func (ie *IfExpression) Pos() token.Position { return ie.Token.Pos }
+
 func (ie *IfExpression) String() string {
 	var out = bytes.Buffer{}
 
@@ -244,12 +365,15 @@ func (ie *IfExpression) expressionNode() {}
 type BlockStatement struct {
 	Token      token.Token
 	Statements []Statement
+	Rbrace     token.Position // of the closing }
 }
 
 func (bs *BlockStatement) TokenLiteral() string {
 	return bs.Token.Literal
 }
 
+func (bs *BlockStatement) Pos() token.Position { return bs.Token.Pos }
+
 func (bs *BlockStatement) String() string {
 	var out = bytes.Buffer{}
 
@@ -264,16 +388,107 @@ func (bs *BlockStatement) String() string {
 
 func (bs *BlockStatement) statementNode() {}
 
+type WhileStatement struct {
+	Token     token.Token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) TokenLiteral() string {
+	return ws.Token.Literal
+}
+
+func (ws *WhileStatement) Pos() token.Position { return ws.Token.Pos }
+
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while (")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(") ")
+	out.WriteString(ws.Body.String())
+
+	return out.String()
+}
+
+func (ws *WhileStatement) statementNode() {}
+
+// ForStatement binds Variable to each element of Iterable in turn and runs
+// Body.
+type ForStatement struct {
+	Token    token.Token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+	Locals   Frame // the loop variable first, then the body's let bindings
+}
+
+func (fs *ForStatement) TokenLiteral() string {
+	return fs.Token.Literal
+}
+
+func (fs *ForStatement) Pos() token.Position { return fs.Token.Pos }
+
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	out.WriteString(fs.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+func (fs *ForStatement) statementNode() {}
+
+type BreakStatement struct {
+	Token token.Token
+}
+
+func (bs *BreakStatement) TokenLiteral() string {
+	return bs.Token.Literal
+}
+
+func (bs *BreakStatement) Pos() token.Position { return bs.Token.Pos }
+
+func (bs *BreakStatement) String() string {
+	return bs.Token.Literal + ";"
+}
+
+func (bs *BreakStatement) statementNode() {}
+
+type ContinueStatement struct {
+	Token token.Token
+}
+
+func (cs *ContinueStatement) TokenLiteral() string {
+	return cs.Token.Literal
+}
+
+func (cs *ContinueStatement) Pos() token.Position { return cs.Token.Pos }
+
+func (cs *ContinueStatement) String() string {
+	return cs.Token.Literal + ";"
+}
+
+func (cs *ContinueStatement) statementNode() {}
+
 type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
 	Body       *BlockStatement
+	Locals     Frame // parameters first, then the body's let bindings
 }
 
 func (fl *FunctionLiteral) TokenLiteral() string {
 	return fl.Token.Literal
 }
 
+func (fl *FunctionLiteral) Pos() token.Position { return fl.Token.Pos }
+
 func (fl *FunctionLiteral) String() string {
 	var out = bytes.Buffer{}
 
@@ -293,16 +508,53 @@ func (fl *FunctionLiteral) String() string {
 
 func (fl *FunctionLiteral) expressionNode() {}
 
+// MacroLiteral is macro(params) { body }. Macros bound by top-level let
+// statements are expanded before the program runs.
+type MacroLiteral struct {
+	Token      token.Token
+	Parameters []*Identifier
+	Body       *BlockStatement
+	Locals     Frame // parameters first, then the body's let bindings
+}
+
+func (ml *MacroLiteral) TokenLiteral() string {
+	return ml.Token.Literal
+}
+
+func (ml *MacroLiteral) Pos() token.Position { return ml.Token.Pos }
+
+func (ml *MacroLiteral) String() string {
+	var out = bytes.Buffer{}
+
+	params := []string{}
+	for _, parameter := range ml.Parameters {
+		params = append(params, parameter.String())
+	}
+
+	out.WriteString(ml.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") ")
+	out.WriteString(ml.Body.String())
+
+	return out.String()
+}
+
+func (ml *MacroLiteral) expressionNode() {}
+
 type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+	Rparen    token.Position // of the closing )
 }
 
 func (ce *CallExpression) TokenLiteral() string {
 	return ce.Token.Literal
 }
 
+func (ce *CallExpression) Pos() token.Position { return ce.Token.Pos }
+
 func (ce *CallExpression) String() string {
 	out := bytes.Buffer{}
 	arguments := []string{}
@@ -323,12 +575,15 @@ func (ce *CallExpression) expressionNode() {}
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
+	Rbracket token.Position // of the closing ]
 }
 
 func (al *ArrayLiteral) TokenLiteral() string {
 	return al.Token.Literal
 }
 
+func (al *ArrayLiteral) Pos() token.Position { return al.Token.Pos }
+
 func (al *ArrayLiteral) String() string {
 	out := bytes.Buffer{}
 
@@ -357,6 +612,8 @@ func (ie *IndexExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+func (ie *IndexExpression) Pos() token.Position { return ie.Token.Pos }
+
 func (ie *IndexExpression) String() string {
 	out := bytes.Buffer{}
 
@@ -373,21 +630,30 @@ func (ie *IndexExpression) String() string {
 func (ie *IndexExpression) expressionNode() {}
 
 type HashLiteral struct {
-	Token token.Token
-	Pairs map[Expression]Expression
+	Token  token.Token
+	Pairs  []HashPair     // in source order
+	Rbrace token.Position // of the closing }
+}
+
+// HashPair is a key and its value in a hash literal.
+type HashPair struct {
+	Key   Expression
+	Value Expression
 }
 
 func (hl *HashLiteral) TokenLiteral() string {
 	return hl.Token.Literal
 }
 
+func (hl *HashLiteral) Pos() token.Position { return hl.Token.Pos }
+
 func (hl *HashLiteral) String() string {
 	out := bytes.Buffer{}
 
 	var pairs []string
 
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+" : "+value.String())
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, pair.Key.String()+" : "+pair.Value.String())
 	}
 
 	out.WriteString("{")
@@ -398,3 +664,38 @@ func (hl *HashLiteral) String() string {
 }
 
 func (hl *HashLiteral) expressionNode() {}
+
+// BadStatement is a placeholder for a statement containing syntax errors.
+// It spans the tokens the parser skipped while recovering.
+type BadStatement struct {
+	Token token.Token // the first token of the statement
+	To    token.Token // the last token skipped
+}
+
+func (bs *BadStatement) TokenLiteral() string {
+	return bs.Token.Literal
+}
+
+func (bs *BadStatement) Pos() token.Position { return bs.Token.Pos }
+
+func (bs *BadStatement) String() string {
+	return "<bad statement>"
+}
+
+func (bs *BadStatement) statementNode() {}
+
+// Comment is a // or /* */ comment. Its literal is the text of the
+// comment, delimiters included.
+type Comment struct {
+	Token token.Token
+}
+
+func (c *Comment) TokenLiteral() string {
+	return c.Token.Literal
+}
+
+func (c *Comment) Pos() token.Position { return c.Token.Pos }
+
+func (c *Comment) String() string {
+	return c.Token.Literal
+}
@@ -1,7 +1,10 @@
 package ast
 
 import (
+	"bytes"
+	"math/big"
 	"monkey/token"
+	"reflect"
 	"testing"
 )
 
@@ -36,3 +39,533 @@ func TestString(t *testing.T) {
 	}
 
 }
+
+func TestFprint(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let", Pos: token.Position{Line: 1, Column: 1}},
+				Name: &Identifier{
+					Token: token.Token{Type: token.IDENT, Literal: "x", Pos: token.Position{Line: 1, Column: 5}},
+					Value: "x",
+				},
+				Value: &InfixExpression{
+					Token:    token.Token{Type: token.PLUS, Literal: "+", Pos: token.Position{Line: 1, Column: 11}},
+					Left:     &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1},
+					Operator: "+",
+					Right:    &ArrayLiteral{Token: token.Token{Type: token.LBRACKET, Literal: "["}},
+				},
+			},
+		},
+	}
+
+	expected := `Program 1:1
+  Statements: (len = 1)
+    0: LetStatement 1:1
+      Name: Identifier 1:5 Value="x"
+      Value: InfixExpression 1:11 Operator="+"
+        Left: IntegerLiteral Value=1
+        Right: ArrayLiteral
+          Elements: (len = 0)
+`
+
+	var out bytes.Buffer
+	if err := Fprint(&out, program); err != nil {
+		t.Fatalf("Fprint returned error: %s", err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("Fprint wrong. expected=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestModify(t *testing.T) {
+	one := func() Expression { return &IntegerLiteral{Value: 1} }
+	two := func() Expression { return &IntegerLiteral{Value: 2} }
+
+	turnOneIntoTwo := func(node Node) Node {
+		integer, ok := node.(*IntegerLiteral)
+		if !ok {
+			return node
+		}
+
+		if integer.Value != 1 {
+			return node
+		}
+
+		integer.Value = 2
+		return integer
+	}
+
+	tests := []struct {
+		input    Node
+		expected Node
+	}{
+		{
+			one(),
+			two(),
+		},
+		{
+			&Program{
+				Statements: []Statement{
+					&ExpressionStatement{Expression: one()},
+				},
+			},
+			&Program{
+				Statements: []Statement{
+					&ExpressionStatement{Expression: two()},
+				},
+			},
+		},
+		{
+			&InfixExpression{Left: one(), Operator: "+", Right: two()},
+			&InfixExpression{Left: two(), Operator: "+", Right: two()},
+		},
+		{
+			&InfixExpression{Left: two(), Operator: "+", Right: one()},
+			&InfixExpression{Left: two(), Operator: "+", Right: two()},
+		},
+		{
+			&PrefixExpression{Operator: "-", Right: one()},
+			&PrefixExpression{Operator: "-", Right: two()},
+		},
+		{
+			&IndexExpression{Left: one(), Index: one()},
+			&IndexExpression{Left: two(), Index: two()},
+		},
+		{
+			&AssignExpression{Target: &IndexExpression{Left: one(), Index: one()}, Operator: "=", Value: one()},
+			&AssignExpression{Target: &IndexExpression{Left: two(), Index: two()}, Operator: "=", Value: two()},
+		},
+		{
+			&IfExpression{
+				Condition: one(),
+				Consequence: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+				Alternative: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&IfExpression{
+				Condition: two(),
+				Consequence: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+				Alternative: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&WhileStatement{Condition: one(), Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}}},
+			&WhileStatement{Condition: two(), Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}}},
+		},
+		{
+			&ForStatement{Variable: &Identifier{Value: "x"}, Iterable: one(), Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}}},
+			&ForStatement{Variable: &Identifier{Value: "x"}, Iterable: two(), Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}}},
+		},
+		{
+			&ReturnStatement{ReturnValue: one()},
+			&ReturnStatement{ReturnValue: two()},
+		},
+		{
+			&LetStatement{Value: one()},
+			&LetStatement{Value: two()},
+		},
+		{
+			&FunctionLiteral{
+				Parameters: []*Identifier{},
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&FunctionLiteral{
+				Parameters: []*Identifier{},
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&CallExpression{Function: one(), Arguments: []Expression{one(), one()}},
+			&CallExpression{Function: two(), Arguments: []Expression{two(), two()}},
+		},
+		{
+			&ArrayLiteral{Elements: []Expression{one(), one()}},
+			&ArrayLiteral{Elements: []Expression{two(), two()}},
+		},
+		{
+			&InterpolatedString{Parts: []Expression{&StringLiteral{Value: "a"}, one()}},
+			&InterpolatedString{Parts: []Expression{&StringLiteral{Value: "a"}, two()}},
+		},
+	}
+
+	for _, tt := range tests {
+		modified := Modify(tt.input, turnOneIntoTwo)
+
+		if !reflect.DeepEqual(modified, tt.expected) {
+			t.Errorf("not equal. got=%#v, want=%#v", modified, tt.expected)
+		}
+	}
+
+	hashLiteral := &HashLiteral{
+		Pairs: []HashPair{
+			{Key: one(), Value: one()},
+			{Key: one(), Value: one()},
+		},
+	}
+
+	Modify(hashLiteral, turnOneIntoTwo)
+
+	for _, pair := range hashLiteral.Pairs {
+		key, _ := pair.Key.(*IntegerLiteral)
+		if key.Value != 2 {
+			t.Errorf("value is not %d, got=%d", 2, key.Value)
+		}
+		val, _ := pair.Value.(*IntegerLiteral)
+		if val.Value != 2 {
+			t.Errorf("value is not %d, got=%d", 2, val.Value)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	original := &CallExpression{
+		Function: &Identifier{Value: "f", Binding: Binding{Resolved: true, Slot: 3}},
+		Arguments: []Expression{
+			&BigIntegerLiteral{Token: token.Token{Literal: "99999999999999999999"}, Value: new(big.Int).Lsh(big.NewInt(1), 70)},
+			&HashLiteral{Pairs: []HashPair{{Key: &IntegerLiteral{Value: 1}, Value: &IntegerLiteral{Value: 1}}}},
+		},
+	}
+
+	copied := Copy(original).(*CallExpression)
+	if copied.String() != original.String() {
+		t.Fatalf("copy is not equal. got=%s, want=%s", copied, original)
+	}
+	if copied.Function.(*Identifier).Binding != original.Function.(*Identifier).Binding {
+		t.Errorf("copy lost the binding. got=%+v", copied.Function.(*Identifier).Binding)
+	}
+
+	Modify(copied, func(node Node) Node {
+		if integer, ok := node.(*IntegerLiteral); ok {
+			integer.Value = 2
+		}
+		if ident, ok := node.(*Identifier); ok {
+			ident.Value = "g"
+		}
+		return node
+	})
+	copied.Arguments[0] = nil
+
+	if original.Function.(*Identifier).Value != "f" || original.Arguments[0] == nil {
+		t.Errorf("modifying the copy changed the original: %s", original)
+	}
+	for _, pair := range original.Arguments[1].(*HashLiteral).Pairs {
+		if pair.Key.(*IntegerLiteral).Value != 1 || pair.Value.(*IntegerLiteral).Value != 1 {
+			t.Errorf("modifying the copy changed the original hash: %s", original)
+		}
+	}
+}
+
+// walkTree is fn(x) { if (x) { f(x, [1]) } else { {"a": 1, "b": x} } }.
+func walkTree() Node {
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+
+	return &FunctionLiteral{
+		Parameters: []*Identifier{ident("x")},
+		Body: &BlockStatement{Statements: []Statement{
+			&ExpressionStatement{Expression: &IfExpression{
+				Condition: ident("x"),
+				Consequence: &BlockStatement{Statements: []Statement{
+					&ExpressionStatement{Expression: &CallExpression{
+						Function:  ident("f"),
+						Arguments: []Expression{ident("x"), &ArrayLiteral{Elements: []Expression{&IntegerLiteral{Value: 1}}}},
+					}},
+				}},
+				Alternative: &BlockStatement{Statements: []Statement{
+					&ExpressionStatement{Expression: &HashLiteral{Pairs: []HashPair{
+						{Key: &StringLiteral{Value: "a"}, Value: &IntegerLiteral{Value: 1}},
+						{Key: &StringLiteral{Value: "b"}, Value: ident("x")},
+					}}},
+				}},
+			}},
+		}},
+	}
+}
+
+type recorder struct {
+	visited []string
+	depth   int
+}
+
+func (r *recorder) Visit(node Node) Visitor {
+	if node == nil {
+		r.depth--
+		return nil
+	}
+
+	name := reflect.TypeOf(node).Elem().Name()
+	if ident, ok := node.(*Identifier); ok {
+		name += " " + ident.Value
+	}
+	if str, ok := node.(*StringLiteral); ok {
+		name += " " + str.Value
+	}
+	r.visited = append(r.visited, name)
+
+	r.depth++
+	return r
+}
+
+func TestWalk(t *testing.T) {
+	r := &recorder{}
+	Walk(r, walkTree())
+
+	expected := []string{
+		"FunctionLiteral",
+		"Identifier x",
+		"BlockStatement",
+		"ExpressionStatement",
+		"IfExpression",
+		"Identifier x",
+		"BlockStatement",
+		"ExpressionStatement",
+		"CallExpression",
+		"Identifier f",
+		"Identifier x",
+		"ArrayLiteral",
+		"IntegerLiteral",
+		"BlockStatement",
+		"ExpressionStatement",
+		"HashLiteral",
+		"StringLiteral a",
+		"IntegerLiteral",
+		"StringLiteral b",
+		"Identifier x",
+	}
+
+	if !reflect.DeepEqual(r.visited, expected) {
+		t.Errorf("wrong nodes visited.\nwant=%q\ngot =%q", expected, r.visited)
+	}
+	if r.depth != 0 {
+		t.Errorf("Visit(nil) not called once per node visited. depth=%d", r.depth)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	tests := []struct {
+		skip     string
+		expected int
+	}{
+		{"", 5},
+		{"CallExpression", 3},
+		{"IfExpression", 1},
+		{"FunctionLiteral", 0},
+	}
+
+	for _, tt := range tests {
+		identifiers := 0
+		nils := 0
+		Inspect(walkTree(), func(node Node) bool {
+			switch node := node.(type) {
+			case nil:
+				nils++
+			case *Identifier:
+				identifiers++
+			default:
+				if reflect.TypeOf(node).Elem().Name() == tt.skip {
+					return false
+				}
+			}
+			return true
+		})
+
+		if identifiers != tt.expected {
+			t.Errorf("skipping %s: wrong number of identifiers. want=%d, got=%d",
+				tt.skip, tt.expected, identifiers)
+		}
+		if tt.skip == "" && nils != 20 {
+			t.Errorf("wrong number of nil nodes. want=20, got=%d", nils)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	rename := func(node Node) (Node, bool) {
+		switch node := node.(type) {
+		case *Identifier:
+			return &Identifier{Value: node.Value + "2"}, false
+		case *CallExpression:
+			// The argument put in its place is not rewritten itself.
+			return node.Arguments[0], true
+		case *HashLiteral:
+			return &ArrayLiteral{}, true
+		}
+		return node, true
+	}
+
+	rewritten := Rewrite(walkTree(), rename)
+
+	expected := "fn (x2) ifx2 xelse []"
+	if rewritten.String() != expected {
+		t.Errorf("wrong result. want=%q, got=%q", expected, rewritten.String())
+	}
+
+	dropped := Rewrite(&ExpressionStatement{Expression: &IntegerLiteral{Value: 1}}, func(node Node) (Node, bool) {
+		if _, ok := node.(*IntegerLiteral); ok {
+			return &BlockStatement{}, true
+		}
+		return node, true
+	})
+	if stmt := dropped.(*ExpressionStatement); stmt.Expression != nil {
+		t.Errorf("statement put in place of an expression. got=%v", stmt.Expression)
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	pos := func(file string, col int) token.Position {
+		return token.Position{Filename: file, Line: 1, Column: col, Offset: col - 1}
+	}
+
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{
+				Token: token.Token{Type: token.INT, Literal: "10", Pos: pos("a.mk", 1)},
+				Expression: &BigIntegerLiteral{
+					Token: token.Token{Type: token.INT, Literal: "10", Pos: pos("a.mk", 1)},
+					Value: big.NewInt(10),
+				},
+			},
+			&BadStatement{
+				Token: token.Token{Type: token.LET, Literal: "let", Pos: pos("b.mk", 1)},
+				To:    token.Token{Type: token.SEMICOLON, Literal: ";", Pos: pos("b.mk", 6)},
+			},
+		},
+	}
+
+	expected := `{
+  "kind": "Program",
+  "statements": [
+    {
+      "kind": "ExpressionStatement",
+      "pos": {
+        "filename": "a.mk",
+        "line": 1,
+        "column": 1,
+        "offset": 0
+      },
+      "token": "INT",
+      "literal": "10",
+      "expression": {
+        "kind": "BigIntegerLiteral",
+        "pos": {
+          "line": 1,
+          "column": 1,
+          "offset": 0
+        },
+        "token": "INT",
+        "literal": "10",
+        "value": "10"
+      }
+    },
+    {
+      "kind": "BadStatement",
+      "pos": {
+        "filename": "b.mk",
+        "line": 1,
+        "column": 1,
+        "offset": 0
+      },
+      "token": "LET",
+      "literal": "let",
+      "to": {
+        "pos": {
+          "line": 1,
+          "column": 6,
+          "offset": 5
+        },
+        "token": ";",
+        "literal": ";"
+      }
+    }
+  ],
+  "comments": null
+}
+`
+
+	var out bytes.Buffer
+	if err := EncodeJSON(&out, program); err != nil {
+		t.Fatalf("EncodeJSON failed: %s", err)
+	}
+	if out.String() != expected {
+		t.Fatalf("wrong encoding.\nwant=%s\ngot =%s", expected, out.String())
+	}
+
+	decoded, err := DecodeJSON(&out)
+	if err != nil {
+		t.Fatalf("DecodeJSON failed: %s", err)
+	}
+	if !reflect.DeepEqual(decoded, program) {
+		t.Errorf("round trip changed the program.\nwant=%#v\ngot =%#v", program, decoded)
+	}
+}
+
+func TestJSONHashLiteral(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := EncodeJSON(&encoded, walkTree()); err != nil {
+		t.Fatalf("EncodeJSON failed: %s", err)
+	}
+
+	decoded, err := DecodeJSON(bytes.NewReader(encoded.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodeJSON failed: %s", err)
+	}
+
+	r := &recorder{}
+	Walk(r, decoded)
+	expected := &recorder{}
+	Walk(expected, walkTree())
+	if !reflect.DeepEqual(r.visited, expected.visited) {
+		t.Errorf("round trip changed the tree.\nwant=%q\ngot =%q", expected.visited, r.visited)
+	}
+}
+
+func TestDecodeJSONErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"statements": []}`, "node without a kind"},
+		{`{"kind": "Loop"}`, `unknown node kind "Loop"`},
+		{`{"kind": "Program", "statements": [{"kind": "Identifier"}]}`,
+			"Program.statements: 0: Identifier does not belong here"},
+		{`{"kind": "LetStatement", "name": {"kind": "StringLiteral"}}`,
+			"LetStatement.name: StringLiteral does not belong here"},
+		{`{"kind": "BigIntegerLiteral", "value": "1x"}`, `BigIntegerLiteral.value: invalid integer "1x"`},
+		{`{"kind": "HashLiteral", "pairs": [{"value": null}]}`, "HashLiteral.pairs: 0: pair without a key"},
+	}
+
+	for _, tt := range tests {
+		_, err := DecodeJSON(bytes.NewBufferString(tt.input))
+		if err == nil {
+			t.Errorf("DecodeJSON(%s) did not fail", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error for %s. want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"monkey/token"
+	"reflect"
+)
+
+// EncodeJSON writes the tree rooted at node to w as JSON, for tools that
+// cannot link this package. Each node is an object whose "kind" is the
+// name of its type, followed by its fields, named like the Go fields but
+// starting in lower case:
+//
+//   - the node's token becomes "pos", "token" (the token type) and
+//     "literal"; other tokens, like BadStatement.To, are objects holding
+//     those three;
+//   - a position, like BlockStatement.Rbrace, is {"line", "column",
+//     "offset"}, plus "filename" where it differs from the filename of the
+//     enclosing node;
+//   - child nodes are objects, or null, and lists of them arrays;
+//   - the pairs of a hash literal are an array of {"key", "value"}
+//     objects, in source order;
+//   - the value of a BigIntegerLiteral is a string of decimal digits.
+//
+// The resolver's annotations are left out. DecodeJSON reads the encoding
+// back.
+func EncodeJSON(w io.Writer, node Node) error {
+	e := &jsonEncoder{}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(e.node(node))
+}
+
+// DecodeJSON reads a tree written by EncodeJSON from r. Fields missing
+// from a node are left at their zero value and unknown ones are ignored.
+func DecodeJSON(r io.Reader) (Node, error) {
+	var data json.RawMessage
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	d := &jsonDecoder{}
+	return d.node(data)
+}
+
+// jsonKinds maps the "kind" of each node to its type.
+var jsonKinds = map[string]reflect.Type{}
+
+func init() {
+	for _, node := range []Node{
+		&Program{}, &LetStatement{}, &ReturnStatement{}, &ExpressionStatement{},
+		&BlockStatement{}, &WhileStatement{}, &ForStatement{}, &BreakStatement{},
+		&ContinueStatement{}, &BadStatement{}, &Identifier{}, &IntegerLiteral{},
+		&BigIntegerLiteral{}, &FloatLiteral{}, &StringLiteral{}, &InterpolatedString{},
+		&PrefixExpression{}, &InfixExpression{}, &AssignExpression{}, &Boolean{},
+		&IfExpression{}, &FunctionLiteral{}, &MacroLiteral{}, &CallExpression{},
+		&ArrayLiteral{}, &IndexExpression{}, &HashLiteral{}, &Comment{},
+	} {
+		typ := reflect.TypeOf(node).Elem()
+		jsonKinds[typ.Name()] = typ
+	}
+}
+
+var (
+	bigIntType   = reflect.TypeOf((*big.Int)(nil))
+	hashPairType = reflect.TypeOf(HashPair{})
+)
+
+// jsonName is the name of the field called name in the encoding.
+func jsonName(name string) string {
+	return string(name[0]-'A'+'a') + name[1:]
+}
+
+// jsonObject is a JSON object that keeps its keys in the order they are
+// set, so that "kind" comes first.
+type jsonObject struct {
+	keys   []string
+	values []any
+}
+
+func (o *jsonObject) set(key string, value any) {
+	o.keys = append(o.keys, key)
+	o.values = append(o.values, value)
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := marshal(key)
+		v, err := marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// marshal is json.Marshal without the escaping of <, > and &, which are
+// common in operators.
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+type jsonEncoder struct {
+	filename string // of the enclosing node
+}
+
+func (e *jsonEncoder) node(node Node) any {
+	v := reflect.ValueOf(node)
+	if node == nil || (v.Kind() == reflect.Pointer && v.IsNil()) {
+		return nil
+	}
+
+	outer := e.filename
+	defer func() { e.filename = outer }()
+
+	elem := v.Elem()
+	typ := elem.Type()
+
+	obj := &jsonObject{}
+	obj.set("kind", typ.Name())
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		value := elem.Field(i)
+
+		switch {
+		case !field.IsExported() || field.Type == bindingType || field.Type == frameType:
+			continue
+		case field.Type == tokenType && field.Name == "Token":
+			tok := value.Interface().(token.Token)
+			obj.set("pos", e.pos(tok.Pos))
+			obj.set("token", tok.Type)
+			obj.set("literal", tok.Literal)
+			// Children are placed relative to the node's own file.
+			e.filename = tok.Pos.Filename
+		case field.Type == tokenType:
+			tok := value.Interface().(token.Token)
+			t := &jsonObject{}
+			t.set("pos", e.pos(tok.Pos))
+			t.set("token", tok.Type)
+			t.set("literal", tok.Literal)
+			obj.set(jsonName(field.Name), t)
+		case field.Type == positionType:
+			obj.set(jsonName(field.Name), e.pos(value.Interface().(token.Position)))
+		default:
+			obj.set(jsonName(field.Name), e.value(value))
+		}
+	}
+
+	return obj
+}
+
+func (e *jsonEncoder) pos(pos token.Position) any {
+	obj := &jsonObject{}
+	if pos.Filename != e.filename {
+		obj.set("filename", pos.Filename)
+	}
+	obj.set("line", pos.Line)
+	obj.set("column", pos.Column)
+	obj.set("offset", pos.Offset)
+	return obj
+}
+
+func (e *jsonEncoder) value(value reflect.Value) any {
+	switch {
+	case value.Type() == bigIntType:
+		if value.IsNil() {
+			return nil
+		}
+		return value.Interface().(*big.Int).String()
+	case value.Type().Implements(nodeType) || value.Type() == nodeType:
+		node, _ := value.Interface().(Node)
+		return e.node(node)
+	case value.Kind() == reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+		return e.value(value.Elem())
+	case value.Kind() == reflect.Slice:
+		if value.IsNil() {
+			return nil
+		}
+		list := make([]any, value.Len())
+		for i := range list {
+			list[i] = e.value(value.Index(i))
+		}
+		return list
+	case value.Type() == hashPairType:
+		pair := value.Interface().(HashPair)
+		obj := &jsonObject{}
+		obj.set("key", e.node(pair.Key))
+		obj.set("value", e.node(pair.Value))
+		return obj
+	default:
+		return value.Interface()
+	}
+}
+
+type jsonDecoder struct {
+	filename string // of the enclosing node
+}
+
+func isNull(data json.RawMessage) bool {
+	return len(data) == 0 || string(data) == "null"
+}
+
+func (d *jsonDecoder) node(data json.RawMessage) (Node, error) {
+	if isNull(data) {
+		return nil, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	var kind string
+	if err := json.Unmarshal(fields["kind"], &kind); err != nil {
+		return nil, fmt.Errorf("node without a kind")
+	}
+	typ, ok := jsonKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown node kind %q", kind)
+	}
+
+	outer := d.filename
+	defer func() { d.filename = outer }()
+
+	v := reflect.New(typ)
+	elem := v.Elem()
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		value := elem.Field(i)
+
+		switch {
+		case !field.IsExported() || field.Type == bindingType || field.Type == frameType:
+			continue
+		case field.Type == tokenType && field.Name == "Token":
+			tok, err := d.token(fields)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", kind, err)
+			}
+			value.Set(reflect.ValueOf(tok))
+			d.filename = tok.Pos.Filename
+		case field.Type == tokenType:
+			var t map[string]json.RawMessage
+			if raw := fields[jsonName(field.Name)]; !isNull(raw) {
+				if err := json.Unmarshal(raw, &t); err != nil {
+					return nil, fmt.Errorf("%s.%s: %w", kind, jsonName(field.Name), err)
+				}
+			}
+			tok, err := d.token(t)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", kind, jsonName(field.Name), err)
+			}
+			value.Set(reflect.ValueOf(tok))
+		case field.Type == positionType:
+			pos, err := d.pos(fields[jsonName(field.Name)])
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", kind, jsonName(field.Name), err)
+			}
+			value.Set(reflect.ValueOf(pos))
+		default:
+			if err := d.value(value, fields[jsonName(field.Name)]); err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", kind, jsonName(field.Name), err)
+			}
+		}
+	}
+
+	return v.Interface().(Node), nil
+}
+
+// token reads the "pos", "token" and "literal" of fields.
+func (d *jsonDecoder) token(fields map[string]json.RawMessage) (token.Token, error) {
+	var tok token.Token
+
+	pos, err := d.pos(fields["pos"])
+	if err != nil {
+		return tok, err
+	}
+	tok.Pos = pos
+
+	for key, dst := range map[string]any{"token": &tok.Type, "literal": &tok.Literal} {
+		if raw := fields[key]; !isNull(raw) {
+			if err := json.Unmarshal(raw, dst); err != nil {
+				return tok, err
+			}
+		}
+	}
+
+	return tok, nil
+}
+
+// pos reads a position. A missing filename is that of the enclosing node.
+func (d *jsonDecoder) pos(data json.RawMessage) (token.Position, error) {
+	pos := token.Position{Filename: d.filename}
+	if isNull(data) {
+		return pos, nil
+	}
+
+	fields := struct {
+		Filename *string
+		Line     int
+		Column   int
+		Offset   int
+	}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return pos, err
+	}
+
+	if fields.Filename != nil {
+		pos.Filename = *fields.Filename
+	}
+	pos.Line, pos.Column, pos.Offset = fields.Line, fields.Column, fields.Offset
+	return pos, nil
+}
+
+// value sets value, a field of a node, from data.
+func (d *jsonDecoder) value(value reflect.Value, data json.RawMessage) error {
+	if isNull(data) {
+		return nil
+	}
+
+	typ := value.Type()
+	switch {
+	case typ == bigIntType:
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		n, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		value.Set(reflect.ValueOf(n))
+	case typ.Implements(nodeType) || typ == nodeType:
+		node, err := d.node(data)
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			return nil
+		}
+		if !reflect.TypeOf(node).AssignableTo(typ) {
+			return fmt.Errorf("%s does not belong here", reflect.TypeOf(node).Elem().Name())
+		}
+		value.Set(reflect.ValueOf(node))
+	case typ.Kind() == reflect.Slice:
+		var list []json.RawMessage
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(typ, len(list), len(list))
+		for i, item := range list {
+			if err := d.value(slice.Index(i), item); err != nil {
+				return fmt.Errorf("%d: %w", i, err)
+			}
+		}
+		value.Set(slice)
+	case typ == hashPairType:
+		var fields struct{ Key, Value json.RawMessage }
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+		pair := value.Addr().Interface().(*HashPair)
+		if err := d.value(reflect.ValueOf(&pair.Key).Elem(), fields.Key); err != nil {
+			return fmt.Errorf("key: %w", err)
+		}
+		if err := d.value(reflect.ValueOf(&pair.Value).Elem(), fields.Value); err != nil {
+			return fmt.Errorf("value: %w", err)
+		}
+		if pair.Key == nil {
+			return fmt.Errorf("pair without a key")
+		}
+	default:
+		return json.Unmarshal(data, value.Addr().Interface())
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package ast
+
+import "reflect"
+
+// ModifierFunc returns the node to put in place of node.
+type ModifierFunc func(node Node) Node
+
+// Modify rewrites the tree rooted at node in place, bottom up: the children
+// of a node are modified before the node itself is passed to modifier, and
+// the result of modifier replaces it. Children are visited in the order
+// Walk visits them.
+//
+// A replacement of the wrong kind for where it goes, a statement where an
+// expression belongs for example, is dropped and leaves nil behind.
+func Modify(node Node, modifier ModifierFunc) Node {
+	replaceChildren(node, func(child Node) Node {
+		return Modify(child, modifier)
+	})
+
+	return modifier(node)
+}
+
+// Rewrite rewrites the tree rooted at node in place, top down: f is called
+// with a node before its children, and the node it returns replaces it. If
+// f also returns true, the children of the replacement are rewritten in
+// turn; otherwise they are left as they are. Replacements of the wrong kind
+// are dropped as by Modify.
+func Rewrite(node Node, f func(node Node) (Node, bool)) Node {
+	node, descend := f(node)
+	if descend && node != nil {
+		replaceChildren(node, func(child Node) Node {
+			return Rewrite(child, f)
+		})
+	}
+
+	return node
+}
+
+// replaceChildren sets each non-nil child of node to what replace returns
+// for it.
+func replaceChildren(node Node, replace func(Node) Node) {
+	expression := func(exp Expression) Expression {
+		if exp == nil {
+			return nil
+		}
+		exp, _ = replace(exp).(Expression)
+		return exp
+	}
+	block := func(block *BlockStatement) *BlockStatement {
+		if block == nil {
+			return nil
+		}
+		block, _ = replace(block).(*BlockStatement)
+		return block
+	}
+	identifier := func(ident *Identifier) *Identifier {
+		if ident == nil {
+			return nil
+		}
+		ident, _ = replace(ident).(*Identifier)
+		return ident
+	}
+	statements := func(list []Statement) {
+		for i, stmt := range list {
+			if stmt != nil {
+				list[i], _ = replace(stmt).(Statement)
+			}
+		}
+	}
+	expressions := func(list []Expression) {
+		for i, exp := range list {
+			list[i] = expression(exp)
+		}
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		statements(node.Statements)
+	case *LetStatement:
+		node.Name = identifier(node.Name)
+		node.Value = expression(node.Value)
+	case *ReturnStatement:
+		node.ReturnValue = expression(node.ReturnValue)
+	case *ExpressionStatement:
+		node.Expression = expression(node.Expression)
+	case *BlockStatement:
+		statements(node.Statements)
+	case *WhileStatement:
+		node.Condition = expression(node.Condition)
+		node.Body = block(node.Body)
+	case *ForStatement:
+		node.Variable = identifier(node.Variable)
+		node.Iterable = expression(node.Iterable)
+		node.Body = block(node.Body)
+	case *InterpolatedString:
+		expressions(node.Parts)
+	case *PrefixExpression:
+		node.Right = expression(node.Right)
+	case *InfixExpression:
+		node.Left = expression(node.Left)
+		node.Right = expression(node.Right)
+	case *AssignExpression:
+		node.Target = expression(node.Target)
+		node.Value = expression(node.Value)
+	case *IfExpression:
+		node.Condition = expression(node.Condition)
+		node.Consequence = block(node.Consequence)
+		node.Alternative = block(node.Alternative)
+	case *FunctionLiteral:
+		for i, param := range node.Parameters {
+			node.Parameters[i] = identifier(param)
+		}
+		node.Body = block(node.Body)
+	case *MacroLiteral:
+		for i, param := range node.Parameters {
+			node.Parameters[i] = identifier(param)
+		}
+		node.Body = block(node.Body)
+	case *CallExpression:
+		node.Function = expression(node.Function)
+		expressions(node.Arguments)
+	case *ArrayLiteral:
+		expressions(node.Elements)
+	case *IndexExpression:
+		node.Left = expression(node.Left)
+		node.Index = expression(node.Index)
+	case *HashLiteral:
+		for i, pair := range node.Pairs {
+			node.Pairs[i].Key = expression(pair.Key)
+			node.Pairs[i].Value = expression(pair.Value)
+		}
+	}
+}
+
+// Copy returns a deep copy of the tree rooted at node, so that it can be
+// modified without changing node.
+func Copy(node Node) Node {
+	if node == nil {
+		return nil
+	}
+	return copyValue(reflect.ValueOf(node)).Interface().(Node)
+}
+
+func copyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		// Only nodes are copied. Other pointers, like the *big.Int of a
+		// BigIntegerLiteral, point to values nothing changes.
+		if v.IsNil() || !v.Type().Implements(nodeType) {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(copyValue(v.Elem()))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(copyValue(v.Elem()))
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(copyValue(v.Field(i)))
+			}
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(copyValue(iter.Key()), copyValue(iter.Value()))
+		}
+		return c
+	default:
+		return v
+	}
+}
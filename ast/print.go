@@ -0,0 +1,127 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"monkey/token"
+	"reflect"
+	"strings"
+)
+
+// Fprint writes the tree rooted at node to w, one node per line, indented
+// by depth. Each line shows the node type, its position and its scalar
+// fields; child nodes follow, labelled with the field that holds them.
+// Tokens and the resolver's annotations are left out.
+func Fprint(w io.Writer, node Node) error {
+	p := &printer{w: w}
+	p.node("", node, 0)
+	return p.err
+}
+
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+var (
+	nodeType     = reflect.TypeOf((*Node)(nil)).Elem()
+	tokenType    = reflect.TypeOf(token.Token{})
+	bindingType  = reflect.TypeOf(Binding{})
+	frameType    = reflect.TypeOf(Frame{})
+	positionType = reflect.TypeOf(token.Position{})
+	commentsType = reflect.TypeOf([]*Comment{})
+)
+
+func (p *printer) printf(depth int, format string, a ...any) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, strings.Repeat("  ", depth)+format+"\n", a...)
+}
+
+func (p *printer) node(label string, node Node, depth int) {
+	v := reflect.ValueOf(node)
+	if node == nil || (v.Kind() == reflect.Pointer && v.IsNil()) {
+		p.printf(depth, "%snil", label)
+		return
+	}
+
+	elem := v.Elem()
+	typ := elem.Type()
+
+	var line strings.Builder
+	line.WriteString(label)
+	line.WriteString(typ.Name())
+	if pos := node.Pos(); pos.IsValid() {
+		line.WriteString(" " + pos.String())
+	}
+
+	var children []int
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() || field.Type == tokenType ||
+			field.Type == bindingType || field.Type == frameType {
+			continue
+		}
+
+		value := elem.Field(i)
+		switch {
+		case field.Type == positionType:
+			if pos := value.Interface().(token.Position); pos.IsValid() {
+				fmt.Fprintf(&line, " %s=%s", field.Name, pos)
+			}
+			continue
+		case field.Type == commentsType && value.Len() == 0:
+			// most programs are parsed without their comments
+			continue
+		}
+
+		switch value.Kind() {
+		case reflect.String, reflect.Int, reflect.Int64, reflect.Float64, reflect.Bool:
+			fmt.Fprintf(&line, " %s=%#v", field.Name, value.Interface())
+		default:
+			children = append(children, i)
+		}
+	}
+
+	p.printf(depth, "%s", line.String())
+
+	for _, i := range children {
+		p.field(typ.Field(i).Name, elem.Field(i), depth+1)
+	}
+}
+
+func (p *printer) field(name string, value reflect.Value, depth int) {
+	switch value.Kind() {
+	case reflect.Slice:
+		p.printf(depth, "%s: (len = %d)", name, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			p.value(fmt.Sprintf("%d: ", i), value.Index(i), depth+1)
+		}
+	default:
+		p.value(name+": ", value, depth)
+	}
+}
+
+func (p *printer) value(label string, value reflect.Value, depth int) {
+	if value.Type().Implements(nodeType) || value.Type() == nodeType {
+		node, _ := value.Interface().(Node)
+		p.node(label, node, depth)
+		return
+	}
+
+	if value.Type() == hashPairType {
+		pair := value.Interface().(HashPair)
+		p.printf(depth, "%sHashPair", label)
+		p.node("Key: ", pair.Key, depth+1)
+		p.node("Value: ", pair.Value, depth+1)
+		return
+	}
+
+	if value.Kind() == reflect.Interface && !value.IsNil() {
+		p.value(label, value.Elem(), depth)
+		return
+	}
+
+	p.printf(depth, "%s%v", label, value.Interface())
+}
@@ -0,0 +1,137 @@
+package ast
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree rooted at node in depth-first order: it starts
+// by calling v.Visit(node); node must not be nil. If the visitor w
+// returned by v.Visit(node) is not nil, Walk is invoked recursively with
+// visitor w for each of the non-nil children of node, followed by a call
+// of w.Visit(nil).
+//
+// Children are visited in source order, the key of each hash literal pair
+// before its value. The comments of a program are not visited.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkStatements(v, n.Statements)
+	case *LetStatement:
+		walkIdentifier(v, n.Name)
+		walkExpression(v, n.Value)
+	case *ReturnStatement:
+		walkExpression(v, n.ReturnValue)
+	case *ExpressionStatement:
+		walkExpression(v, n.Expression)
+	case *BlockStatement:
+		walkStatements(v, n.Statements)
+	case *WhileStatement:
+		walkExpression(v, n.Condition)
+		walkBlock(v, n.Body)
+	case *ForStatement:
+		walkIdentifier(v, n.Variable)
+		walkExpression(v, n.Iterable)
+		walkBlock(v, n.Body)
+	case *InterpolatedString:
+		walkExpressions(v, n.Parts)
+	case *PrefixExpression:
+		walkExpression(v, n.Right)
+	case *InfixExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Right)
+	case *AssignExpression:
+		walkExpression(v, n.Target)
+		walkExpression(v, n.Value)
+	case *IfExpression:
+		walkExpression(v, n.Condition)
+		walkBlock(v, n.Consequence)
+		walkBlock(v, n.Alternative)
+	case *FunctionLiteral:
+		for _, param := range n.Parameters {
+			walkIdentifier(v, param)
+		}
+		walkBlock(v, n.Body)
+	case *MacroLiteral:
+		for _, param := range n.Parameters {
+			walkIdentifier(v, param)
+		}
+		walkBlock(v, n.Body)
+	case *CallExpression:
+		walkExpression(v, n.Function)
+		walkExpressions(v, n.Arguments)
+	case *ArrayLiteral:
+		walkExpressions(v, n.Elements)
+	case *IndexExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Index)
+	case *HashLiteral:
+		for _, pair := range n.Pairs {
+			walkExpression(v, pair.Key)
+			walkExpression(v, pair.Value)
+		}
+	case *Identifier, *IntegerLiteral, *BigIntegerLiteral, *FloatLiteral,
+		*StringLiteral, *Boolean, *BreakStatement, *ContinueStatement, *BadStatement, *Comment:
+		// leaves
+	}
+
+	v.Visit(nil)
+}
+
+// The helpers below skip nil children, which a tree with syntax errors
+// can have, so that Walk never calls Visit with a typed nil node.
+
+func walkIdentifier(v Visitor, ident *Identifier) {
+	if ident != nil {
+		Walk(v, ident)
+	}
+}
+
+func walkBlock(v Visitor, block *BlockStatement) {
+	if block != nil {
+		Walk(v, block)
+	}
+}
+
+func walkExpression(v Visitor, exp Expression) {
+	if exp != nil {
+		Walk(v, exp)
+	}
+}
+
+func walkExpressions(v Visitor, list []Expression) {
+	for _, exp := range list {
+		walkExpression(v, exp)
+	}
+}
+
+func walkStatements(v Visitor, list []Statement) {
+	for _, stmt := range list {
+		if stmt != nil {
+			Walk(v, stmt)
+		}
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree rooted at node in depth-first order: it
+// starts by calling f(node); node must not be nil. If f returns true,
+// Inspect invokes f recursively for each of the non-nil children of node,
+// followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
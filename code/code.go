@@ -0,0 +1,286 @@
+// Package code defines the bytecode instructions the compiler produces and
+// the vm runs.
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"monkey/token"
+	"sort"
+)
+
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	case 3:
+		return fmt.Sprintf("%s %d %d %d", def.Name, operands[0], operands[1], operands[2])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	// Binary operators pop the right operand, then the left one, and push
+	// the result.
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShiftLeft
+	OpShiftRight
+	OpEqual
+	OpNotEqual
+	OpLessThan
+	OpGreaterThan
+	OpLessEqual
+	OpGreaterEqual
+
+	OpMinus
+	OpBang
+	OpBitNot
+
+	OpJump
+	OpJumpNotTruthy
+	// OpAnd and OpOr implement && and ||. OpAnd jumps if the value on top
+	// of the stack is falsy, leaving it there as the result, and pops it
+	// otherwise so that the right operand can be evaluated. OpOr does the
+	// same for a truthy value.
+	OpAnd
+	OpOr
+
+	// Variables live in environment slots. OpGetVar and OpAssign name the
+	// environment by how many levels out it is from the current one.
+	OpGetVar
+	OpDefine
+	// OpAssign stores the value on top of the stack, which it leaves there,
+	// into a variable that must already be set. A nonzero last operand is
+	// the binary operator of a compound assignment, applied to the current
+	// value and the new one.
+	OpAssign
+
+	OpArray
+	OpHash
+	OpIndex
+	// OpSetIndex pops a value, an index and an array or hash, stores the
+	// value and pushes it. Its operand is as for OpAssign.
+	OpSetIndex
+	OpInterpolate
+
+	OpCall
+	OpReturnValue
+	OpReturn
+	OpClosure
+
+	// OpIter replaces the value on top of the stack with an iterator over
+	// it. OpNext pushes the iterator's next element or, when there are no
+	// more, jumps.
+	OpIter
+	OpNext
+	// OpEnterScope gives the code that follows a new environment laid out
+	// as the given scope of the bytecode. OpLeaveScope returns to the
+	// environment it replaced.
+	OpEnterScope
+	OpLeaveScope
+
+	// OpQuote pops the values of the unquote calls in the quoted code that
+	// is the given constant and pushes a quote of the code with the values
+	// in their place.
+	OpQuote
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpAdd:          {"OpAdd", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpMod:          {"OpMod", []int{}},
+	OpBitAnd:       {"OpBitAnd", []int{}},
+	OpBitOr:        {"OpBitOr", []int{}},
+	OpBitXor:       {"OpBitXor", []int{}},
+	OpShiftLeft:    {"OpShiftLeft", []int{}},
+	OpShiftRight:   {"OpShiftRight", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpLessThan:     {"OpLessThan", []int{}},
+	OpGreaterThan:  {"OpGreaterThan", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+
+	OpMinus:  {"OpMinus", []int{}},
+	OpBang:   {"OpBang", []int{}},
+	OpBitNot: {"OpBitNot", []int{}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpAnd:           {"OpAnd", []int{2}},
+	OpOr:            {"OpOr", []int{2}},
+
+	OpGetVar: {"OpGetVar", []int{1, 2}},
+	OpDefine: {"OpDefine", []int{2}},
+	OpAssign: {"OpAssign", []int{1, 2, 1}},
+
+	OpArray:       {"OpArray", []int{2}},
+	OpHash:        {"OpHash", []int{2}},
+	OpIndex:       {"OpIndex", []int{}},
+	OpSetIndex:    {"OpSetIndex", []int{1}},
+	OpInterpolate: {"OpInterpolate", []int{2}},
+
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+	OpClosure:     {"OpClosure", []int{2}},
+
+	OpIter:       {"OpIter", []int{}},
+	OpNext:       {"OpNext", []int{2}},
+	OpEnterScope: {"OpEnterScope", []int{2}},
+	OpLeaveScope: {"OpLeaveScope", []int{}},
+
+	OpQuote: {"OpQuote", []int{2, 2}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
+// SourcePos records that the instructions from Offset on were compiled
+// from the code at Pos.
+type SourcePos struct {
+	Offset int
+	Pos    token.Position
+}
+
+// SourceMap relates instructions to source positions, so that runtime
+// errors can point at the code that caused them. Entries are in order of
+// Offset.
+type SourceMap []SourcePos
+
+// Lookup returns the source position of the instruction at offset.
+func (m SourceMap) Lookup(offset int) token.Position {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+	return m[i-1].Pos
+}
@@ -0,0 +1,125 @@
+package code
+
+import (
+	"monkey/token"
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpCall, []int{255}, []byte{byte(OpCall), 255}},
+		{OpGetVar, []int{2, 65534}, []byte{byte(OpGetVar), 2, 255, 254}},
+		{OpAssign, []int{1, 3, int(OpAdd)}, []byte{byte(OpAssign), 1, 0, 3, byte(OpAdd)}},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		if len(instruction) != len(tt.expected) {
+			t.Errorf("instruction has wrong length. want=%d, got=%d",
+				len(tt.expected), len(instruction))
+		}
+
+		for i, b := range tt.expected {
+			if instruction[i] != tt.expected[i] {
+				t.Errorf("wrong byte at pos %d. want=%d, got=%d",
+					i, b, instruction[i])
+			}
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	instructions := []Instructions{
+		Make(OpAdd),
+		Make(OpGetVar, 1, 2),
+		Make(OpConstant, 2),
+		Make(OpConstant, 65535),
+		Make(OpAssign, 0, 1, int(OpMul)),
+	}
+
+	expected := `0000 OpAdd
+0001 OpGetVar 1 2
+0005 OpConstant 2
+0008 OpConstant 65535
+0011 OpAssign 0 1 7
+`
+
+	concatted := Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q",
+			expected, concatted.String())
+	}
+}
+
+func TestReadOperands(t *testing.T) {
+	tests := []struct {
+		op        Opcode
+		operands  []int
+		bytesRead int
+	}{
+		{OpConstant, []int{65535}, 2},
+		{OpGetVar, []int{255, 65535}, 3},
+		{OpSetIndex, []int{4}, 1},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		def, err := Lookup(byte(tt.op))
+		if err != nil {
+			t.Fatalf("definition not found: %q\n", err)
+		}
+
+		operandsRead, n := ReadOperands(def, instruction[1:])
+		if n != tt.bytesRead {
+			t.Fatalf("n wrong. want=%d, got=%d", tt.bytesRead, n)
+		}
+
+		for i, want := range tt.operands {
+			if operandsRead[i] != want {
+				t.Errorf("operand wrong. want=%d, got=%d", want, operandsRead[i])
+			}
+		}
+	}
+}
+
+func TestSourceMapLookup(t *testing.T) {
+	m := SourceMap{
+		{Offset: 0, Pos: token.Position{Line: 1, Column: 1}},
+		{Offset: 4, Pos: token.Position{Line: 2, Column: 3}},
+		{Offset: 9, Pos: token.Position{Line: 3, Column: 5}},
+	}
+
+	tests := []struct {
+		offset int
+		line   int
+	}{
+		{0, 1},
+		{3, 1},
+		{4, 2},
+		{8, 2},
+		{9, 3},
+		{100, 3},
+	}
+
+	for _, tt := range tests {
+		if pos := m.Lookup(tt.offset); pos.Line != tt.line {
+			t.Errorf("Lookup(%d) wrong line. want=%d, got=%d", tt.offset, tt.line, pos.Line)
+		}
+	}
+
+	if pos := (SourceMap{}).Lookup(0); pos.IsValid() {
+		t.Errorf("empty map gave a position: %v", pos)
+	}
+}
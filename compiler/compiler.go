@@ -0,0 +1,711 @@
+// Package compiler lowers a parsed program to bytecode for the vm.
+package compiler
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/evaluator"
+	"monkey/object"
+	"monkey/resolver"
+	"monkey/token"
+)
+
+// Bytecode is a compiled program. Scopes holds the slot layout of each
+// for loop body, which OpEnterScope refers to by index. Like the constants,
+// they are those of the top-level code; each function has its own.
+// Globals names the slots of the global environment the program was
+// compiled for.
+type Bytecode struct {
+	Instructions code.Instructions
+	Positions    code.SourceMap
+	Constants    []object.Object
+	Scopes       []ast.Frame
+	Globals      []string
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// CompilationScope is the code of the function being compiled, or of the
+// program itself.
+type CompilationScope struct {
+	instructions    code.Instructions
+	positions       code.SourceMap
+	constants       []object.Object
+	layouts         []ast.Frame
+	lastInstruction EmittedInstruction
+
+	// depth is the number of values the code compiled so far leaves on
+	// the stack, so that break and continue know how many to drop.
+	depth int
+	loops []*loop
+}
+
+// loop is a loop being compiled. The operands of its break and continue
+// jumps are filled in once their targets are known.
+type loop struct {
+	depth     int
+	breaks    []int
+	continues []int
+}
+
+type Compiler struct {
+	globals *object.Environment
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	pos token.Position
+
+	// err is the first operand that did not fit in an instruction. Compile
+	// returns it once the node that caused it is done.
+	err error
+}
+
+// New returns a compiler whose top-level variables live in globals, the
+// environment the vm will run the program in.
+func New(globals *object.Environment) *Compiler {
+	return &Compiler{
+		globals: globals,
+		scopes:  []CompilationScope{{}},
+	}
+}
+
+var infixOpcodes = map[string]code.Opcode{
+	token.PLUS:     code.OpAdd,
+	token.MINUS:    code.OpSub,
+	token.ASTERISK: code.OpMul,
+	token.SLASH:    code.OpDiv,
+	token.PERCENT:  code.OpMod,
+	token.BIT_AND:  code.OpBitAnd,
+	token.BIT_OR:   code.OpBitOr,
+	token.BIT_XOR:  code.OpBitXor,
+	token.SHL:      code.OpShiftLeft,
+	token.SHR:      code.OpShiftRight,
+	token.EQ:       code.OpEqual,
+	token.NOT_EQ:   code.OpNotEqual,
+	token.LT:       code.OpLessThan,
+	token.GT:       code.OpGreaterThan,
+	token.LT_EQ:    code.OpLessEqual,
+	token.GT_EQ:    code.OpGreaterEqual,
+}
+
+var prefixOpcodes = map[string]code.Opcode{
+	token.MINUS:   code.OpMinus,
+	token.BANG:    code.OpBang,
+	token.BIT_NOT: code.OpBitNot,
+}
+
+func (c *Compiler) Compile(node ast.Node) (err error) {
+	outer := c.pos
+	c.pos = node.Pos()
+	defer func() {
+		c.pos = outer
+		if err == nil {
+			err = c.err
+		}
+	}()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		resolver.Resolve(node, c.globals)
+
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+		// The value of the program is that of its last statement, if it
+		// is an expression.
+		if endsWithExpression(node.Statements) {
+			c.replaceLastPopWithReturn()
+		} else {
+			c.emit(code.OpReturn)
+		}
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+	case *ast.LetStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpDefine, node.Name.Binding.Slot)
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+	case *ast.WhileStatement:
+		return c.compileWhileStatement(node)
+	case *ast.ForStatement:
+		return c.compileForStatement(node)
+	case *ast.BreakStatement:
+		l := c.innermostLoop()
+		if l == nil {
+			return fmt.Errorf("break outside of a loop")
+		}
+		l.breaks = append(l.breaks, c.emitLoopJump(l))
+	case *ast.ContinueStatement:
+		l := c.innermostLoop()
+		if l == nil {
+			return fmt.Errorf("continue outside of a loop")
+		}
+		l.continues = append(l.continues, c.emitLoopJump(l))
+	case *ast.Identifier:
+		if !node.Binding.Resolved {
+			return fmt.Errorf("unresolved identifier %s", node.Value)
+		}
+		c.emit(code.OpGetVar, node.Binding.Depth, node.Binding.Slot)
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+	case *ast.BigIntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.BigInt{Value: node.Value}))
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			if err := c.Compile(part); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpInterpolate, len(node.Parts))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *ast.PrefixExpression:
+		op, ok := prefixOpcodes[node.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(op)
+	case *ast.InfixExpression:
+		return c.compileInfixExpression(node)
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
+	case *ast.IfExpression:
+		return c.compileIfExpression(node)
+	case *ast.FunctionLiteral:
+		return c.compileFunctionLiteral(node)
+	case *ast.MacroLiteral:
+		return fmt.Errorf("%s: macros can only be defined by top-level let statements", node.Pos())
+	case *ast.CallExpression:
+		if evaluator.IsQuoteCall(node) {
+			return c.compileQuote(node)
+		}
+
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			if err := c.Compile(pair.Key); err != nil {
+				return err
+			}
+			if err := c.Compile(pair.Value); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs)*2)
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+	case *ast.BadStatement:
+		return fmt.Errorf("%s: cannot compile statement with syntax errors", node.Pos())
+	default:
+		return fmt.Errorf("cannot compile %T", node)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileInfixExpression(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	if node.Operator == token.AND || node.Operator == token.OR {
+		op := code.OpAnd
+		if node.Operator == token.OR {
+			op = code.OpOr
+		}
+
+		jumpPos := c.emit(op, 9999)
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+		return nil
+	}
+
+	op, ok := infixOpcodes[node.Operator]
+	if !ok {
+		return fmt.Errorf("unknown operator %s", node.Operator)
+	}
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	c.emit(op)
+
+	return nil
+}
+
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	// The operand of OpAssign and OpSetIndex is zero for a plain
+	// assignment, which OpConstant never is.
+	operator := 0
+	if node.Operator != token.ASSIGN {
+		op, ok := infixOpcodes[compoundOperator(node.Operator)]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		operator = int(op)
+	}
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		if !target.Binding.Resolved {
+			return fmt.Errorf("unresolved identifier %s", target.Value)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		c.pos = target.Pos()
+		c.emit(code.OpAssign, target.Binding.Depth, target.Binding.Slot, operator)
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		c.pos = target.Index.Pos()
+		c.emit(code.OpSetIndex, operator)
+	default:
+		return fmt.Errorf("cannot assign to %s", node.Target.String())
+	}
+
+	return nil
+}
+
+// compoundOperator returns the infix operator a compound assignment
+// applies, such as + for +=.
+func compoundOperator(operator string) string {
+	return operator[:len(operator)-1]
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	// Only one of the branches runs.
+	c.scopes[c.scopeIndex].depth--
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBlockValue(node.Alternative); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	return nil
+}
+
+// compileBlockValue compiles a block whose value is used, leaving the value
+// of its last expression on the stack, or null if it does not end with an
+// expression.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+
+	if endsWithExpression(block.Statements) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileWhileStatement(node *ast.WhileStatement) error {
+	start := len(c.currentInstructions())
+	l := c.enterLoop()
+
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	exitPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+
+	end := len(c.currentInstructions())
+	c.changeOperand(exitPos, end)
+	c.leaveLoop(l, end, start)
+
+	return nil
+}
+
+// compileForStatement compiles a for loop so that each iteration runs in a
+// new environment, like the evaluator does:
+//
+//	        <iterable>
+//	        OpIter
+//	start:  OpNext exit
+//	        OpEnterScope
+//	        OpDefine <variable>
+//	        <body>
+//	cont:   OpLeaveScope
+//	        OpJump start
+//	brk:    OpLeaveScope
+//	exit:   OpPop
+func (c *Compiler) compileForStatement(node *ast.ForStatement) error {
+	if err := c.Compile(node.Iterable); err != nil {
+		return err
+	}
+	c.pos = node.Iterable.Pos()
+	c.emit(code.OpIter)
+	c.pos = node.Pos()
+
+	start := len(c.currentInstructions())
+	nextPos := c.emit(code.OpNext, 9999)
+	c.emit(code.OpEnterScope, c.addScope(node.Locals))
+	c.emit(code.OpDefine, node.Variable.Binding.Slot)
+
+	l := c.enterLoop()
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+
+	cont := c.emit(code.OpLeaveScope)
+	c.emit(code.OpJump, start)
+	brk := c.emit(code.OpLeaveScope)
+	exit := c.emit(code.OpPop)
+
+	c.changeOperand(nextPos, exit)
+	c.leaveLoop(l, brk, cont)
+
+	return nil
+}
+
+// compileQuote compiles quote(x) to the values of the unquote calls in x,
+// followed by OpQuote with x as a constant.
+func (c *Compiler) compileQuote(node *ast.CallExpression) error {
+	if len(node.Arguments) != 1 {
+		return fmt.Errorf("%s: wrong number of arguments. got=%d, want=1", node.Pos(), len(node.Arguments))
+	}
+
+	args := evaluator.UnquoteArguments(node.Arguments[0])
+	for _, arg := range args {
+		if err := c.Compile(arg); err != nil {
+			return err
+		}
+	}
+
+	template := &object.Quote{Node: node.Arguments[0]}
+	c.emit(code.OpQuote, c.addConstant(template), len(args))
+
+	return nil
+}
+
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) error {
+	c.enterScope()
+
+	// The arguments are on the stack, the last one on top. Of parameters
+	// with the same name, the last one wins.
+	c.scopes[c.scopeIndex].depth = len(node.Parameters)
+	defined := make(map[int]bool, len(node.Parameters))
+	for i := len(node.Parameters) - 1; i >= 0; i-- {
+		if slot := node.Parameters[i].Binding.Slot; !defined[slot] {
+			c.emit(code.OpDefine, slot)
+			defined[slot] = true
+		} else {
+			c.emit(code.OpPop)
+		}
+	}
+
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+
+	if endsWithExpression(node.Body.Statements) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	scope := c.leaveScope()
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  scope.instructions,
+		Positions:     scope.positions,
+		Constants:     scope.constants,
+		Scopes:        scope.layouts,
+		Locals:        node.Locals,
+		NumParameters: len(node.Parameters),
+	}
+	c.emit(code.OpClosure, c.addConstant(compiledFn))
+
+	return nil
+}
+
+func endsWithExpression(statements []ast.Statement) bool {
+	if len(statements) == 0 {
+		return false
+	}
+	_, ok := statements[len(statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	scope := c.scopes[c.scopeIndex]
+	return &Bytecode{
+		Instructions: scope.instructions,
+		Positions:    scope.positions,
+		Constants:    scope.constants,
+		Scopes:       scope.layouts,
+		Globals:      c.globals.Slots(),
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	scope := &c.scopes[c.scopeIndex]
+	scope.constants = append(scope.constants, obj)
+	return len(scope.constants) - 1
+}
+
+func (c *Compiler) addScope(locals ast.Frame) int {
+	scope := &c.scopes[c.scopeIndex]
+	scope.layouts = append(scope.layouts, locals)
+	return len(scope.layouts) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.lastInstruction = EmittedInstruction{Opcode: op, Position: pos}
+	scope.depth += stackEffect(op, operands)
+
+	if n := len(scope.positions); n == 0 || scope.positions[n-1].Pos != c.pos {
+		scope.positions = append(scope.positions, code.SourcePos{Offset: pos, Pos: c.pos})
+	}
+
+	return pos
+}
+
+// stackEffect returns by how much an instruction changes the number of
+// values on the stack. For a conditional jump it is the change when the
+// jump is not taken.
+func stackEffect(op code.Opcode, operands []int) int {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetVar, code.OpClosure, code.OpNext:
+		return 1
+	case code.OpPop, code.OpJumpNotTruthy, code.OpAnd, code.OpOr,
+		code.OpDefine, code.OpIndex, code.OpReturnValue,
+		code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight,
+		code.OpEqual, code.OpNotEqual, code.OpLessThan, code.OpGreaterThan,
+		code.OpLessEqual, code.OpGreaterEqual:
+		return -1
+	case code.OpSetIndex:
+		return -2
+	case code.OpArray, code.OpHash, code.OpInterpolate:
+		return 1 - operands[0]
+	case code.OpQuote:
+		return 1 - operands[1]
+	case code.OpCall:
+		return -operands[0]
+	default:
+		return 0
+	}
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	return posNewInstruction
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+// removeLastPop drops the OpPop of the expression statement just compiled,
+// so that its value stays on the stack.
+func (c *Compiler) removeLastPop() {
+	scope := &c.scopes[c.scopeIndex]
+	end := scope.lastInstruction.Position
+
+	scope.instructions = scope.instructions[:end]
+	for n := len(scope.positions); n > 0 && scope.positions[n-1].Offset >= end; n-- {
+		scope.positions = scope.positions[:n-1]
+	}
+	scope.depth++
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	copy(ins[pos:], newInstruction)
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	def, _ := code.Lookup(byte(op))
+
+	operands, _ := code.ReadOperands(def, c.currentInstructions()[opPos+1:])
+	operands[0] = operand
+	c.checkOperands(op, operands)
+
+	c.replaceInstruction(opPos, code.Make(op, operands...))
+}
+
+// checkOperands records an error if one of operands does not fit in the
+// bytes op has for it, rather than letting Make cut it short.
+func (c *Compiler) checkOperands(op code.Opcode, operands []int) {
+	def, _ := code.Lookup(byte(op))
+	for i, width := range def.OperandWidths {
+		if limit := 1 << (8 * width); operands[i] < 0 || operands[i] >= limit {
+			if c.err == nil {
+				c.err = fmt.Errorf("%s: program too large: operand %d of %s is %d, the most it can be is %d",
+					c.pos, i, def.Name, operands[i], limit-1)
+			}
+			return
+		}
+	}
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{})
+	c.scopeIndex++
+}
+
+func (c *Compiler) leaveScope() CompilationScope {
+	scope := c.scopes[c.scopeIndex]
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	return scope
+}
+
+func (c *Compiler) enterLoop() *loop {
+	scope := &c.scopes[c.scopeIndex]
+	l := &loop{depth: scope.depth}
+	scope.loops = append(scope.loops, l)
+	return l
+}
+
+// leaveLoop points the loop's break jumps at brk and its continue jumps at
+// cont.
+func (c *Compiler) leaveLoop(l *loop, brk, cont int) {
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, brk)
+	}
+	for _, pos := range l.continues {
+		c.changeOperand(pos, cont)
+	}
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+}
+
+func (c *Compiler) innermostLoop() *loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// emitLoopJump drops the values the enclosing expressions have left on the
+// stack since the loop body started and emits a jump for break or continue
+// to fill in. It returns the position of the jump.
+func (c *Compiler) emitLoopJump(l *loop) int {
+	scope := &c.scopes[c.scopeIndex]
+	depth := scope.depth
+
+	for i := l.depth; i < depth; i++ {
+		c.emit(code.OpPop)
+	}
+	pos := c.emit(code.OpJump, 9999)
+
+	// The code after the jump runs, if at all, with the stack as it was.
+	scope.depth = depth
+
+	return pos
+}
@@ -0,0 +1,878 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+// compiledFunction is an expected function constant that has constants of
+// its own.
+type compiledFunction struct {
+	instructions []code.Instructions
+	constants    []interface{}
+}
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []interface{}
+	expectedInstructions []code.Instructions
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "1; 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "2 < 1 << 3",
+			expectedConstants: []interface{}{2, 1, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpShiftLeft),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "-1 % ~2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBitNot),
+				code.Make(code.OpMod),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             `1.5 * "a"`,
+			expectedConstants: []interface{}{1.5, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true && false || !true",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpAnd, 5),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpOr, 10),
+				// 0008
+				code.Make(code.OpTrue),
+				// 0009
+				code.Make(code.OpBang),
+				// 0010
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "if (true) { let a = 1 } else { 20 }",
+			expectedConstants: []interface{}{1, 20},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpDefine, 0),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpJump, 17),
+				// 0014
+				code.Make(code.OpConstant, 1),
+				// 0017
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestVariables(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let one = 1; let two = one; two",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDefine, 0),
+				code.Make(code.OpGetVar, 0, 0),
+				code.Make(code.OpDefine, 1),
+				code.Make(code.OpGetVar, 0, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "let x = 1; x += 2; x = 3",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDefine, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAssign, 0, 0, int(code.OpAdd)),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAssign, 0, 0, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "len",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetVar, 0, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCollections(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[1, 2][0] = {2: 3}",
+			expectedConstants: []interface{}{1, 2, 0, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpSetIndex, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             `"a${1}"[0]`,
+			expectedConstants: []interface{}{"a", 1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpInterpolate, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(a, b) { a + b }(1, 2)",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpDefine, 1),
+					code.Make(code.OpDefine, 0),
+					code.Make(code.OpGetVar, 0, 0),
+					code.Make(code.OpGetVar, 0, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				2,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input: "fn() { }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input: "fn(a) { fn() { return a; } }",
+			expectedConstants: []interface{}{
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpDefine, 0),
+						code.Make(code.OpClosure, 0),
+						code.Make(code.OpReturnValue),
+					},
+					constants: []interface{}{
+						[]code.Instructions{
+							code.Make(code.OpGetVar, 1, 0),
+							code.Make(code.OpReturnValue),
+						},
+					},
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input: "fn(a, a) { a }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpDefine, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetVar, 0, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { break; }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpJump, 10),
+				// 0007
+				code.Make(code.OpJump, 0),
+				// 0010
+				code.Make(code.OpReturn),
+			},
+		},
+		{
+			input:             "for (x in [1]) { x + if (true) { continue } }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIter),
+				// 0007
+				code.Make(code.OpNext, 40),
+				// 0010
+				code.Make(code.OpEnterScope, 0),
+				// 0013
+				code.Make(code.OpDefine, 0),
+				// 0016
+				code.Make(code.OpGetVar, 0, 0),
+				// 0020
+				code.Make(code.OpTrue),
+				// 0021
+				code.Make(code.OpJumpNotTruthy, 32),
+				// 0024: continue drops x, the left operand of +
+				code.Make(code.OpPop),
+				// 0025
+				code.Make(code.OpJump, 35),
+				// 0028
+				code.Make(code.OpNull),
+				// 0029
+				code.Make(code.OpJump, 33),
+				// 0032
+				code.Make(code.OpNull),
+				// 0033
+				code.Make(code.OpAdd),
+				// 0034
+				code.Make(code.OpPop),
+				// 0035
+				code.Make(code.OpLeaveScope),
+				// 0036
+				code.Make(code.OpJump, 7),
+				// 0039
+				code.Make(code.OpLeaveScope),
+				// 0040
+				code.Make(code.OpPop),
+				// 0041
+				code.Make(code.OpReturn),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCompilerScopes(t *testing.T) {
+	program := parse("for (i in [1]) { let j = i } fn(a, a) { let b = a }")
+
+	compiler := New(object.NewEnvironment())
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	if len(bytecode.Scopes) != 1 {
+		t.Fatalf("wrong number of scopes. want=1, got=%d", len(bytecode.Scopes))
+	}
+	if got := fmt.Sprint(bytecode.Scopes[0]); got != "[i j]" {
+		t.Errorf("wrong loop scope. want=[i j], got=%s", got)
+	}
+
+	fn := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction)
+	if fn.NumParameters != 2 {
+		t.Errorf("wrong NumParameters. want=2, got=%d", fn.NumParameters)
+	}
+	if got := fmt.Sprint(fn.Locals); got != "[a b]" {
+		t.Errorf("wrong Locals. want=[a b], got=%s", got)
+	}
+}
+
+func TestSourcePositions(t *testing.T) {
+	program := parse("let x = 1;\nx + y")
+
+	compiler := New(object.NewEnvironment())
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	tests := []struct {
+		offset int
+		line   int
+		column int
+	}{
+		{0, 1, 9},  // OpConstant 1
+		{3, 1, 1},  // OpDefine x
+		{6, 2, 1},  // OpGetVar x
+		{10, 2, 5}, // OpGetVar y
+		{14, 2, 3}, // OpAdd
+	}
+
+	for _, tt := range tests {
+		pos := bytecode.Positions.Lookup(tt.offset)
+		if pos.Line != tt.line || pos.Column != tt.column {
+			t.Errorf("wrong position for offset %d. want=%d:%d, got=%d:%d",
+				tt.offset, tt.line, tt.column, pos.Line, pos.Column)
+		}
+	}
+}
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	program := parse(`let big = 99999999999999999999;
+let f = fn(a, b) {
+  let s = "";
+  for (i in range(a)) { s += "${i}" }
+  fn() { s + b + 1.5 + big }
+};
+puts(f(3, "x")());
+let q = fn(x) { quote(unquote(x) + {"k": [y, 2]}) };
+puts(q(1))`)
+
+	compiler := New(object.NewEnvironment())
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	want := compiler.Bytecode()
+
+	data, err := want.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %s", err)
+	}
+
+	var got Bytecode
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary error: %s", err)
+	}
+
+	if fmt.Sprint(got.Globals) != fmt.Sprint(want.Globals) {
+		t.Errorf("wrong globals. want=%v, got=%v", want.Globals, got.Globals)
+	}
+
+	if err := testSameFunction(topLevel(want), topLevel(&got)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnmarshalBinaryErrors(t *testing.T) {
+	compiler := New(object.NewEnvironment())
+	if err := compiler.Compile(parse(`let x = "hello"; x`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	data, err := compiler.Bytecode().MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %s", err)
+	}
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)/2] ^= 0xff
+
+	otherVersion := append([]byte(nil), data...)
+	otherVersion[5]++
+
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("let x = 1;"), "not a compiled monkey program"},
+		{data[:6], "not a compiled monkey program"},
+		{otherVersion, fmt.Sprintf("unsupported format version %d, want %d", FormatVersion+1, FormatVersion)},
+		{corrupt, "checksum mismatch, the file is corrupt"},
+		{data[:len(data)-1], "checksum mismatch, the file is corrupt"},
+	}
+
+	for i, tt := range tests {
+		var b Bytecode
+		err := b.UnmarshalBinary(tt.data)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("tests[%d]: wrong error. want=%q, got=%v", i, tt.expected, err)
+		}
+	}
+}
+
+func TestUnmarshalBinaryChecksInstructions(t *testing.T) {
+	concat := func(parts ...[]byte) code.Instructions {
+		var ins code.Instructions
+		for _, part := range parts {
+			ins = append(ins, part...)
+		}
+		return ins
+	}
+	str := &object.String{Value: "s"}
+
+	tests := []struct {
+		bytecode *Bytecode
+		expected string
+	}{
+		{&Bytecode{Instructions: code.Instructions{255}}, "at 0: opcode 255 undefined"},
+		{&Bytecode{Instructions: code.Make(code.OpConstant, 0)[:2], Constants: []object.Object{str}},
+			"at 0: OpConstant is missing operands"},
+		{&Bytecode{Instructions: code.Make(code.OpConstant, 1), Constants: []object.Object{str}},
+			"at 0: OpConstant refers to constant 1 of 1"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpClosure, 0)), Constants: []object.Object{str}},
+			"at 1: OpClosure constant 0 is not a function"},
+		{&Bytecode{Instructions: code.Make(code.OpEnterScope, 0)}, "at 0: OpEnterScope refers to scope 0 of 0"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpJump, 4), code.Make(code.OpConstant, 0)), Constants: []object.Object{str}},
+			"at 0: jump to 4, which is not the start of an instruction"},
+		{&Bytecode{Constants: []object.Object{&object.CompiledFunction{Instructions: code.Instructions{255}}}},
+			"at 0: opcode 255 undefined"},
+		{&Bytecode{Instructions: code.Make(code.OpQuote, 0, 1), Constants: []object.Object{&object.Quote{Node: parse("a").Statements[0].(*ast.ExpressionStatement).Expression}}},
+			"at 0: OpQuote takes 1 values for 0 unquote calls"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpAssign, 0, 0, 255)), Globals: []string{"x"}},
+			"at 1: OpAssign has unknown operator 255"},
+		{&Bytecode{Instructions: code.Make(code.OpHash, 1)}, "at 0: OpHash of 1 values, which do not make pairs"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpAdd))},
+			"at 1: OpAdd takes 2 values from a stack of 1"},
+		{&Bytecode{Instructions: code.Make(code.OpGetVar, 0, 1), Globals: []string{"x"}},
+			"at 0: OpGetVar refers to slot 1 of 1"},
+		{&Bytecode{Instructions: code.Make(code.OpGetVar, 1, 0), Globals: []string{"x"}},
+			"at 0: OpGetVar refers to environment 1 of 1"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpDefine, 1)), Globals: []string{"x"}},
+			"at 1: OpDefine refers to slot 1 of 1"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpEnterScope, 0), code.Make(code.OpGetVar, 0, 1)), Scopes: []ast.Frame{{"i"}}, Globals: []string{"x", "y"}},
+			"at 3: OpGetVar refers to slot 1 of 1"},
+		{&Bytecode{Instructions: code.Make(code.OpLeaveScope)}, "at 0: OpLeaveScope outside of a scope"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpJumpNotTruthy, 5), code.Make(code.OpTrue), code.Make(code.OpNull))},
+			"at 5: reached with 0 and with 1 values on the stack"},
+		{&Bytecode{
+			Instructions: concat(code.Make(code.OpClosure, 0), code.Make(code.OpPop)),
+			Constants: []object.Object{&object.CompiledFunction{
+				Instructions: concat(code.Make(code.OpGetVar, 2, 0), code.Make(code.OpReturnValue)),
+				Locals:       ast.Frame{"a"},
+			}},
+			Globals: []string{"x"},
+		}, "at 0: OpGetVar refers to environment 2 of 2"},
+		{&Bytecode{Constants: []object.Object{&object.CompiledFunction{
+			Instructions:  concat(code.Make(code.OpDefine, 0), code.Make(code.OpDefine, 1)),
+			Locals:        ast.Frame{"a"},
+			NumParameters: 2,
+		}}}, "at 3: OpDefine refers to slot 1 of 1"},
+	}
+
+	for i, tt := range tests {
+		data, err := tt.bytecode.MarshalBinary()
+		if err != nil {
+			t.Fatalf("tests[%d]: MarshalBinary error: %s", i, err)
+		}
+
+		var b Bytecode
+		err = b.UnmarshalBinary(data)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("tests[%d]: wrong error. want=%q, got=%v", i, tt.expected, err)
+		}
+	}
+
+	// Jumping just past the last instruction ends the code.
+	data, _ := (&Bytecode{Instructions: code.Make(code.OpJump, 3)}).MarshalBinary()
+	var b Bytecode
+	if err := b.UnmarshalBinary(data); err != nil {
+		t.Errorf("jump to the end rejected: %s", err)
+	}
+}
+
+func TestUnmarshalBinaryCorruptFields(t *testing.T) {
+	data, err := (&Bytecode{
+		Instructions: append(code.Make(code.OpConstant, 0), code.Make(code.OpPop)...),
+		Constants:    []object.Object{&object.Integer{Value: 7}},
+		Globals:      []string{"x"},
+	}).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %s", err)
+	}
+
+	// The fields after the magic and version, by offset:
+	//
+	//	 6 number of globals, 7 length of the first, 8 "x"
+	//	 9 length of the code, 10 OpConstant 0, 13 OpPop
+	//	14 number of positions
+	//	15 number of constants, 16 tag of the first, 17 7
+	//	18 number of scopes, 19 number of locals, 20 number of parameters
+	body := data[:len(data)-4]
+	if len(body) != 21 {
+		t.Fatalf("unexpected layout. got=%v", body)
+	}
+
+	tests := []struct {
+		at, length int
+		with       []byte
+		expected   string
+	}{
+		{6, 1, []byte{0x7f}, "unexpected end of data"},
+		{7, 1, []byte{0x7f}, "unexpected end of data"},
+		{9, 1, []byte{0x7f}, "unexpected end of data"},
+		{10, 1, []byte{255}, "at 0: opcode 255 undefined"},
+		{12, 1, []byte{1}, "at 0: OpConstant refers to constant 1 of 1"},
+		{13, 1, []byte{byte(code.OpAdd)}, "at 3: OpAdd takes 2 values from a stack of 1"},
+		{13, 1, []byte{byte(code.OpDefine)}, "at 3: OpDefine is missing operands"},
+		{15, 1, []byte{0x7f}, "unexpected end of data"},
+		{16, 1, []byte{'z'}, "unknown constant tag 'z'"},
+		{16, 2, []byte("q\x04null"), "quoted code is not an expression"},
+		{16, 2, []byte("q\x01{"), "malformed quoted code: unexpected EOF"},
+		{18, 1, []byte{0x7f}, "unexpected end of data"},
+		{19, 1, []byte{1, 0}, "the top-level code has parameters"},
+		{20, 1, []byte{1}, "the top-level code has parameters"},
+		{20, 1, []byte{0x80}, "malformed number"},
+		{20, 1, []byte{0, 0}, "1 bytes of trailing data"},
+	}
+
+	for i, tt := range tests {
+		corrupt := append([]byte(nil), body[:tt.at]...)
+		corrupt = append(corrupt, tt.with...)
+		corrupt = append(corrupt, body[tt.at+tt.length:]...)
+		corrupt = binary.BigEndian.AppendUint32(corrupt, crc32.ChecksumIEEE(corrupt))
+
+		var b Bytecode
+		err := b.UnmarshalBinary(corrupt)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("tests[%d]: wrong error. want=%q, got=%v", i, tt.expected, err)
+		}
+	}
+}
+
+func TestOperandsMustFit(t *testing.T) {
+	args := make([]string, 300)
+	for i := range args {
+		args[i] = fmt.Sprint(i)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let s = 0;" + strings.Repeat(" s += 1;", 70000),
+			"1:524297: program too large: operand 0 of OpConstant is 65536, the most it can be is 65535"},
+		{"f(" + strings.Join(args, ", ") + ")",
+			"1:2: program too large: operand 0 of OpCall is 300, the most it can be is 255"},
+	}
+
+	for _, tt := range tests {
+		compiler := New(object.NewEnvironment())
+		err := compiler.Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	input := `let f = fn(a) {
+  for (i in [a]) { fn() { i + a } }
+};
+f(1);
+let g = fn(b, b) { b };`
+
+	compiler := New(object.NewEnvironment())
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out bytes.Buffer
+	Disassemble(&out, compiler.Bytecode(), input)
+
+	expected := []string{
+		"globals: f g\n",
+		"     0  COMPILED_FUNCTION fn 0\n",
+		"  0010  4:3     OpConstant 1           ; 1\n",
+		"== fn 0 (a) at 1:9 ==\n",
+		"           2 |   for (i in [a]) { fn() { i + a } }\n",
+		"  0014  2:3     OpEnterScope 0         ; [i]\n",
+		"  0020  2:20    OpClosure 0            ; fn 0.0\n",
+		"  0030  1:9     OpReturn\n",
+		"== fn 0.0 () at 2:27 ==\n",
+		"  0000  2:27    OpGetVar 1 0           ; i\n",
+		"  0004  2:31    OpGetVar 2 0           ; a\n",
+		"== fn 2 (b) at 5:9 ==\n",
+	}
+
+	for _, want := range expected {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("listing does not contain %q. got=\n%s", want, out.String())
+		}
+	}
+}
+
+func topLevel(b *Bytecode) *object.CompiledFunction {
+	return &object.CompiledFunction{
+		Instructions: b.Instructions,
+		Positions:    b.Positions,
+		Constants:    b.Constants,
+		Scopes:       b.Scopes,
+	}
+}
+
+// testSameFunction compares a function with its decoded copy.
+func testSameFunction(want, got *object.CompiledFunction) error {
+	if got.Instructions.String() != want.Instructions.String() {
+		return fmt.Errorf("wrong instructions.\nwant=%q\ngot =%q", want.Instructions, got.Instructions)
+	}
+
+	for _, field := range []struct {
+		name      string
+		want, got interface{}
+	}{
+		{"positions", want.Positions, got.Positions},
+		{"scopes", want.Scopes, got.Scopes},
+		{"locals", want.Locals, got.Locals},
+		{"parameters", want.NumParameters, got.NumParameters},
+	} {
+		if fmt.Sprint(field.got) != fmt.Sprint(field.want) {
+			return fmt.Errorf("wrong %s. want=%v, got=%v", field.name, field.want, field.got)
+		}
+	}
+
+	if len(got.Constants) != len(want.Constants) {
+		return fmt.Errorf("wrong number of constants. want=%d, got=%d", len(want.Constants), len(got.Constants))
+	}
+	for i, c := range want.Constants {
+		if c.Type() != got.Constants[i].Type() {
+			return fmt.Errorf("constant %d - wrong type. want=%s, got=%s", i, c.Type(), got.Constants[i].Type())
+		}
+
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			if err := testSameFunction(fn, got.Constants[i].(*object.CompiledFunction)); err != nil {
+				return fmt.Errorf("constant %d - %s", i, err)
+			}
+		} else if c.Inspect() != got.Constants[i].Inspect() {
+			return fmt.Errorf("constant %d - wrong value. want=%s, got=%s", i, c.Inspect(), got.Constants[i].Inspect())
+		}
+	}
+
+	return nil
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New(object.NewEnvironment())
+		err := compiler.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		err = testInstructions(tt.expectedInstructions, bytecode.Instructions)
+		if err != nil {
+			t.Fatalf("%s: testInstructions failed: %s", tt.input, err)
+		}
+
+		err = testConstants(tt.expectedConstants, bytecode.Constants)
+		if err != nil {
+			t.Fatalf("%s: testConstants failed: %s", tt.input, err)
+		}
+	}
+}
+
+func concatInstructions(s []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+
+	return out
+}
+
+func testInstructions(expected []code.Instructions, actual code.Instructions) error {
+	concatted := concatInstructions(expected)
+
+	if len(actual) != len(concatted) {
+		return fmt.Errorf("wrong instructions length.\nwant=%q\ngot =%q",
+			concatted, actual)
+	}
+
+	for i, ins := range concatted {
+		if actual[i] != ins {
+			return fmt.Errorf("wrong instruction at %d.\nwant=%q\ngot =%q",
+				i, concatted, actual)
+		}
+	}
+
+	return nil
+}
+
+func testConstants(expected []interface{}, actual []object.Object) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("wrong number of constants. got=%d, want=%d",
+			len(actual), len(expected))
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			result, ok := actual[i].(*object.Integer)
+			if !ok || result.Value != int64(constant) {
+				return fmt.Errorf("constant %d - wrong integer. want=%d, got=%s", i, constant, actual[i].Inspect())
+			}
+		case float64:
+			result, ok := actual[i].(*object.Float)
+			if !ok || result.Value != constant {
+				return fmt.Errorf("constant %d - wrong float. want=%g, got=%s", i, constant, actual[i].Inspect())
+			}
+		case string:
+			result, ok := actual[i].(*object.String)
+			if !ok || result.Value != constant {
+				return fmt.Errorf("constant %d - wrong string. want=%q, got=%s", i, constant, actual[i].Inspect())
+			}
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				return fmt.Errorf("constant %d - not a function: %T", i, actual[i])
+			}
+
+			if err := testInstructions(constant, fn.Instructions); err != nil {
+				return fmt.Errorf("constant %d - testInstructions failed: %s", i, err)
+			}
+		case compiledFunction:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				return fmt.Errorf("constant %d - not a function: %T", i, actual[i])
+			}
+
+			if err := testInstructions(constant.instructions, fn.Instructions); err != nil {
+				return fmt.Errorf("constant %d - testInstructions failed: %s", i, err)
+			}
+			if err := testConstants(constant.constants, fn.Constants); err != nil {
+				return fmt.Errorf("constant %d - %s", i, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package compiler
+
+import (
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/object"
+	"strconv"
+	"strings"
+)
+
+// Disassemble writes a listing of b to w: the constants and instructions of
+// the top-level code and then of each function, in the order they appear.
+// Operands are decoded into the constants, variable names and operators they
+// stand for, and each instruction is shown with the source position it was
+// compiled from. If source is given, the line an instruction comes from is
+// printed above it.
+func Disassemble(w io.Writer, b *Bytecode, source string) {
+	d := &disassembler{out: w}
+	if source != "" {
+		d.lines = strings.Split(source, "\n")
+	}
+
+	fmt.Fprintf(w, "globals: %s\n", strings.Join(b.Globals, " "))
+	d.function("main", &object.CompiledFunction{
+		Instructions: b.Instructions,
+		Positions:    b.Positions,
+		Constants:    b.Constants,
+		Scopes:       b.Scopes,
+	}, []ast.Frame{b.Globals})
+}
+
+type disassembler struct {
+	out   io.Writer
+	lines []string
+}
+
+// function lists fn, whose variables live in env, innermost frame first,
+// and then the functions among its constants.
+func (d *disassembler) function(name string, fn *object.CompiledFunction, env []ast.Frame) {
+	fmt.Fprintf(d.out, "\n== %s", name)
+	if name != "main" {
+		// Parameters that share a name share a local.
+		params := fn.Locals[:min(fn.NumParameters, len(fn.Locals))]
+		fmt.Fprintf(d.out, " (%s)", strings.Join(params, ", "))
+		if len(fn.Positions) > 0 {
+			fmt.Fprintf(d.out, " at %s", fn.Positions[0].Pos)
+		}
+	}
+	fmt.Fprintln(d.out, " ==")
+
+	if len(fn.Constants) > 0 {
+		fmt.Fprintln(d.out, "constants:")
+		for i, c := range fn.Constants {
+			fmt.Fprintf(d.out, "  %4d  %-17s %s\n", i, c.Type(), d.constant(name, i, c))
+		}
+	}
+
+	// The environment of each function is the one it is created in.
+	closures := make(map[int][]ast.Frame)
+
+	fmt.Fprintln(d.out, "code:")
+	ins := fn.Instructions
+	line := 0
+	for ip := 0; ip < len(ins); {
+		def, err := code.Lookup(ins[ip])
+		if err != nil {
+			fmt.Fprintf(d.out, "  %04d  ERROR: %s\n", ip, err)
+			ip++
+			continue
+		}
+		operands, read := code.ReadOperands(def, ins[ip+1:])
+
+		pos := fn.Positions.Lookup(ip)
+		where := ""
+		if pos.IsValid() {
+			where = fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+			if pos.Line != line && pos.Line <= len(d.lines) {
+				fmt.Fprintf(d.out, "        %4d | %s\n", pos.Line, strings.TrimRight(d.lines[pos.Line-1], "\r"))
+			}
+			line = pos.Line
+		}
+
+		text := def.Name
+		for _, o := range operands {
+			text += " " + strconv.Itoa(o)
+		}
+
+		var comment string
+		switch code.Opcode(ins[ip]) {
+		case code.OpConstant, code.OpQuote:
+			comment = d.constant(name, operands[0], fn.Constants[operands[0]])
+		case code.OpClosure:
+			comment = d.constant(name, operands[0], fn.Constants[operands[0]])
+			closures[operands[0]] = env
+		case code.OpGetVar:
+			comment = variable(env, operands[0], operands[1])
+		case code.OpDefine:
+			comment = variable(env, 0, operands[0])
+		case code.OpAssign:
+			comment = variable(env, operands[0], operands[1]) + " " + assignOperator(operands[2])
+		case code.OpSetIndex:
+			comment = "[] " + assignOperator(operands[0])
+		case code.OpEnterScope:
+			scope := fn.Scopes[operands[0]]
+			comment = fmt.Sprint(scope)
+			env = append([]ast.Frame{scope}, env...)
+		case code.OpLeaveScope:
+			// A for loop leaves its scope twice: on the way back to the
+			// next iteration, which is followed by the jump there, and
+			// at its end.
+			if next := ip + 1 + read; next >= len(ins) || code.Opcode(ins[next]) != code.OpJump {
+				env = env[1:]
+			}
+		}
+
+		if comment != "" {
+			fmt.Fprintf(d.out, "  %04d  %-7s %-22s ; %s\n", ip, where, text, comment)
+		} else {
+			fmt.Fprintf(d.out, "  %04d  %-7s %s\n", ip, where, text)
+		}
+
+		ip += 1 + read
+	}
+
+	for i, c := range fn.Constants {
+		if f, ok := c.(*object.CompiledFunction); ok {
+			outer, ok := closures[i]
+			if !ok {
+				outer = env
+			}
+			d.function(functionName(name, i), f, append([]ast.Frame{f.Locals}, outer...))
+		}
+	}
+}
+
+// constant describes constant i of the function called name.
+func (d *disassembler) constant(name string, i int, c object.Object) string {
+	switch c := c.(type) {
+	case *object.String:
+		return strconv.Quote(c.Value)
+	case *object.CompiledFunction:
+		return functionName(name, i)
+	default:
+		return c.Inspect()
+	}
+}
+
+// functionName names the function that is constant i of the function
+// called parent by its path of constant indexes, like fn 3.1.
+func functionName(parent string, i int) string {
+	if parent == "main" {
+		return "fn " + strconv.Itoa(i)
+	}
+	return parent + "." + strconv.Itoa(i)
+}
+
+func variable(env []ast.Frame, depth, slot int) string {
+	if depth < len(env) && slot < len(env[depth]) {
+		return env[depth][slot]
+	}
+	return "?"
+}
+
+// assignOperator describes the operator operand of OpAssign and OpSetIndex.
+func assignOperator(op int) string {
+	if op == 0 {
+		return "="
+	}
+	for operator, opcode := range infixOpcodes {
+		if int(opcode) == op {
+			return operator + "="
+		}
+	}
+	return "?="
+}
@@ -0,0 +1,361 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/big"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/object"
+	"monkey/token"
+	"strings"
+)
+
+// A compiled program is stored as
+//
+//	magic    "MKC\x00"
+//	version  uint16
+//	globals  the names of the global slots, in slot order
+//	code     the top-level code, laid out like a function
+//	checksum CRC-32 (IEEE) of everything before it, uint32
+//
+// Fixed-size numbers are big-endian, like instruction operands. Counts,
+// lengths and positions are unsigned varints and integer constants are
+// signed varints. A function is its instructions, source map, constants,
+// loop scopes, locals and number of parameters. Each constant starts with
+// a tag byte saying what it is. Quoted code is kept as the JSON encoding of
+// its tree, as written by ast.EncodeJSON.
+//
+// The format follows the opcodes, so FormatVersion must change whenever
+// they are renumbered or their operands change.
+const FormatVersion = 2
+
+var magic = []byte("MKC\x00")
+
+const (
+	tagInteger  = 'i'
+	tagBigInt   = 'b'
+	tagFloat    = 'f'
+	tagString   = 's'
+	tagFunction = 'F'
+	tagQuote    = 'q'
+)
+
+// MarshalBinary encodes the program in the format read by UnmarshalBinary.
+func (b *Bytecode) MarshalBinary() ([]byte, error) {
+	e := &encoder{}
+	e.buf = append(e.buf, magic...)
+	e.buf = binary.BigEndian.AppendUint16(e.buf, FormatVersion)
+
+	e.strings(b.Globals)
+	err := e.function(&object.CompiledFunction{
+		Instructions: b.Instructions,
+		Positions:    b.Positions,
+		Constants:    b.Constants,
+		Scopes:       b.Scopes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return binary.BigEndian.AppendUint32(e.buf, crc32.ChecksumIEEE(e.buf)), nil
+}
+
+// UnmarshalBinary decodes a program written by MarshalBinary, checking its
+// version and checksum, and that its instructions are ones the vm can run.
+func (b *Bytecode) UnmarshalBinary(data []byte) error {
+	if len(data) < len(magic)+2+4 || !bytes.Equal(data[:len(magic)], magic) {
+		return fmt.Errorf("not a compiled monkey program")
+	}
+
+	if version := binary.BigEndian.Uint16(data[len(magic):]); version != FormatVersion {
+		return fmt.Errorf("unsupported format version %d, want %d", version, FormatVersion)
+	}
+
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return fmt.Errorf("checksum mismatch, the file is corrupt")
+	}
+
+	d := &decoder{buf: body[len(magic)+2:]}
+	globals := d.strings()
+	top := d.function()
+	if d.err == nil && len(d.buf) != 0 {
+		d.fail("%d bytes of trailing data", len(d.buf))
+	}
+	if d.err == nil && (top.NumParameters != 0 || len(top.Locals) != 0) {
+		d.fail("the top-level code has parameters")
+	}
+	if d.err == nil {
+		if err := verify(top, []ast.Frame{globals}); err != nil {
+			d.fail("%s", err)
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	*b = Bytecode{
+		Instructions: top.Instructions,
+		Positions:    top.Positions,
+		Constants:    top.Constants,
+		Scopes:       top.Scopes,
+		Globals:      globals,
+	}
+	return nil
+}
+
+type encoder struct {
+	buf []byte
+
+	// filename is that of the last position written. Positions only
+	// repeat their filename when it changes.
+	filename string
+}
+
+func (e *encoder) uint(n int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(n))
+}
+
+func (e *encoder) string(s string) {
+	e.uint(len(s))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) strings(ss []string) {
+	e.uint(len(ss))
+	for _, s := range ss {
+		e.string(s)
+	}
+}
+
+func (e *encoder) function(fn *object.CompiledFunction) error {
+	e.uint(len(fn.Instructions))
+	e.buf = append(e.buf, fn.Instructions...)
+
+	e.uint(len(fn.Positions))
+	for _, p := range fn.Positions {
+		e.uint(p.Offset)
+		if p.Pos.Filename != e.filename {
+			e.buf = append(e.buf, 1)
+			e.string(p.Pos.Filename)
+			e.filename = p.Pos.Filename
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+		e.uint(p.Pos.Offset)
+		e.uint(p.Pos.Line)
+		e.uint(p.Pos.Column)
+	}
+
+	e.uint(len(fn.Constants))
+	for _, c := range fn.Constants {
+		if err := e.constant(c); err != nil {
+			return err
+		}
+	}
+
+	e.uint(len(fn.Scopes))
+	for _, s := range fn.Scopes {
+		e.strings(s)
+	}
+	e.strings(fn.Locals)
+	e.uint(fn.NumParameters)
+
+	return nil
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buf = append(e.buf, tagInteger)
+		e.buf = binary.AppendVarint(e.buf, obj.Value)
+	case *object.BigInt:
+		e.buf = append(e.buf, tagBigInt)
+		e.string(obj.Value.String())
+	case *object.Float:
+		e.buf = append(e.buf, tagFloat)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(obj.Value))
+	case *object.String:
+		e.buf = append(e.buf, tagString)
+		e.string(obj.Value)
+	case *object.CompiledFunction:
+		e.buf = append(e.buf, tagFunction)
+		return e.function(obj)
+	case *object.Quote:
+		var tree, compact bytes.Buffer
+		if err := ast.EncodeJSON(&tree, obj.Node); err != nil {
+			return err
+		}
+		if err := json.Compact(&compact, tree.Bytes()); err != nil {
+			return err
+		}
+		e.buf = append(e.buf, tagQuote)
+		e.string(compact.String())
+	default:
+		return fmt.Errorf("cannot encode constant of type %s", obj.Type())
+	}
+
+	return nil
+}
+
+// decoder reads what encoder writes. The first error sticks, and reads
+// after it return zero values.
+type decoder struct {
+	buf []byte
+	err error
+
+	filename string
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, a...)
+	}
+	d.buf = nil
+}
+
+func (d *decoder) byte() byte {
+	if len(d.buf) == 0 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if n > len(d.buf) {
+		d.fail("unexpected end of data")
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint() int {
+	n, read := binary.Uvarint(d.buf)
+	if read <= 0 || n > math.MaxInt32 {
+		d.fail("malformed number")
+		return 0
+	}
+	d.buf = d.buf[read:]
+	return int(n)
+}
+
+// count reads the length of a list whose elements each take at least one
+// byte, so that a corrupt length cannot make us allocate a huge slice.
+func (d *decoder) count() int {
+	n := d.uint()
+	if n > len(d.buf) {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(d.uint()))
+}
+
+func (d *decoder) strings() []string {
+	n := d.count()
+	ss := make([]string, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		ss = append(ss, d.string())
+	}
+	return ss
+}
+
+func (d *decoder) function() *object.CompiledFunction {
+	fn := &object.CompiledFunction{}
+
+	fn.Instructions = code.Instructions(d.bytes(d.uint()))
+
+	n := d.count()
+	fn.Positions = make(code.SourceMap, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		p := code.SourcePos{Offset: d.uint()}
+		if d.byte() == 1 {
+			d.filename = d.string()
+		}
+		p.Pos = token.Position{
+			Filename: d.filename,
+			Offset:   d.uint(),
+			Line:     d.uint(),
+			Column:   d.uint(),
+		}
+		fn.Positions = append(fn.Positions, p)
+	}
+
+	n = d.count()
+	fn.Constants = make([]object.Object, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		fn.Constants = append(fn.Constants, d.constant())
+	}
+
+	n = d.count()
+	fn.Scopes = make([]ast.Frame, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		fn.Scopes = append(fn.Scopes, d.strings())
+	}
+	fn.Locals = d.strings()
+	fn.NumParameters = d.uint()
+
+	return fn
+}
+
+func (d *decoder) constant() object.Object {
+	switch tag := d.byte(); tag {
+	case tagInteger:
+		n, read := binary.Varint(d.buf)
+		if read <= 0 {
+			d.fail("malformed number")
+			return nil
+		}
+		d.buf = d.buf[read:]
+		return &object.Integer{Value: n}
+	case tagBigInt:
+		s := d.string()
+		n, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			d.fail("malformed big integer %q", s)
+			return nil
+		}
+		return &object.BigInt{Value: n}
+	case tagFloat:
+		b := d.bytes(8)
+		if b == nil {
+			return nil
+		}
+		return &object.Float{Value: math.Float64frombits(binary.BigEndian.Uint64(b))}
+	case tagString:
+		return &object.String{Value: d.string()}
+	case tagFunction:
+		return d.function()
+	case tagQuote:
+		s := d.string()
+		if d.err != nil {
+			return nil
+		}
+		node, err := ast.DecodeJSON(strings.NewReader(s))
+		if err != nil {
+			d.fail("malformed quoted code: %s", err)
+			return nil
+		}
+		if _, ok := node.(ast.Expression); !ok {
+			d.fail("quoted code is not an expression")
+			return nil
+		}
+		return &object.Quote{Node: node}
+	default:
+		d.fail("unknown constant tag %q", tag)
+		return nil
+	}
+}
@@ -0,0 +1,276 @@
+package compiler
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/evaluator"
+	"monkey/object"
+	"slices"
+)
+
+// verify checks that the vm can run fn, a function of a decoded program,
+// without going wrong: each instruction has a known opcode and all its
+// operands, jumps land on an instruction, or just past the last one, the
+// constants, scopes and variables instructions refer to exist, and every
+// path through the code finds the values it takes on the stack. env is the
+// layout of the environment fn runs in, innermost frame first. The
+// functions among its constants are verified in the environment they are
+// created in.
+func verify(fn *object.CompiledFunction, env []ast.Frame) error {
+	if err := verifyInstructions(fn); err != nil {
+		return err
+	}
+
+	closures, err := verifyPaths(fn, env)
+	if err != nil {
+		return err
+	}
+
+	for i, c := range fn.Constants {
+		if f, ok := c.(*object.CompiledFunction); ok {
+			outer, ok := closures[i]
+			if !ok {
+				// Never created, so never run, but check it anyway.
+				outer = env
+			}
+			if err := verify(f, append([]ast.Frame{f.Locals}, outer...)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// verifyInstructions checks each instruction of fn on its own.
+func verifyInstructions(fn *object.CompiledFunction) error {
+	ins := fn.Instructions
+	starts := make([]bool, len(ins)+1)
+	starts[len(ins)] = true
+
+	type jump struct{ from, to int }
+	var jumps []jump
+
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return fmt.Errorf("at %d: %w", i, err)
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			return fmt.Errorf("at %d: %s is missing operands", i, def.Name)
+		}
+		operands, _ := code.ReadOperands(def, ins[i+1:])
+
+		switch op := code.Opcode(ins[i]); op {
+		case code.OpConstant, code.OpClosure, code.OpQuote:
+			index := operands[0]
+			if index >= len(fn.Constants) {
+				return fmt.Errorf("at %d: %s refers to constant %d of %d", i, def.Name, index, len(fn.Constants))
+			}
+			if _, ok := fn.Constants[index].(*object.CompiledFunction); op == code.OpClosure && !ok {
+				return fmt.Errorf("at %d: %s constant %d is not a function", i, def.Name, index)
+			}
+			if op == code.OpQuote {
+				quote, ok := fn.Constants[index].(*object.Quote)
+				if !ok {
+					return fmt.Errorf("at %d: %s constant %d is not quoted code", i, def.Name, index)
+				}
+				if n := len(evaluator.UnquoteArguments(quote.Node)); operands[1] != n {
+					return fmt.Errorf("at %d: %s takes %d values for %d unquote calls", i, def.Name, operands[1], n)
+				}
+			}
+		case code.OpEnterScope:
+			if operands[0] >= len(fn.Scopes) {
+				return fmt.Errorf("at %d: %s refers to scope %d of %d", i, def.Name, operands[0], len(fn.Scopes))
+			}
+		case code.OpAssign, code.OpSetIndex:
+			if operator := operands[len(operands)-1]; operator != 0 && !isInfixOpcode(code.Opcode(operator)) {
+				return fmt.Errorf("at %d: %s has unknown operator %d", i, def.Name, operator)
+			}
+		case code.OpHash:
+			if operands[0]%2 != 0 {
+				return fmt.Errorf("at %d: %s of %d values, which do not make pairs", i, def.Name, operands[0])
+			}
+		case code.OpJump, code.OpJumpNotTruthy, code.OpAnd, code.OpOr, code.OpNext:
+			jumps = append(jumps, jump{i, operands[0]})
+		}
+
+		starts[i] = true
+		i += 1 + width
+	}
+
+	for _, j := range jumps {
+		if j.to > len(ins) || !starts[j.to] {
+			return fmt.Errorf("at %d: jump to %d, which is not the start of an instruction", j.from, j.to)
+		}
+	}
+
+	return nil
+}
+
+// pathState is what verifyPaths knows about the vm when it reaches an
+// instruction.
+type pathState struct {
+	depth  int   // values on the stack, above those of the caller
+	scopes []int // the scopes entered, innermost last
+}
+
+// verifyPaths follows every path through the code of fn, which starts with
+// its arguments on the stack, checking that each instruction finds the
+// values it takes there and that the variables it uses exist in its
+// environment. Each instruction must be reached with the same stack depth
+// and in the same scopes along every path, as the compiler lays code out.
+// It returns the environment each function among the constants is created
+// in, by constant index.
+func verifyPaths(fn *object.CompiledFunction, env []ast.Frame) (map[int][]ast.Frame, error) {
+	ins := fn.Instructions
+	states := make([]*pathState, len(ins))
+	closures := make(map[int][]ast.Frame)
+
+	var work []int
+	reach := func(to int, s pathState) error {
+		if to == len(ins) {
+			return nil
+		}
+		if prev := states[to]; prev != nil {
+			if prev.depth != s.depth {
+				return fmt.Errorf("at %d: reached with %d and with %d values on the stack", to, prev.depth, s.depth)
+			}
+			if !slices.Equal(prev.scopes, s.scopes) {
+				return fmt.Errorf("at %d: reached in different scopes", to)
+			}
+			return nil
+		}
+		states[to] = &s
+		work = append(work, to)
+		return nil
+	}
+
+	if err := reach(0, pathState{depth: fn.NumParameters}); err != nil {
+		return nil, err
+	}
+
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		s := *states[i]
+		op := code.Opcode(ins[i])
+		def, _ := code.Lookup(byte(op))
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		next := i + 1 + read
+
+		if n := stackInputs(op, operands); s.depth < n {
+			return nil, fmt.Errorf("at %d: %s takes %d values from a stack of %d", i, def.Name, n, s.depth)
+		}
+
+		frames := make([]ast.Frame, 0, len(s.scopes)+len(env))
+		for k := len(s.scopes) - 1; k >= 0; k-- {
+			frames = append(frames, fn.Scopes[s.scopes[k]])
+		}
+		frames = append(frames, env...)
+
+		var err error
+		switch op {
+		case code.OpGetVar, code.OpAssign:
+			err = verifyVariable(frames, operands[0], operands[1])
+		case code.OpDefine:
+			err = verifyVariable(frames, 0, operands[0])
+		case code.OpClosure:
+			if prev, ok := closures[operands[0]]; ok && !sameLayout(prev, frames) {
+				err = fmt.Errorf("creates function %d in a different environment than before", operands[0])
+			}
+			closures[operands[0]] = frames
+		case code.OpEnterScope:
+			s.scopes = append(slices.Clip(s.scopes), operands[0])
+		case code.OpLeaveScope:
+			if len(s.scopes) == 0 {
+				err = fmt.Errorf("outside of a scope")
+				break
+			}
+			s.scopes = s.scopes[:len(s.scopes)-1]
+		}
+		if err != nil {
+			return nil, fmt.Errorf("at %d: %s %w", i, def.Name, err)
+		}
+
+		after := s
+		after.depth += stackEffect(op, operands)
+
+		switch op {
+		case code.OpJump:
+			err = reach(operands[0], s)
+		case code.OpJumpNotTruthy:
+			err = reach(operands[0], after)
+		case code.OpAnd, code.OpOr, code.OpNext:
+			// The value on top of the stack stays there when they jump.
+			err = reach(operands[0], s)
+		}
+		if err == nil && op != code.OpJump && op != code.OpReturnValue && op != code.OpReturn {
+			err = reach(next, after)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return closures, nil
+}
+
+// stackInputs returns how many values an instruction takes from the top of
+// the stack, whether it pops them or not.
+func stackInputs(op code.Opcode, operands []int) int {
+	switch op {
+	case code.OpPop, code.OpJumpNotTruthy, code.OpAnd, code.OpOr,
+		code.OpDefine, code.OpAssign, code.OpMinus, code.OpBang, code.OpBitNot,
+		code.OpReturnValue, code.OpIter, code.OpNext:
+		return 1
+	case code.OpIndex,
+		code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight,
+		code.OpEqual, code.OpNotEqual, code.OpLessThan, code.OpGreaterThan,
+		code.OpLessEqual, code.OpGreaterEqual:
+		return 2
+	case code.OpSetIndex:
+		return 3
+	case code.OpArray, code.OpHash, code.OpInterpolate:
+		return operands[0]
+	case code.OpQuote:
+		return operands[1]
+	case code.OpCall:
+		return operands[0] + 1
+	default:
+		return 0
+	}
+}
+
+// verifyVariable checks that the environment depth frames out of frames,
+// innermost first, has slot.
+func verifyVariable(frames []ast.Frame, depth, slot int) error {
+	if depth >= len(frames) {
+		return fmt.Errorf("refers to environment %d of %d", depth, len(frames))
+	}
+	if slot >= len(frames[depth]) {
+		return fmt.Errorf("refers to slot %d of %d", slot, len(frames[depth]))
+	}
+	return nil
+}
+
+func sameLayout(a, b []ast.Frame) bool {
+	return slices.EqualFunc(a, b, func(x, y ast.Frame) bool { return slices.Equal(x, y) })
+}
+
+func isInfixOpcode(op code.Opcode) bool {
+	for _, opcode := range infixOpcodes {
+		if opcode == op {
+			return true
+		}
+	}
+	return false
+}
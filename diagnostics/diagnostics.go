@@ -0,0 +1,308 @@
+package diagnostics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/token"
+	"os"
+	"sort"
+	"strings"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	default:
+		return "error"
+	}
+}
+
+// Label marks a span of the source. A zero Length means the span covers the
+// token that starts at Pos.
+type Label struct {
+	Pos     token.Position
+	Length  int
+	Message string
+}
+
+type Diagnostic struct {
+	Severity  Severity
+	Code      string
+	Message   string
+	Primary   Label
+	Secondary *Label
+	Help      string
+}
+
+func FromParseError(err *parser.ParseError) Diagnostic {
+	d := Diagnostic{
+		Severity: Error,
+		Code:     string(err.Code),
+		Message:  err.Message,
+		Primary:  Label{Pos: err.Span.Start, Length: err.Span.Len()},
+	}
+
+	switch err.Code {
+	case parser.UnexpectedToken:
+		expected := make([]string, len(err.Expected))
+		for i, t := range err.Expected {
+			expected[i] = "`" + string(t) + "`"
+		}
+		d.Primary.Message = "expected " + strings.Join(expected, " or ")
+	case parser.NoPrefixParseFn:
+		if err.Actual.Type == token.EOF {
+			d.Primary.Message = "unexpected end of input"
+			d.Help = "the expression is incomplete"
+		} else {
+			d.Primary.Message = "unexpected `" + err.Actual.Literal + "`"
+			d.Help = "`" + err.Actual.Literal + "` cannot start an expression"
+		}
+	case parser.InvalidIntLiteral:
+		d.Primary.Message = "invalid integer literal"
+	}
+
+	return d
+}
+
+func FromParseErrors(errs parser.ErrorList) []Diagnostic {
+	diags := make([]Diagnostic, len(errs))
+	for i, err := range errs {
+		diags[i] = FromParseError(err)
+	}
+	return diags
+}
+
+func FromRuntimeError(err *object.Error) Diagnostic {
+	return Diagnostic{
+		Severity: Error,
+		Message:  err.Message,
+		Primary:  Label{Pos: err.Pos},
+	}
+}
+
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[1;31m"
+	colorYellow = "\x1b[1;33m"
+	colorBlue   = "\x1b[1;34m"
+	colorCyan   = "\x1b[1;36m"
+)
+
+const tabWidth = 4
+
+type Printer struct {
+	out   io.Writer
+	Color bool
+}
+
+// NewPrinter returns a printer writing to out. Color is enabled when out is
+// a terminal and NO_COLOR is not set.
+func NewPrinter(out io.Writer) *Printer {
+	return &Printer{out: out, Color: IsTerminal(out) && os.Getenv("NO_COLOR") == ""}
+}
+
+func IsTerminal(w any) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *Printer) PrintAll(source string, diags []Diagnostic) {
+	for _, d := range diags {
+		p.Print(source, d)
+	}
+}
+
+func (p *Printer) Print(source string, d Diagnostic) {
+	io.WriteString(p.out, p.Render(source, d))
+}
+
+// Render formats a diagnostic in the style of rustc:
+//
+//	error: expected next token to be =, got INT instead
+//	 --> script.mk:2:7
+//	  |
+//	2 | let y 10;
+//	  |       ^^
+func (p *Printer) Render(source string, d Diagnostic) string {
+	var out bytes.Buffer
+
+	severity := d.Severity.String()
+	if d.Code != "" {
+		severity += "[" + d.Code + "]"
+	}
+	out.WriteString(p.paint(severityColor(d.Severity), severity))
+	out.WriteString(p.paint(colorBold, ": "+d.Message))
+	out.WriteString("\n")
+
+	labels := []labelLine{{Label: d.Primary, primary: true}}
+	if d.Secondary != nil {
+		labels = append(labels, labelLine{Label: *d.Secondary})
+	}
+
+	sort.SliceStable(labels, func(i, j int) bool {
+		return labels[i].Pos.Line < labels[j].Pos.Line
+	})
+
+	// Without the source, as for compiled programs, only the position
+	// is shown, with no gutter.
+	var lines []string
+	if source != "" {
+		lines = strings.Split(source, "\n")
+	}
+
+	width := 0
+	for _, l := range labels {
+		if w := len(fmt.Sprint(l.Pos.Line)); w > width {
+			width = w
+		}
+	}
+	gutter := strings.Repeat(" ", width)
+
+	if d.Primary.Pos.IsValid() {
+		out.WriteString(p.paint(colorBlue, gutter+"--> "))
+		out.WriteString(d.Primary.Pos.String())
+		out.WriteString("\n")
+	}
+
+	if lines != nil {
+		out.WriteString(p.paint(colorBlue, gutter+" |"))
+		out.WriteString("\n")
+	}
+
+	lastLine := 0
+	for _, l := range labels {
+		if !l.Pos.IsValid() || l.Pos.Line > len(lines) {
+			continue
+		}
+
+		if l.Pos.Line != lastLine {
+			if lastLine != 0 && l.Pos.Line > lastLine+1 {
+				out.WriteString(p.paint(colorBlue, "..."))
+				out.WriteString("\n")
+			}
+
+			text := strings.TrimRight(lines[l.Pos.Line-1], "\r")
+			out.WriteString(p.paint(colorBlue, fmt.Sprintf("%*d | ", width, l.Pos.Line)))
+			out.WriteString(expandTabs(text))
+			out.WriteString("\n")
+			lastLine = l.Pos.Line
+		}
+
+		text := lines[l.Pos.Line-1]
+		column := l.Pos.Column - 1
+		if column > len(text) {
+			column = len(text)
+		}
+		indent := len(expandTabs(text[:column]))
+
+		length := l.Length
+		if length <= 0 {
+			length = tokenLength(source, l.Pos.Offset)
+		}
+		if rest := len(text) - column; length > rest {
+			length = max(rest, 1)
+		}
+
+		mark, color := "-", colorBlue
+		if l.primary {
+			mark, color = "^", severityColor(d.Severity)
+		}
+
+		underline := strings.Repeat(mark, length)
+		if l.Message != "" {
+			underline += " " + l.Message
+		}
+
+		out.WriteString(p.paint(colorBlue, gutter+" | "))
+		out.WriteString(strings.Repeat(" ", indent))
+		out.WriteString(p.paint(color, underline))
+		out.WriteString("\n")
+	}
+
+	if d.Help != "" {
+		if lines != nil {
+			out.WriteString(p.paint(colorBlue, gutter+" |"))
+			out.WriteString("\n")
+		}
+		out.WriteString(p.paint(colorBlue, gutter+" = "))
+		out.WriteString(p.paint(colorBold, "help"))
+		out.WriteString(": " + d.Help + "\n")
+	}
+
+	return out.String()
+}
+
+type labelLine struct {
+	Label
+	primary bool
+}
+
+func (p *Printer) paint(color, s string) string {
+	if !p.Color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+func severityColor(s Severity) string {
+	switch s {
+	case Warning:
+		return colorYellow
+	case Note:
+		return colorCyan
+	default:
+		return colorRed
+	}
+}
+
+// tokenLength reports how many bytes of the line the token at offset spans,
+// so that labels without an explicit length underline the whole token.
+func tokenLength(source string, offset int) int {
+	if offset < 0 || offset >= len(source) {
+		return 1
+	}
+
+	rest := source[offset:]
+	tok := lexer.New(rest).NextToken()
+
+	length := tok.Span().Len()
+
+	if end := strings.IndexByte(rest, '\n'); end >= 0 && length > end {
+		length = end
+	}
+
+	if length < 1 {
+		return 1
+	}
+
+	return length
+}
+
+func expandTabs(s string) string {
+	return strings.ReplaceAll(s, "\t", strings.Repeat(" ", tabWidth))
+}
@@ -0,0 +1,116 @@
+package diagnostics
+
+import (
+	"bytes"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/token"
+	"testing"
+)
+
+func TestRenderParseError(t *testing.T) {
+	input := "let x = 5;\nlet y 10;"
+
+	p := parser.New(lexer.NewFile("test.mk", input))
+	p.ParseProgram()
+
+	errs := p.Errors()
+	if len(errs) == 0 {
+		t.Fatalf("expected parser errors")
+	}
+
+	expected := "error[E001]: expected next token to be =, got INT instead\n" +
+		" --> test.mk:2:7\n" +
+		"  |\n" +
+		"2 | let y 10;\n" +
+		"  |       ^^ expected `=`\n"
+
+	printer := &Printer{out: &bytes.Buffer{}}
+	if got := printer.Render(input, FromParseError(errs[0])); got != expected {
+		t.Errorf("wrong rendering. expected=\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+func TestRenderRuntimeError(t *testing.T) {
+	input := `let f = fn(a) {
+	a + "x"
+};
+f(1);`
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	evaluated := evaluator.Eval(program, object.NewEnvironment())
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	expected := `error: type mismatch: INTEGER + STRING
+ --> 2:4
+  |
+2 |     a + "x"
+  |       ^
+`
+
+	printer := &Printer{out: &bytes.Buffer{}}
+	if got := printer.Render(input, FromRuntimeError(errObj)); got != expected {
+		t.Errorf("wrong rendering. expected=\n%s\ngot=\n%s", expected, got)
+	}
+
+	// A compiled program has no source to show.
+	expected = "error: type mismatch: INTEGER + STRING\n --> 2:4\n"
+	if got := printer.Render("", FromRuntimeError(errObj)); got != expected {
+		t.Errorf("wrong rendering without source. expected=\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+func TestRenderSecondaryLabelAndHelp(t *testing.T) {
+	input := "let name = \"monkey\";\n\nname(1);"
+
+	d := Diagnostic{
+		Severity: Error,
+		Message:  "not a function: STRING",
+		Primary: Label{
+			Pos:     token.Position{Offset: 22, Line: 3, Column: 1},
+			Message: "called here",
+		},
+		Secondary: &Label{
+			Pos:     token.Position{Offset: 11, Line: 1, Column: 12},
+			Message: "defined as a string here",
+		},
+		Help: "only functions and builtins can be called",
+	}
+
+	expected := `error: not a function: STRING
+ --> 3:1
+  |
+1 | let name = "monkey";
+  |            -------- defined as a string here
+...
+3 | name(1);
+  | ^^^^ called here
+  |
+  = help: only functions and builtins can be called
+`
+
+	out := &bytes.Buffer{}
+	printer := &Printer{out: out}
+	printer.Print(input, d)
+	if out.String() != expected {
+		t.Errorf("wrong rendering. expected=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestRenderWithColor(t *testing.T) {
+	d := Diagnostic{Severity: Warning, Message: "unused", Primary: Label{Pos: token.Position{Line: 1, Column: 1}}}
+
+	printer := &Printer{out: &bytes.Buffer{}, Color: true}
+	got := printer.Render("x", d)
+
+	if !bytes.Contains([]byte(got), []byte(colorYellow+"warning"+colorReset)) {
+		t.Errorf("expected colored severity. got=%q", got)
+	}
+}
@@ -0,0 +1,170 @@
+// Package diff compares texts line by line.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around each change.
+const context = 3
+
+// Unified returns the differences between old and new in unified diff
+// format, with three lines of context, or "" if they are the same.
+func Unified(oldName, newName, old, new string) string {
+	if old == new {
+		return ""
+	}
+
+	a, b := lines(old), lines(new)
+	ops := edits(a, b)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// A hunk runs from context lines before a change to context lines
+		// after the last change that has no more than 2*context unchanged
+		// lines before it.
+		first := max(i-context, 0)
+		last := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				if j-last > 2*context+1 {
+					break
+				}
+				last = j
+			}
+		}
+		end := min(last+context+1, len(ops))
+
+		hunk(&out, ops[first:end])
+		i = end
+	}
+
+	return out.String()
+}
+
+type op struct {
+	kind byte // ' ', '-' or '+'
+	line string
+	a, b int // lines of old and new before this one
+}
+
+func hunk(out *strings.Builder, ops []op) {
+	var oldLines, newLines int
+	for _, o := range ops {
+		if o.kind != '+' {
+			oldLines++
+		}
+		if o.kind != '-' {
+			newLines++
+		}
+	}
+
+	// An empty range starts after the line before it.
+	oldStart, newStart := ops[0].a+1, ops[0].b+1
+	if oldLines == 0 {
+		oldStart--
+	}
+	if newLines == 0 {
+		newStart--
+	}
+
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldLines, newStart, newLines)
+	for _, o := range ops {
+		out.WriteByte(o.kind)
+		out.WriteString(o.line)
+		if !strings.HasSuffix(o.line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// lines splits s after each newline.
+func lines(s string) []string {
+	list := strings.SplitAfter(s, "\n")
+	if list[len(list)-1] == "" {
+		list = list[:len(list)-1]
+	}
+	return list
+}
+
+// edits returns a shortest edit script turning a into b, found with
+// Myers' algorithm.
+func edits(a, b []string) []op {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// trace holds v as it was before each round d, for finding the path
+	// back once the end is reached.
+	var trace [][]int
+
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var ops []op
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, op{' ', a[x], x, y})
+		}
+
+		if d > 0 {
+			if x == prevX {
+				y--
+				ops = append(ops, op{'+', b[y], x, y})
+			} else {
+				x--
+				ops = append(ops, op{'-', a[x], x, y})
+			}
+		}
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
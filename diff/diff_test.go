@@ -0,0 +1,77 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		old, new string
+		expected string
+	}{
+		{"a\nb\n", "a\nb\n", ""},
+		{
+			"a\nb\nc\n",
+			"a\nB\nc\n",
+			"@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			"",
+			"x\n",
+			"@@ -0,0 +1,1 @@\n+x\n",
+		},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			"1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			"@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n" +
+				"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n",
+			"one\n2\n3\n4\n5\n6\n7\neight\n",
+			"@@ -1,8 +1,8 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+		{
+			"a\nb",
+			"a\nb\n",
+			"@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		got := Unified("old", "new", tt.old, tt.new)
+		if tt.expected != "" {
+			tt.expected = "--- old\n+++ new\n" + tt.expected
+		}
+		if got != tt.expected {
+			t.Errorf("wrong diff of %q and %q.\nwant=%q\ngot =%q", tt.old, tt.new, tt.expected, got)
+		}
+	}
+}
+
+func TestEditsAreShortest(t *testing.T) {
+	a := lines("a\nb\nc\na\nb\nb\na\n")
+	b := lines("c\nb\na\nb\na\nc\n")
+
+	changes := 0
+	var old, new strings.Builder
+	for _, o := range edits(a, b) {
+		if o.kind != ' ' {
+			changes++
+		}
+		if o.kind != '+' {
+			old.WriteString(o.line)
+		}
+		if o.kind != '-' {
+			new.WriteString(o.line)
+		}
+	}
+
+	if old.String() != strings.Join(a, "") || new.String() != strings.Join(b, "") {
+		t.Errorf("edits do not turn a into b")
+	}
+	if changes != 5 {
+		t.Errorf("wrong number of changes. want=5, got=%d", changes)
+	}
+}
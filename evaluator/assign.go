@@ -0,0 +1,115 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+	"monkey/token"
+	"strings"
+)
+
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		return evalIdentifierAssignment(node, target, env)
+	case *ast.IndexExpression:
+		return evalIndexAssignment(node, target, env)
+	default:
+		return newErrorAt(node, "cannot assign to %s", node.Target.String())
+	}
+}
+
+// evalIdentifierAssignment rebinds the variable in the scope that defined
+// it. Assigning to a name that was never bound with let is an error.
+func evalIdentifierAssignment(node *ast.AssignExpression, target *ast.Identifier, env *object.Environment) object.Object {
+	val := Eval(node.Value, env)
+	if escapes(val) {
+		return val
+	}
+
+	if node.Operator != token.ASSIGN {
+		current, ok := lookupVariable(target, env)
+		if !ok {
+			return newErrorAt(target, "identifier not found: %s", target.Value)
+		}
+
+		val = withPosition(evalInfixExpression(current, val, compoundOperator(node.Operator)), node)
+		if isError(val) {
+			return val
+		}
+	}
+
+	if !assignVariable(target, env, val) {
+		return newErrorAt(target, "identifier not found: %s", target.Value)
+	}
+
+	return val
+}
+
+// evalIndexAssignment stores into an array element or a hash entry in
+// place, so every reference to the array or hash sees the change.
+func evalIndexAssignment(node *ast.AssignExpression, target *ast.IndexExpression, env *object.Environment) object.Object {
+	left := Eval(target.Left, env)
+	if escapes(left) {
+		return left
+	}
+
+	index := Eval(target.Index, env)
+	if escapes(index) {
+		return index
+	}
+
+	val := Eval(node.Value, env)
+	if escapes(val) {
+		return val
+	}
+
+	if node.Operator != token.ASSIGN {
+		current := withPosition(applyIndex(left, index), target)
+		if isError(current) {
+			return current
+		}
+
+		val = withPosition(evalInfixExpression(current, val, compoundOperator(node.Operator)), node)
+		if isError(val) {
+			return val
+		}
+	}
+
+	switch left.(type) {
+	case *object.Array, *object.Hash:
+		return withPosition(setIndex(left, index, val), target.Index)
+	default:
+		return newErrorAt(target, "index assignment not supported: %s", left.Type())
+	}
+}
+
+// setIndex stores val at index of an array or hash.
+func setIndex(left, index, val object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		if idx.Value < 0 || idx.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %d", idx.Value)
+		}
+		left.Elements[idx.Value] = val
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.Set(key.HashKey(), object.HashPair{Key: index, Value: val})
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+
+	return val
+}
+
+// compoundOperator returns the infix operator a compound assignment
+// applies, such as + for +=.
+func compoundOperator(operator string) string {
+	return strings.TrimSuffix(operator, "=")
+}
@@ -0,0 +1,81 @@
+package evaluator
+
+import (
+	"math/big"
+	"monkey/object"
+	"monkey/token"
+)
+
+// evalBigIntInfixExpression applies operator to two integers, at least one
+// of which is a BigInt or whose int64 result would overflow.
+func evalBigIntInfixExpression(left, right object.Object, operator string) object.Object {
+	l, r := toBigInt(left), toBigInt(right)
+
+	switch operator {
+	case token.PLUS:
+		return object.NewInteger(new(big.Int).Add(l, r))
+	case token.MINUS:
+		return object.NewInteger(new(big.Int).Sub(l, r))
+	case token.ASTERISK:
+		return object.NewInteger(new(big.Int).Mul(l, r))
+	case token.SLASH:
+		if r.Sign() == 0 {
+			return newError("division by zero")
+		}
+		return object.NewInteger(new(big.Int).Quo(l, r))
+	case token.PERCENT:
+		if r.Sign() == 0 {
+			return newError("division by zero")
+		}
+		return object.NewInteger(new(big.Int).Rem(l, r))
+	case token.EQ:
+		return nativeBoolToBooleanObject(l.Cmp(r) == 0)
+	case token.NOT_EQ:
+		return nativeBoolToBooleanObject(l.Cmp(r) != 0)
+	case token.LT:
+		return nativeBoolToBooleanObject(l.Cmp(r) < 0)
+	case token.GT:
+		return nativeBoolToBooleanObject(l.Cmp(r) > 0)
+	case token.LT_EQ:
+		return nativeBoolToBooleanObject(l.Cmp(r) <= 0)
+	case token.GT_EQ:
+		return nativeBoolToBooleanObject(l.Cmp(r) >= 0)
+	case token.BIT_AND:
+		return object.NewInteger(new(big.Int).And(l, r))
+	case token.BIT_OR:
+		return object.NewInteger(new(big.Int).Or(l, r))
+	case token.BIT_XOR:
+		return object.NewInteger(new(big.Int).Xor(l, r))
+	case token.SHL, token.SHR:
+		if r.Sign() < 0 {
+			return newError("negative shift count: %s", r)
+		}
+		if !r.IsUint64() || r.Uint64() > maxShift {
+			return newError("shift count too large: %s", r)
+		}
+		if operator == token.SHL {
+			return object.NewInteger(new(big.Int).Lsh(l, uint(r.Uint64())))
+		}
+		return object.NewInteger(new(big.Int).Rsh(l, uint(r.Uint64())))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// maxShift bounds shift counts so that a typo cannot allocate gigabytes.
+const maxShift = 1 << 20
+
+func isInteger(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.BIGINT_OBJ
+}
+
+func toBigInt(obj object.Object) *big.Int {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return big.NewInt(obj.Value)
+	case *object.BigInt:
+		return obj.Value
+	default:
+		return new(big.Int)
+	}
+}
@@ -2,7 +2,11 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
+	"math/big"
 	"monkey/object"
+	"strconv"
+	"strings"
 )
 
 var builtins = map[string]*object.Builtin{
@@ -21,6 +25,8 @@ var builtins = map[string]*object.Builtin{
 				return &object.Integer{
 					Value: int64(len(arg.Elements)),
 				}
+			case *object.Range:
+				return object.NewInteger(new(big.Int).SetUint64(arg.Len()))
 			default:
 				return newError("argument to `len` not supported, got %s", arg.Type())
 
@@ -103,6 +109,89 @@ var builtins = map[string]*object.Builtin{
 			}
 		},
 	},
+	"int": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Integer, *object.BigInt:
+				return arg
+			case *object.Float:
+				if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+					return newError("float %s out of integer range", arg.Inspect())
+				}
+				if arg.Value >= math.MaxInt64 || arg.Value < math.MinInt64 {
+					value, _ := big.NewFloat(arg.Value).Int(nil)
+					return object.NewInteger(value)
+				}
+				return &object.Integer{Value: int64(arg.Value)}
+			case *object.String:
+				value, ok := new(big.Int).SetString(strings.TrimSpace(arg.Value), 10)
+				if !ok {
+					return newError("could not convert %q to INTEGER", arg.Value)
+				}
+				return object.NewInteger(value)
+			default:
+				return newError("argument to `int` not supported, got %s", arg.Type())
+			}
+		},
+	},
+	"float": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Float:
+				return arg
+			case *object.Integer, *object.BigInt:
+				return &object.Float{Value: toFloat(arg)}
+			case *object.String:
+				value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+				if err != nil {
+					return newError("could not convert %q to FLOAT", arg.Value)
+				}
+				return &object.Float{Value: value}
+			default:
+				return newError("argument to `float` not supported, got %s", arg.Type())
+			}
+		},
+	},
+	"range": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=1 to 3", len(args))
+			}
+
+			bounds := make([]int64, len(args))
+			for i, arg := range args {
+				integer, ok := arg.(*object.Integer)
+				if !ok {
+					return newError("arguments to `range` must be INTEGER, got %s", arg.Type())
+				}
+				bounds[i] = integer.Value
+			}
+
+			r := &object.Range{Step: 1}
+			switch len(bounds) {
+			case 1:
+				r.Stop = bounds[0]
+			case 2:
+				r.Start, r.Stop = bounds[0], bounds[1]
+			case 3:
+				r.Start, r.Stop, r.Step = bounds[0], bounds[1], bounds[2]
+			}
+
+			if r.Step == 0 {
+				return newError("range step must not be zero")
+			}
+
+			return r
+		},
+	},
 	"puts": {
 		Fn: func(args ...object.Object) object.Object {
 			for _, arg := range args {
@@ -113,3 +202,11 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 }
+
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	return names
+}
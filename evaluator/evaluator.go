@@ -1,9 +1,13 @@
 package evaluator
 
 import (
+	"bytes"
 	"fmt"
+	"math"
+	"math/big"
 	"monkey/ast"
 	"monkey/object"
+	"monkey/resolver"
 	"monkey/token"
 )
 
@@ -11,11 +15,15 @@ var (
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
 	NULL  = &object.Null{}
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
+		resolver.Resolve(node, env)
 		return evalProgram(node.Statements, env)
 	case *ast.BlockStatement:
 		return evalBlockStatement(node.Statements, env)
@@ -23,42 +31,61 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.BigIntegerLiteral:
+		return &object.BigInt{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		return evalInterpolatedString(node, env)
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if escapes(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return withPosition(evalPrefixExpression(node.Operator, right), node)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if escapes(left) {
 			return left
 		}
+		if node.Operator == token.AND || node.Operator == token.OR {
+			return evalLogicalExpression(node, left, env)
+		}
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if escapes(right) {
 			return right
 		}
-		return evalInfixExpression(left, right, node.Operator)
+		return withPosition(evalInfixExpression(left, right, node.Operator), node)
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		if escapes(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if escapes(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		defineVariable(node.Name, env, val)
 	case *ast.Identifier:
-		val, ok := env.Get(node.Value)
+		val, ok := lookupVariable(node, env)
 		if ok {
 			return val
 		}
@@ -67,33 +94,37 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return builtin
 		}
 
-		if !ok {
-			return newError("identifier not found: %s", node.Value)
-		}
-		return val
+		return newErrorAt(node, "identifier not found: %s", node.Value)
 	case *ast.FunctionLiteral:
 		return &object.Function{
 			Parameters: node.Parameters,
 			Body:       node.Body,
+			Locals:     node.Locals,
 			Env:        env,
 		}
+	case *ast.MacroLiteral:
+		return newErrorAt(node, "macros can only be defined by top-level let statements")
 	case *ast.CallExpression:
+		if IsQuoteCall(node) {
+			return quote(node, env)
+		}
+
 		function := Eval(node.Function, env)
-		if isError(function) {
+		if escapes(function) {
 			return function
 		}
 
 		args := evalExpressions(node.Arguments, env)
 
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && escapes(args[0]) {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		return withPosition(applyFunction(function, args), node)
 	case *ast.ArrayLiteral:
 		elems := evalExpressions(node.Elements, env)
 
-		if len(elems) == 1 && isError(elems[0]) {
+		if len(elems) == 1 && escapes(elems[0]) {
 			return elems[0]
 		}
 
@@ -102,29 +133,31 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 	case *ast.IndexExpression:
 		array := Eval(node.Left, env)
-		if isError(array) {
+		if escapes(array) {
 			return array
 		}
 
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if escapes(index) {
 			return index
 		}
 
-		return applyIndex(array, index)
+		return withPosition(applyIndex(array, index), node)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return withPosition(evalHashLiteral(node, env), node)
+	case *ast.BadStatement:
+		return newErrorAt(node, "cannot evaluate statement with syntax errors")
 	}
 
 	return nil
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash(len(node.Pairs))
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
-		if isError(key) {
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, env)
+		if escapes(key) {
 			return key
 		}
 
@@ -133,18 +166,32 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
-		if isError(value) {
+		value := Eval(pair.Value, env)
+		if escapes(value) {
 			return value
 		}
 
-		pairs[keyHash.HashKey()] = object.HashPair{
+		hash.Set(keyHash.HashKey(), object.HashPair{
 			Key:   key,
 			Value: value,
+		})
+	}
+
+	return hash
+}
+
+func evalInterpolatedString(node *ast.InterpolatedString, env *object.Environment) object.Object {
+	var out bytes.Buffer
+
+	for _, part := range node.Parts {
+		value := Eval(part, env)
+		if escapes(value) {
+			return value
 		}
+		out.WriteString(value.Inspect())
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return &object.String{Value: out.String()}
 }
 
 func applyIndex(left object.Object, index object.Object) object.Object {
@@ -166,7 +213,7 @@ func evalHashIndexExpression(hashTable, index object.Object) object.Object {
 		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hashObject.Get(key.HashKey())
 	if !ok {
 		return NULL
 	}
@@ -187,6 +234,10 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 func applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d",
+				len(fn.Parameters), len(args))
+		}
 		extendedEnv := extendFunctionEnvironment(fn, args)
 		result := Eval(fn.Body, extendedEnv)
 		return unwrapReturnValue(result)
@@ -206,21 +257,58 @@ func unwrapReturnValue(obj object.Object) object.Object {
 }
 
 func extendFunctionEnvironment(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.ExtendEnvironment(fn.Env)
+	env := object.NewFrame(fn.Env, fn.Locals)
 
 	for i, parameter := range fn.Parameters {
-		env.Set(parameter.Value, args[i])
+		defineVariable(parameter, env, args[i])
 	}
 
 	return env
 }
 
+// lookupVariable reads the variable ident names, from the slot the
+// resolver gave it or, for an identifier that was never resolved, by name.
+func lookupVariable(ident *ast.Identifier, env *object.Environment) (object.Object, bool) {
+	if !ident.Binding.Resolved {
+		return env.Get(ident.Value)
+	}
+
+	val := env.Lookup(ident.Binding.Depth, ident.Binding.Slot)
+	return val, val != nil
+}
+
+// defineVariable binds ident, a let name, parameter or loop variable, in
+// env.
+func defineVariable(ident *ast.Identifier, env *object.Environment, val object.Object) {
+	if !ident.Binding.Resolved {
+		env.Set(ident.Value, val)
+		return
+	}
+
+	env.Store(ident.Binding.Depth, ident.Binding.Slot, val)
+}
+
+// assignVariable rebinds the existing variable ident names. It reports
+// false if there is none.
+func assignVariable(ident *ast.Identifier, env *object.Environment, val object.Object) bool {
+	if !ident.Binding.Resolved {
+		return env.Assign(ident.Value, val)
+	}
+
+	if env.Lookup(ident.Binding.Depth, ident.Binding.Slot) == nil {
+		return false
+	}
+
+	env.Store(ident.Binding.Depth, ident.Binding.Slot, val)
+	return true
+}
+
 func evalExpressions(arguments []ast.Expression, env *object.Environment) []object.Object {
 	results := []object.Object{}
 
 	for _, argument := range arguments {
 		result := Eval(argument, env)
-		if isError(result) {
+		if escapes(result) {
 			return []object.Object{result}
 		}
 		results = append(results, result)
@@ -231,6 +319,9 @@ func evalExpressions(arguments []ast.Expression, env *object.Environment) []obje
 
 func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(node.Condition, env)
+	if escapes(condition) {
+		return condition
+	}
 	var returnValue object.Object
 	if isTruthy(condition) {
 		returnValue = Eval(node.Consequence, env)
@@ -255,8 +346,25 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
+// evalLogicalExpression evaluates the right operand of && and || only when
+// the left one does not already decide the result, and returns whichever
+// operand decided it.
+func evalLogicalExpression(node *ast.InfixExpression, left object.Object, env *object.Environment) object.Object {
+	if isTruthy(left) == (node.Operator == token.OR) {
+		return left
+	}
+
+	return Eval(node.Right, env)
+}
+
 func evalInfixExpression(left object.Object, right object.Object, operator string) object.Object {
 	switch {
+	case isNumeric(left) && isNumeric(right) &&
+		(left.Type() == object.FLOAT_OBJ || right.Type() == object.FLOAT_OBJ):
+		return evalFloatInfixExpression(toFloat(left), toFloat(right), operator)
+	case isInteger(left) && isInteger(right) &&
+		(left.Type() == object.BIGINT_OBJ || right.Type() == object.BIGINT_OBJ):
+		return evalBigIntInfixExpression(left, right, operator)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s + %s", left.Type(), right.Type())
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
@@ -285,27 +393,121 @@ func evalStringInfixExpression(left *object.String, right *object.String, operat
 	}
 }
 
+// evalIntegerInfixExpression does int64 arithmetic, handing the operation
+// to evalBigIntInfixExpression when the result would overflow.
 func evalIntegerInfixExpression(left *object.Integer, right *object.Integer, operator string) object.Object {
+	l, r := left.Value, right.Value
+
 	switch operator {
 	case token.PLUS:
-		return &object.Integer{Value: left.Value + right.Value}
+		if sum := l + r; (sum > l) == (r > 0) {
+			return &object.Integer{Value: sum}
+		}
 	case token.MINUS:
-		return &object.Integer{Value: left.Value - right.Value}
+		if diff := l - r; (diff < l) == (r > 0) {
+			return &object.Integer{Value: diff}
+		}
 	case token.ASTERISK:
-		return &object.Integer{Value: left.Value * right.Value}
+		if l == 0 || r == 0 {
+			return &object.Integer{Value: 0}
+		}
+		if product := l * r; product/r == l && !(l == math.MinInt64 && r == -1) {
+			return &object.Integer{Value: product}
+		}
 	case token.SLASH:
-		return &object.Integer{Value: left.Value / right.Value}
+		if r == 0 {
+			return newError("division by zero")
+		}
+		if !(l == math.MinInt64 && r == -1) {
+			return &object.Integer{Value: l / r}
+		}
+	case token.PERCENT:
+		if r == 0 {
+			return newError("division by zero")
+		}
+		return &object.Integer{Value: l % r}
 	case token.EQ:
-		return nativeBoolToBooleanObject(left.Value == right.Value)
+		return nativeBoolToBooleanObject(l == r)
 	case token.NOT_EQ:
-		return nativeBoolToBooleanObject(left.Value != right.Value)
+		return nativeBoolToBooleanObject(l != r)
 	case token.LT:
-		return nativeBoolToBooleanObject(left.Value < right.Value)
+		return nativeBoolToBooleanObject(l < r)
 	case token.GT:
-		return nativeBoolToBooleanObject(left.Value > right.Value)
+		return nativeBoolToBooleanObject(l > r)
+	case token.LT_EQ:
+		return nativeBoolToBooleanObject(l <= r)
+	case token.GT_EQ:
+		return nativeBoolToBooleanObject(l >= r)
+	case token.BIT_AND:
+		return &object.Integer{Value: l & r}
+	case token.BIT_OR:
+		return &object.Integer{Value: l | r}
+	case token.BIT_XOR:
+		return &object.Integer{Value: l ^ r}
+	case token.SHL:
+		if r < 0 {
+			return newError("negative shift count: %d", r)
+		}
+		if shifted := l << r; shifted>>r == l {
+			return &object.Integer{Value: shifted}
+		}
+	case token.SHR:
+		if r < 0 {
+			return newError("negative shift count: %d", r)
+		}
+		return &object.Integer{Value: l >> r}
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
+
+	return evalBigIntInfixExpression(left, right, operator)
+}
+
+func evalFloatInfixExpression(left, right float64, operator string) object.Object {
+	switch operator {
+	case token.PLUS:
+		return &object.Float{Value: left + right}
+	case token.MINUS:
+		return &object.Float{Value: left - right}
+	case token.ASTERISK:
+		return &object.Float{Value: left * right}
+	case token.SLASH:
+		return &object.Float{Value: left / right}
+	case token.PERCENT:
+		return &object.Float{Value: math.Mod(left, right)}
+	case token.EQ:
+		return nativeBoolToBooleanObject(left == right)
+	case token.NOT_EQ:
+		return nativeBoolToBooleanObject(left != right)
+	case token.LT:
+		return nativeBoolToBooleanObject(left < right)
+	case token.GT:
+		return nativeBoolToBooleanObject(left > right)
+	case token.LT_EQ:
+		return nativeBoolToBooleanObject(left <= right)
+	case token.GT_EQ:
+		return nativeBoolToBooleanObject(left >= right)
+	default:
+		return newError("unknown operator: %s %s %s", object.FLOAT_OBJ, operator, object.FLOAT_OBJ)
+	}
+}
+
+func isNumeric(obj object.Object) bool {
+	return isInteger(obj) || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.BigInt:
+		value, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return value
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
 }
 
 func evalPrefixExpression(operator string, object object.Object) object.Object {
@@ -314,18 +516,37 @@ func evalPrefixExpression(operator string, object object.Object) object.Object {
 		return evalBangOperator(object)
 	case token.MINUS:
 		return evalMinusPrefixOperator(object)
+	case token.BIT_NOT:
+		return evalBitNotPrefixOperator(object)
 	default:
 		return newError("unknown operator: %s%s", operator, object.Type())
 	}
 }
 
 func evalMinusPrefixOperator(o object.Object) object.Object {
-	if o.Type() != object.INTEGER_OBJ {
+	switch o := o.(type) {
+	case *object.Integer:
+		if o.Value == math.MinInt64 {
+			return object.NewInteger(new(big.Int).Neg(big.NewInt(o.Value)))
+		}
+		return &object.Integer{Value: -o.Value}
+	case *object.BigInt:
+		return object.NewInteger(new(big.Int).Neg(o.Value))
+	case *object.Float:
+		return &object.Float{Value: -o.Value}
+	default:
 		return newError("unknown operator: %s%s", token.MINUS, o.Type())
 	}
+}
 
-	return &object.Integer{
-		Value: -o.(*object.Integer).Value,
+func evalBitNotPrefixOperator(o object.Object) object.Object {
+	switch o := o.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: ^o.Value}
+	case *object.BigInt:
+		return object.NewInteger(new(big.Int).Not(o.Value))
+	default:
+		return newError("unknown operator: %s%s", token.BIT_NOT, o.Type())
 	}
 }
 
@@ -357,7 +578,7 @@ func evalBlockStatement(statements []ast.Statement, env *object.Environment) obj
 
 		if result != nil {
 			switch result.Type() {
-			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
 				return result
 			}
 		}
@@ -386,9 +607,38 @@ func newError(format string, a ...any) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+func newErrorAt(node ast.Node, format string, a ...any) *object.Error {
+	err := newError(format, a...)
+	err.Pos = node.Pos()
+	return err
+}
+
+// withPosition attributes an error that does not know where it happened yet
+// to the given node.
+func withPosition(obj object.Object, node ast.Node) object.Object {
+	if err, ok := obj.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+	return obj
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
 	}
 	return false
 }
+
+// escapes reports whether obj is not a value at all but an error, return,
+// break or continue on its way out to the statement that handles it. The
+// expression that evaluated it stops there and passes it on unchanged.
+func escapes(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+	switch obj.Type() {
+	case object.ERROR_OBJ, object.RETURN_VALUE_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	}
+	return false
+}
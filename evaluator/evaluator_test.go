@@ -1,6 +1,7 @@
 package evaluator
 
 import (
+	"monkey/ast"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
@@ -27,6 +28,20 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"0xff + 0o10 + 0b11", 266},
+		{"1_000 * 2", 2000},
+		{"12 & 10", 8},
+		{"12 | 10", 14},
+		{"12 ^ 10", 6},
+		{"~0", -1},
+		{"~5", -6},
+		{"1 << 10", 1024},
+		{"-16 >> 2", -4},
+		{"1 + 1 << 2", 8},
+		{"0xf0 & 0x3c | 1", 49},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"10 - 9 % 4 * 2", 8},
 	}
 
 	for _, tt := range tests {
@@ -46,6 +61,12 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"1 > 2", false},
 		{"1 < 1", false},
 		{"1 > 1", false},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{"1.5 <= 2", true},
+		{"(1 << 64) >= (1 << 63)", true},
 		{"1 == 1", true},
 		{"1 != 1", false},
 		{"1 == 2", false},
@@ -220,6 +241,30 @@ func TestErrorHandling(t *testing.T) {
 			`"Hello" - "World"`,
 			"unknown operator: STRING - STRING",
 		},
+		{
+			"1 << -1",
+			"negative shift count: -1",
+		},
+		{
+			"~true",
+			"unknown operator: ~BOOLEAN",
+		},
+		{
+			"(1 << 64) / 0",
+			"division by zero",
+		},
+		{
+			"5 % 0",
+			"division by zero",
+		},
+		{
+			"true && -false",
+			"unknown operator: -BOOLEAN",
+		},
+		{
+			"(1 << 64) + true",
+			"type mismatch: BIGINT + BOOLEAN",
+		},
 		{
 			`{"name":"Monkey"}[fn(x) {x}];'`,
 			"unusable as hash key: FUNCTION",
@@ -539,12 +584,12 @@ func TestHashLiterals(t *testing.T) {
 		FALSE.HashKey():                            6,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
 
 	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+		pair, ok := result.Get(expectedKey)
 		if !ok {
 			t.Errorf("no pair for given key in Pairs")
 		}
@@ -553,6 +598,26 @@ func TestHashLiterals(t *testing.T) {
 	}
 }
 
+func TestHashesKeepInsertionOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, 3: 4, true: 5}`, "{b: 1, a: 2, 3: 4, true: 5}"},
+		{`{"b": 1, "a": 2, "b": 3}`, "{b: 3, a: 2}"},
+		{`let h = {"b": 1}; h["a"] = 2; h["b"] = 3; h`, "{b: 3, a: 2}"},
+		{`let r = []; for (k in {"z": 1, "y": 2, "x": 3}) { r = push(r, k) }; r`, "[z, y, x]"},
+		{`let s = ""; let f = fn(x) { s = s + x; x }; {f("b"): f("1"), f("a"): f("2")}; s`, "b1a2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: wrong value. got=%s, want=%s", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+}
+
 func TestHashIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -598,3 +663,684 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + true;", "ERROR: 1:3: type mismatch: INTEGER + BOOLEAN"},
+		{"let a = 1;\n  foobar", "ERROR: 2:3: identifier not found: foobar"},
+		{"let f = fn() {\n  -true\n};\nf()", "ERROR: 2:3: unknown operator: -BOOLEAN"},
+		{`len(1, 2)`, "ERROR: 1:4: wrong number of arguments. got=2, want=1"},
+		{"fn(a) { a }()", "ERROR: 1:12: wrong number of arguments: want=1, got=0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)",
+				evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Inspect() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q",
+				tt.expected, errObj.Inspect())
+		}
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let name = "Monkey"; "Hello ${name}!"`, "Hello Monkey!"},
+		{`"${1 + 2} apples"`, "3 apples"},
+		{`let xs = [1, 2]; "xs=${xs}, len=${len(xs)}"`, "xs=[1, 2], len=2"},
+		{`"outer ${"inner ${true}"}"`, "outer inner true"},
+		{"`no ${interpolation}`", "no ${interpolation}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Fatalf("object is not String. got=%T (%+v)", evaluated, evaluated)
+		}
+
+		if str.Value != tt.expected {
+			t.Errorf("String has wrong value. expected=%q, got=%q", tt.expected, str.Value)
+		}
+	}
+
+	errObj, ok := testEval(`"${missing}"`).(*object.Error)
+	if !ok || errObj.Message != "identifier not found: missing" || errObj.Pos.Column != 4 {
+		t.Errorf("wrong error for missing identifier. got=%+v", errObj)
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"10 / 4.0", 2.5},
+		{"2 * 1.25", 2.5},
+		{"1e3 - 1", 999},
+		{"7.5 % 2", 1.5},
+		{"float(3)", 3},
+		{`float("0.25")`, 0.25},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestMixedNumericComparison(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 < 1.5", true},
+		{"2.5 > 3", false},
+		{"1 == 1.0", true},
+		{"1.5 != 1.5", false},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestNumericConversionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"int(3.9)", 3},
+		{"int(-3.9)", -3},
+		{"int(7)", 7},
+		{`int("42")`, 42},
+		{`int("4.2")`, `could not convert "4.2" to INTEGER`},
+		{`int(true)`, "argument to `int` not supported, got BOOLEAN"},
+		{`float("x")`, `could not convert "x" to FLOAT`},
+		{"int(0.0 / 0.0)", "float NaN out of integer range"},
+		{"1 / 0", "division by zero"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"4294967296 * 4294967296", "18446744073709551616"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"1 << 64", "18446744073709551616"},
+		{"99999999999999999999", "99999999999999999999"},
+		{"0x1_0000_0000_0000_0000", "18446744073709551616"},
+		{"100000000000000000000 / 10", "10000000000000000000"},
+		{"~(1 << 64)", "-18446744073709551617"},
+		{`int("123456789012345678901234567890")`, "123456789012345678901234567890"},
+		{"int(1e20)", "100000000000000000000"},
+		{`let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(25)`,
+			"15511210043330985984000000"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		result, ok := evaluated.(*object.BigInt)
+		if !ok {
+			t.Errorf("%s: object is not BigInt. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s: wrong value. got=%s, want=%s", tt.input, result.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestBigIntegersShrinkAndCompare(t *testing.T) {
+	testIntegerObject(t, testEval("(9223372036854775807 + 1) - 1"), 9223372036854775807)
+	testIntegerObject(t, testEval("99999999999999999999 - 99999999999999999998"), 1)
+	testIntegerObject(t, testEval("-9223372036854775807 - 1"), -9223372036854775807-1)
+	testIntegerObject(t, testEval(`{18446744073709551616: 1}[1 << 64]`), 1)
+	testFloatObject(t, testEval("float(1 << 64)"), 18446744073709551616)
+	testFloatObject(t, testEval("(1 << 64) + 0.5"), 18446744073709551616.5)
+
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"(1 << 64) > 1", true},
+		{"1 < (1 << 64)", true},
+		{"(1 << 64) == (1 << 64)", true},
+		{"(1 << 64) != (1 << 65)", true},
+		{"(1 << 64) == 5", false},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", 2},
+		{"1 || 2", 1},
+		{"if (false) { 1 } || 3", 3},
+		{"if (false) { 1 } && 3", nil},
+		{"false && missing", false},
+		{"true || missing", true},
+		{"1 < 2 && 2 <= 2 && 3 >= 2", true},
+		{"1 > 2 || 2 >= 3", false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let n = 0; while (true) { break; }; n", 0},
+		{"let f = fn() { while (true) { return 5; } }; f()", 5},
+		{"while (false) { 1 }", nil},
+		{"let f = fn(xs) { for (x in xs) { if (x > 2) { return x; } } }; f([1, 2, 3, 4])", 3},
+		{"let f = fn(s) { for (c in s) { return c; } }; f(\"hello\")", "h"},
+		{"let f = fn() { for (c in \"éa\") { return c; } }; f()", "é"},
+		{"let f = fn(h) { for (k in h) { return k; } }; f({\"key\": 1})", "key"},
+		{"let f = fn() { for (i in range(10)) { if (i * i > 20) { return i; } } }; f()", 5},
+		{"let f = fn() { for (i in range(10, 0, -4)) { if (i < 5) { return i; } } }; f()", 2},
+		{"let f = fn() { for (i in range(5)) { if (i < 3) { continue; } return i; } }; f()", 3},
+		{"let f = fn() { for (i in range(5)) { if (i == 2) { break; } } return 99; }; f()", 99},
+		{"for (i in []) { i }", nil},
+		{"let fs = fn() { for (i in [1, 2]) { return fn() { i }; } }; fs()()", 1},
+		{"let f = fn() { for (i in range(3)) { for (j in range(3)) { if (j == 1) { break; } if (i == 2) { return [i, j]; } } } }; f()[0]", 2},
+		{"for (x in 5) { x }", "cannot iterate over INTEGER"},
+		{"for (x in [1, 2]) { x + true }", "type mismatch: INTEGER + BOOLEAN"},
+		{"while (1 + true) { 1 }", "type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			switch result := evaluated.(type) {
+			case *object.String:
+				if result.Value != expected {
+					t.Errorf("%s: wrong string. expected=%q, got=%q", tt.input, expected, result.Value)
+				}
+			case *object.Error:
+				if result.Message != expected {
+					t.Errorf("%s: wrong error message. expected=%q, got=%q", tt.input, expected, result.Message)
+				}
+			default:
+				t.Errorf("%s: unexpected result %T (%+v)", tt.input, evaluated, evaluated)
+			}
+		default:
+			if evaluated != nil {
+				t.Errorf("%s: expected no value. got=%T (%+v)", tt.input, evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestLongLoopDoesNotRecurse(t *testing.T) {
+	input := `
+let count = fn(xs) {
+  for (x in xs) {
+    if (x == 999999) { return x; }
+  }
+};
+count(range(1000000))`
+
+	testIntegerObject(t, testEval(input), 999999)
+}
+
+func TestRangeBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"len(range(10))", 10},
+		{"len(range(2, 5))", 3},
+		{"len(range(0, 10, 3))", 4},
+		{"len(range(10, 0, -3))", 4},
+		{"len(range(5, 0))", 0},
+		{"range(1, 2, 0)", "range step must not be zero"},
+		{`range("a")`, "arguments to `range` must be INTEGER, got STRING"},
+		{"range()", "wrong number of arguments. got=0, want=1 to 3"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+
+	if inspect := testEval("range(0, 10, 2)").Inspect(); inspect != "range(0, 10, 2)" {
+		t.Errorf("wrong Inspect. got=%q", inspect)
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = 2", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x %= 4; x", 2},
+		{"let a = 1; let b = 2; a = b = 7; a + b", 14},
+		{"let n = 0; let inc = fn() { n += 1 }; inc(); inc(); n", 2},
+		{"let make = fn() { let n = 0; fn() { n += 1 } }; let c = make(); c(); c(); c()", 3},
+		{"let n = 0; let f = fn() { let n = 5; n = 6; n }; f() + n", 6},
+		{"let total = 0; for (i in range(1, 4)) { for (j in range(1, 4)) { total += i * j; } }; total", 36},
+		{"let i = 0; let sum = 0; while (i < 5) { i += 1; if (i == 2) { continue; } sum += i; }; sum", 13},
+		{"let a = [1, 2, 3]; a[1] = 20; a[1] + a[2]", 23},
+		{"let a = [1, 2, 3]; a[0] += 10; a[0]", 11},
+		{"let a = [1, 2]; let b = a; b[0] = 5; a[0]", 5},
+		{`let h = {"a": 1}; h["a"] = 2; h["b"] = 3; h["a"] + h["b"]`, 5},
+		{`let h = {"a": 1}; h["a"] *= 4; h["a"]`, 4},
+		{"let a = [[1], [2]]; a[1][0] = 9; a[1][0]", 9},
+		{"let big = 9223372036854775807; big += 1; big > 0", true},
+		{"y = 1", "identifier not found: y"},
+		{"y += 1", "identifier not found: y"},
+		{"let x = 1; x += true", "type mismatch: INTEGER + BOOLEAN"},
+		{"let a = [1]; a[1] = 2", "index out of range: 1"},
+		{"let a = [1]; a[-1] = 2", "index out of range: -1"},
+		{`let a = [1]; a["x"] = 2`, "array index must be INTEGER, got STRING"},
+		{`let h = {}; h[fn() {}] = 1`, "unusable as hash key: FUNCTION"},
+		{`let s = "abc"; s[0] = "x"`, "index assignment not supported: STRING"},
+		{`let h = {}; h["missing"] += 1`, "type mismatch: NULL + INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%s: object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("%s: wrong error message. expected=%q, got=%q", tt.input, expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestLexicalScoping(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let g = 1; let f = fn() { fn() { fn() { g } } }; f()()()", 1},
+		{"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)", 6},
+		{"let f = fn() { later }; let later = 5; f()", 5},
+		{"let x = 1; let f = fn() { let x = x + 1; x }; f() + x", 3},
+		{"let f = fn() { let count = fn(n) { if (n == 0) { 0 } else { 1 + count(n - 1) } }; count(3) }; f()", 3},
+		{"let outer = fn() { let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } }; " +
+			"let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } }; isEven(4) }; outer()", true},
+		{"let n = 0; let f = fn() { fn() { fn() { n += 1 } } }; f()()(); f()()(); n", 2},
+		{"let fs = []; for (i in range(3)) { fs = push(fs, fn() { i }); }; fs[0]() + fs[2]()", 2},
+		{"let len = fn(x) { 42 }; len([1])", 42},
+		{"let f = fn() { missing }; f()", "identifier not found: missing"},
+		{"let f = fn() { let a = 1; }; f(); a", "identifier not found: a"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%s: object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("%s: wrong error message. expected=%q, got=%q", tt.input, expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestEvalAcrossPrograms(t *testing.T) {
+	env := object.NewEnvironment()
+
+	inputs := []string{
+		"let counter = 0; let bump = fn() { counter += 1 };",
+		"bump(); bump();",
+		"let total = counter * 10;",
+	}
+	for _, input := range inputs {
+		program := parser.New(lexer.New(input)).ParseProgram()
+		if isError(Eval(program, env)) {
+			t.Fatalf("error evaluating %q", input)
+		}
+	}
+
+	val, ok := env.Get("total")
+	if !ok {
+		t.Fatalf("total not bound")
+	}
+	testIntegerObject(t, val, 20)
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(5)`, `5`},
+		{`quote(5 + 8)`, `(5 + 8)`},
+		{`quote(foobar)`, `foobar`},
+		{`quote(foobar + barfoo)`, `(foobar + barfoo)`},
+	}
+
+	for _, tt := range tests {
+		testQuoteObject(t, tt.input, tt.expected)
+	}
+}
+
+func TestQuoteUnquote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(unquote(4))`, `4`},
+		{`quote(unquote(4 + 4))`, `8`},
+		{`quote(8 + unquote(4 + 4))`, `(8 + 8)`},
+		{`quote(unquote(4 + 4) + 8)`, `(8 + 8)`},
+		{`let foobar = 8; quote(foobar)`, `foobar`},
+		{`let foobar = 8; quote(unquote(foobar))`, `8`},
+		{`quote(unquote(true))`, `true`},
+		{`quote(unquote(true == false))`, `false`},
+		{`quote(unquote(quote(4 + 4)))`, `(4 + 4)`},
+		{`let quotedInfixExpression = quote(4 + 4);
+		quote(unquote(4 + 4) + unquote(quotedInfixExpression))`, `(8 + (4 + 4))`},
+		{`quote(unquote(1.5) * unquote("s"))`, `(1.5 * s)`},
+		{`quote(unquote(2 * 9223372036854775807))`, `18446744073709551614`},
+		{`quote(unquote([1, [2, false]]))`, `[1, [2, false]]`},
+		{`let f = fn(x) { quote(unquote(x) + 1) }; f(1); f(2)`, `(2 + 1)`},
+	}
+
+	for _, tt := range tests {
+		testQuoteObject(t, tt.input, tt.expected)
+	}
+}
+
+func testQuoteObject(t *testing.T, input, expected string) {
+	t.Helper()
+
+	evaluated := testEval(input)
+	quote, ok := evaluated.(*object.Quote)
+	if !ok {
+		t.Fatalf("%s: expected *object.Quote. got=%T (%+v)", input, evaluated, evaluated)
+	}
+
+	if quote.Node == nil {
+		t.Fatalf("%s: quote.Node is nil", input)
+	}
+
+	if quote.Node.String() != expected {
+		t.Errorf("%s: not equal. got=%q, want=%q", input, quote.Node.String(), expected)
+	}
+}
+
+func TestDefineMacros(t *testing.T) {
+	input := `
+	let number = 1;
+	let function = fn(x, y) { x + y };
+	let mymacro = macro(x, y) { x + y; };
+	`
+
+	env := object.NewEnvironment()
+	program := testParseProgram(input)
+
+	DefineMacros(program, env)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("Wrong number of statements. got=%d", len(program.Statements))
+	}
+
+	if _, ok := env.Get("number"); ok {
+		t.Fatalf("number should not be defined")
+	}
+	if _, ok := env.Get("function"); ok {
+		t.Fatalf("function should not be defined")
+	}
+
+	obj, ok := env.Get("mymacro")
+	if !ok {
+		t.Fatalf("macro not in environment.")
+	}
+
+	macro, ok := obj.(*object.Macro)
+	if !ok {
+		t.Fatalf("object is not Macro. got=%T (%+v)", obj, obj)
+	}
+
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("Wrong number of macro parameters. got=%d", len(macro.Parameters))
+	}
+
+	if macro.Parameters[0].String() != "x" {
+		t.Fatalf("parameter is not 'x'. got=%q", macro.Parameters[0])
+	}
+	if macro.Parameters[1].String() != "y" {
+		t.Fatalf("parameter is not 'y'. got=%q", macro.Parameters[1])
+	}
+
+	expectedBody := "(x + y)"
+
+	if macro.Body.String() != expectedBody {
+		t.Fatalf("body is not %q. got=%q", expectedBody, macro.Body.String())
+	}
+}
+
+func TestExpandMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`
+			let infixExpression = macro() { quote(1 + 2); };
+
+			infixExpression();
+			`,
+			`(1 + 2)`,
+		},
+		{
+			`
+			let reverse = macro(a, b) { quote(unquote(b) - unquote(a)); };
+
+			reverse(2 + 2, 10 - 5);
+			`,
+			`(10 - 5) - (2 + 2)`,
+		},
+		{
+			`
+			let unless = macro(condition, consequence, alternative) {
+				quote(if (!(unquote(condition))) {
+					unquote(consequence);
+				} else {
+					unquote(alternative);
+				});
+			};
+
+			unless(10 > 5, puts("not greater"), puts("greater"));
+			`,
+			`if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`,
+		},
+		{
+			`
+			let twice = macro(x) { quote(unquote(x) + unquote(x)) };
+
+			twice(1); twice(2);
+			`,
+			`1 + 1; 2 + 2`,
+		},
+	}
+
+	for _, tt := range tests {
+		expected := testParseProgram(tt.expected)
+		program := testParseProgram(tt.input)
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Fatalf("expansion failed: %s", err.Inspect())
+		}
+
+		if expanded.String() != expected.String() {
+			t.Errorf("not equal. want=%q, got=%q", expected.String(), expanded.String())
+		}
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let m = macro(a) { quote(a) }; m(1, 2)", "1:33: wrong number of arguments to macro m: want=1, got=2"},
+		{"let m = macro() { 5 }; m()", "1:25: macro m must return quoted code, got INTEGER"},
+		{"let m = macro() { x }; m()", "1:19: identifier not found: x"},
+		{"let m = macro() { quote(unquote(fn() {})) }; m()", "1:32: cannot unquote FUNCTION"},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		_, err := ExpandMacros(program, env)
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.input)
+			continue
+		}
+
+		if got := err.Pos.String() + ": " + err.Message; got != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	evaluated := testEval("fn() { macro(x) { x } }()")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Message != "macros can only be defined by top-level let statements" {
+		t.Errorf("wrong result for a nested macro literal. got=%s", evaluated.Inspect())
+	}
+}
+
+func testParseProgram(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func BenchmarkFibonacci(b *testing.B) {
+	program := parser.New(lexer.New(`
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+fib(20)`)).ParseProgram()
+
+	for i := 0; i < b.N; i++ {
+		Eval(program, object.NewEnvironment())
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+		return false
+	}
+
+	return true
+}
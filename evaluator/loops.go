@@ -0,0 +1,67 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+func evalWhileStatement(node *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(node.Condition, env)
+		if escapes(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return nil
+		}
+
+		if result, done := evalLoopBody(node.Body, env); done {
+			return result
+		}
+	}
+}
+
+// evalForStatement runs the body once per element of the iterable, each
+// time in a fresh environment holding the loop variable so that closures
+// created in the body capture that iteration's value.
+func evalForStatement(node *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if escapes(iterable) {
+		return iterable
+	}
+
+	it, ok := object.NewIterator(iterable)
+	if !ok {
+		return newErrorAt(node.Iterable, "cannot iterate over %s", iterable.Type())
+	}
+
+	for element, ok := it.Next(); ok; element, ok = it.Next() {
+		loopEnv := object.NewFrame(env, node.Locals)
+		defineVariable(node.Variable, loopEnv, element)
+
+		if result, done := evalLoopBody(node.Body, loopEnv); done {
+			return result
+		}
+	}
+
+	return nil
+}
+
+// evalLoopBody runs one iteration. done is set when the loop must stop, in
+// which case result is what the loop statement evaluates to: nil after a
+// break, or the return value or error that ended it.
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) (result object.Object, done bool) {
+	result = Eval(body, env)
+	if result == nil {
+		return nil, false
+	}
+
+	switch result.Type() {
+	case object.BREAK_OBJ:
+		return nil, true
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+		return result, true
+	}
+
+	return nil, false
+}
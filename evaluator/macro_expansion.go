@@ -0,0 +1,141 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+	"monkey/resolver"
+)
+
+// DefineMacros binds in env the macros defined by the top-level let
+// statements of program, and removes those statements from it.
+func DefineMacros(program *ast.Program, env *object.Environment) {
+	definitions := []int{}
+
+	for i, statement := range program.Statements {
+		if isMacroDefinition(statement) {
+			addMacro(statement, env)
+			definitions = append(definitions, i)
+		}
+	}
+
+	for i := len(definitions) - 1; i >= 0; i-- {
+		definitionIndex := definitions[i]
+		program.Statements = append(
+			program.Statements[:definitionIndex],
+			program.Statements[definitionIndex+1:]...,
+		)
+	}
+}
+
+func isMacroDefinition(node ast.Statement) bool {
+	letStatement, ok := node.(*ast.LetStatement)
+	if !ok {
+		return false
+	}
+
+	_, ok = letStatement.Value.(*ast.MacroLiteral)
+	return ok
+}
+
+func addMacro(stmt ast.Statement, env *object.Environment) {
+	letStatement, _ := stmt.(*ast.LetStatement)
+	macroLiteral, _ := letStatement.Value.(*ast.MacroLiteral)
+
+	// The body runs in a frame of its own inside env, like a function's.
+	resolver.Resolve(macroLiteral, env)
+
+	macro := &object.Macro{
+		Parameters: macroLiteral.Parameters,
+		Body:       macroLiteral.Body,
+		Locals:     macroLiteral.Locals,
+		Env:        env,
+	}
+
+	env.Set(letStatement.Name.Value, macro)
+}
+
+// ExpandMacros replaces the calls in program to the macros in env with
+// the code the macros return for their arguments, quoted. Calls are
+// expanded innermost first, and the code a macro returns is not expanded
+// again. It returns the error of the first expansion that fails.
+func ExpandMacros(program ast.Node, env *object.Environment) (ast.Node, *object.Error) {
+	var err *object.Error
+
+	expanded := ast.Modify(program, func(node ast.Node) ast.Node {
+		callExpression, ok := node.(*ast.CallExpression)
+		if !ok || err != nil {
+			return node
+		}
+
+		macro, ok := isMacroCall(callExpression, env)
+		if !ok {
+			return node
+		}
+
+		if len(callExpression.Arguments) != len(macro.Parameters) {
+			err = newErrorAt(callExpression, "wrong number of arguments to macro %s: want=%d, got=%d",
+				callExpression.Function, len(macro.Parameters), len(callExpression.Arguments))
+			return node
+		}
+
+		evalEnv := extendMacroEnv(macro, quoteArgs(callExpression))
+		evaluated := unwrapReturnValue(Eval(macro.Body, evalEnv))
+		if errObj, ok := evaluated.(*object.Error); ok {
+			err = errObj
+			return node
+		}
+
+		quote, ok := evaluated.(*object.Quote)
+		if !ok {
+			err = newErrorAt(callExpression, "macro %s must return quoted code, got %s",
+				callExpression.Function, typeOf(evaluated))
+			return node
+		}
+
+		return quote.Node
+	})
+
+	return expanded, err
+}
+
+func isMacroCall(exp *ast.CallExpression, env *object.Environment) (*object.Macro, bool) {
+	identifier, ok := exp.Function.(*ast.Identifier)
+	if !ok {
+		return nil, false
+	}
+
+	obj, ok := env.Get(identifier.Value)
+	if !ok {
+		return nil, false
+	}
+
+	macro, ok := obj.(*object.Macro)
+	return macro, ok
+}
+
+func quoteArgs(exp *ast.CallExpression) []*object.Quote {
+	args := []*object.Quote{}
+
+	for _, a := range exp.Arguments {
+		args = append(args, &object.Quote{Node: a})
+	}
+
+	return args
+}
+
+func extendMacroEnv(macro *object.Macro, args []*object.Quote) *object.Environment {
+	extended := object.NewFrame(macro.Env, macro.Locals)
+
+	for paramIdx, param := range macro.Parameters {
+		defineVariable(param, extended, args[paramIdx])
+	}
+
+	return extended
+}
+
+func typeOf(obj object.Object) object.ObjectType {
+	if obj == nil {
+		return object.NULL_OBJ
+	}
+	return obj.Type()
+}
@@ -0,0 +1,36 @@
+package evaluator
+
+import "monkey/object"
+
+// The functions below apply the language's operators and builtins to
+// values. They are what Eval uses, exported so that the vm computes the
+// same results and reports the same errors. Errors they return carry no
+// position; the caller knows where the operation came from.
+
+// Infix applies a binary operator other than && and ||.
+func Infix(operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(left, right, operator)
+}
+
+func Prefix(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+func Index(left, index object.Object) object.Object {
+	return applyIndex(left, index)
+}
+
+// SetIndex stores val at index of an array or hash and returns val.
+func SetIndex(left, index, val object.Object) object.Object {
+	return setIndex(left, index, val)
+}
+
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+// Builtin returns the builtin function called name.
+func Builtin(name string) (*object.Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
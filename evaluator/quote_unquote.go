@@ -0,0 +1,121 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+	"monkey/token"
+	"strconv"
+)
+
+// IsQuoteCall reports whether call is quote(...), which returns its
+// argument unevaluated instead of calling a function.
+func IsQuoteCall(call *ast.CallExpression) bool {
+	ident, ok := call.Function.(*ast.Identifier)
+	return ok && ident.Value == "quote"
+}
+
+func isUnquoteCall(call *ast.CallExpression) bool {
+	ident, ok := call.Function.(*ast.Identifier)
+	return ok && ident.Value == "unquote" && len(call.Arguments) == 1
+}
+
+func quote(call *ast.CallExpression, env *object.Environment) object.Object {
+	if len(call.Arguments) != 1 {
+		return newErrorAt(call, "wrong number of arguments. got=%d, want=1", len(call.Arguments))
+	}
+
+	return Quote(call.Arguments[0], func(i int, arg ast.Expression) object.Object {
+		return Eval(arg, env)
+	})
+}
+
+// Quote returns a Quote of a copy of node in which the unquote(arg) calls
+// are replaced by the code for the value unquote returns for their
+// argument. The calls are numbered in the order UnquoteArguments lists
+// them. If unquote returns an error, or a value that cannot be turned back
+// into code, Quote returns the error.
+func Quote(node ast.Node, unquote func(i int, arg ast.Expression) object.Object) object.Object {
+	var err *object.Error
+	i := 0
+
+	node = ast.Modify(ast.Copy(node), func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok || !isUnquoteCall(call) || err != nil {
+			return node
+		}
+
+		val := unquote(i, call.Arguments[0])
+		i++
+		if errObj, ok := val.(*object.Error); ok {
+			err = errObj
+			return node
+		}
+
+		converted, ok := objectToNode(val, call.Token)
+		if !ok {
+			err = newErrorAt(call, "cannot unquote %s", val.Type())
+			return node
+		}
+		return converted
+	})
+
+	if err != nil {
+		return err
+	}
+	return &object.Quote{Node: node}
+}
+
+// UnquoteArguments returns the arguments of the unquote calls in node, in
+// the order Quote replaces them.
+func UnquoteArguments(node ast.Node) []ast.Expression {
+	var args []ast.Expression
+
+	ast.Modify(ast.Copy(node), func(node ast.Node) ast.Node {
+		if call, ok := node.(*ast.CallExpression); ok && isUnquoteCall(call) {
+			args = append(args, call.Arguments[0])
+		}
+		return node
+	})
+
+	return args
+}
+
+// objectToNode returns code that evaluates to obj, with the position of
+// tok. Quoted code stands for itself.
+func objectToNode(obj object.Object, tok token.Token) (ast.Node, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		t := token.Token{Type: token.INT, Literal: strconv.FormatInt(obj.Value, 10), Pos: tok.Pos}
+		return &ast.IntegerLiteral{Token: t, Value: obj.Value}, true
+	case *object.BigInt:
+		t := token.Token{Type: token.INT, Literal: obj.Value.String(), Pos: tok.Pos}
+		return &ast.BigIntegerLiteral{Token: t, Value: obj.Value}, true
+	case *object.Float:
+		t := token.Token{Type: token.FLOAT, Literal: obj.Inspect(), Pos: tok.Pos}
+		return &ast.FloatLiteral{Token: t, Value: obj.Value}, true
+	case *object.String:
+		t := token.Token{Type: token.STRING, Literal: obj.Value, Pos: tok.Pos}
+		return &ast.StringLiteral{Token: t, Value: obj.Value}, true
+	case *object.Boolean:
+		t := token.Token{Type: token.FALSE, Literal: "false", Pos: tok.Pos}
+		if obj.Value {
+			t = token.Token{Type: token.TRUE, Literal: "true", Pos: tok.Pos}
+		}
+		return &ast.Boolean{Token: t, Value: obj.Value}, true
+	case *object.Array:
+		t := token.Token{Type: token.LBRACKET, Literal: "[", Pos: tok.Pos}
+		array := &ast.ArrayLiteral{Token: t, Elements: []ast.Expression{}}
+		for _, el := range obj.Elements {
+			node, ok := objectToNode(el, tok)
+			if !ok {
+				return nil, false
+			}
+			array.Elements = append(array.Elements, node.(ast.Expression))
+		}
+		return array, true
+	case *object.Quote:
+		return ast.Copy(obj.Node), true
+	default:
+		return nil, false
+	}
+}
@@ -4,22 +4,80 @@ import (
 	"monkey/token"
 )
 
+type Mode uint
+
+const (
+	// ScanComments makes NextToken return comments as COMMENT tokens
+	// instead of skipping them.
+	ScanComments Mode = 1 << iota
+)
+
 type Lexer struct {
+	mode         Mode
+	filename     string
+	base         int
 	input        string
 	position     int
 	readPosition int
 	ch           byte
+
+	line   int
+	column int
 }
 
 func New(input string) *Lexer {
+	return NewFile("", input)
+}
+
+// NewFile creates a lexer whose token positions are reported relative to
+// the given file name.
+func NewFile(filename, input string) *Lexer {
 	l := &Lexer{
-		input: input,
+		filename: filename,
+		input:    input,
+		line:     1,
 	}
 	l.readChar()
+	l.skipShebang()
 	return l
 }
 
+// NewAt creates a lexer for a fragment of a larger source that starts at
+// pos, such as the expression inside a string interpolation.
+func NewAt(input string, pos token.Position) *Lexer {
+	l := &Lexer{
+		filename: pos.Filename,
+		base:     pos.Offset,
+		input:    input,
+		line:     pos.Line,
+		column:   pos.Column - 1,
+	}
+	l.readChar()
+	return l
+}
+
+func (l *Lexer) SetMode(mode Mode) {
+	l.mode = mode
+}
+
+// skipShebang skips a `#!` interpreter line at the very start of the input so
+// scripts can be made executable.
+func (l *Lexer) skipShebang() {
+	if l.ch != '#' || l.peekChar() != '!' {
+		return
+	}
+
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+}
+
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -28,25 +86,91 @@ func (l *Lexer) readChar() {
 
 	l.position = l.readPosition
 	l.readPosition++
+	l.column++
 }
 
 func (l *Lexer) peekChar() byte {
 	if l.readPosition >= len(l.input) {
-		l.ch = 0
+		return 0
 	}
 	return l.input[l.readPosition]
 }
 
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.base + l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
+// readString reads a double-quoted string and returns the source text
+// between the quotes. Escapes are skipped over and `${...}` interpolations
+// are matched up to their closing brace, but neither is decoded here.
 func (l *Lexer) readString() (string, bool) {
 	l.readChar()
 	startPosition := l.position
 
-	for l.ch != '"' && l.ch != 0 {
+	for l.ch != '"' {
+		switch {
+		case l.ch == 0:
+			return "", false
+		case l.ch == '\\':
+			l.readChar()
+			if l.ch == 0 {
+				return "", false
+			}
+		case l.ch == '$' && l.peekChar() == '{':
+			l.readChar()
+			if !l.skipInterpolation() {
+				return "", false
+			}
+		}
 		l.readChar()
 	}
 
-	if l.ch != '"' {
-		return "", false
+	return l.input[startPosition:l.position], true
+}
+
+// skipInterpolation moves from the `{` opening an interpolation to the `}`
+// that closes it, stepping over nested braces and strings.
+func (l *Lexer) skipInterpolation() bool {
+	depth := 0
+
+	for {
+		switch l.ch {
+		case 0:
+			return false
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return true
+			}
+		case '"':
+			if _, ok := l.readString(); !ok {
+				return false
+			}
+		case '`':
+			if _, ok := l.readRawString(); !ok {
+				return false
+			}
+		}
+		l.readChar()
+	}
+}
+
+func (l *Lexer) readRawString() (string, bool) {
+	l.readChar()
+	startPosition := l.position
+
+	for l.ch != '`' {
+		if l.ch == 0 {
+			return "", false
+		}
+		l.readChar()
 	}
 
 	return l.input[startPosition:l.position], true
@@ -68,38 +192,155 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
+// readComment reads a `//` comment up to the end of the line or a `/* */`
+// comment, which may nest, and returns its text including the delimiters.
+// It reports false if a block comment is not terminated.
+func (l *Lexer) readComment() (string, bool) {
+	startPosition := l.position
+
+	if l.peekChar() == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		return l.input[startPosition:l.position], true
+	}
+
+	l.readChar()
+	l.readChar()
+
+	for depth := 1; depth > 0; {
+		switch {
+		case l.ch == 0:
+			return "", false
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+		}
+		l.readChar()
+	}
+
+	return l.input[startPosition:l.position], true
+}
+
+func (l *Lexer) isCommentStart() bool {
+	return l.ch == '/' && (l.peekChar() == '/' || l.peekChar() == '*')
+}
+
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
 
+	for l.isCommentStart() {
+		pos := l.currentPosition()
+
+		comment, ok := l.readComment()
+		if !ok {
+			return token.Token{Type: token.ILLEGAL, Literal: "/*", Pos: pos}
+		}
+
+		if l.mode&ScanComments != 0 {
+			return token.Token{Type: token.COMMENT, Literal: comment, Pos: pos}
+		}
+
+		l.skipWhitespace()
+	}
+
+	pos := l.currentPosition()
+
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
-			tok = token.Token{token.EQ, l.input[l.position : l.position+2]}
+			tok = token.Token{Type: token.EQ, Literal: l.input[l.position : l.position+2]}
 			l.readChar()
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = token.Token{Type: token.PLUS_ASSIGN, Literal: l.input[l.position : l.position+2]}
+			l.readChar()
+		} else {
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = token.Token{Type: token.MINUS_ASSIGN, Literal: l.input[l.position : l.position+2]}
+			l.readChar()
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '!':
 		if l.peekChar() == '=' {
-			tok = token.Token{token.NOT_EQ, l.input[l.position : l.position+2]}
+			tok = token.Token{Type: token.NOT_EQ, Literal: l.input[l.position : l.position+2]}
 			l.readChar()
 		} else {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '=' {
+			tok = token.Token{Type: token.ASTERISK_ASSIGN, Literal: l.input[l.position : l.position+2]}
+			l.readChar()
+		} else {
+			tok = newToken(token.ASTERISK, l.ch)
+		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekChar() == '=' {
+			tok = token.Token{Type: token.SLASH_ASSIGN, Literal: l.input[l.position : l.position+2]}
+			l.readChar()
+		} else {
+			tok = newToken(token.SLASH, l.ch)
+		}
+	case '%':
+		if l.peekChar() == '=' {
+			tok = token.Token{Type: token.PERCENT_ASSIGN, Literal: l.input[l.position : l.position+2]}
+			l.readChar()
+		} else {
+			tok = newToken(token.PERCENT, l.ch)
+		}
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		switch l.peekChar() {
+		case '<':
+			tok = token.Token{Type: token.SHL, Literal: l.input[l.position : l.position+2]}
+			l.readChar()
+		case '=':
+			tok = token.Token{Type: token.LT_EQ, Literal: l.input[l.position : l.position+2]}
+			l.readChar()
+		default:
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		switch l.peekChar() {
+		case '>':
+			tok = token.Token{Type: token.SHR, Literal: l.input[l.position : l.position+2]}
+			l.readChar()
+		case '=':
+			tok = token.Token{Type: token.GT_EQ, Literal: l.input[l.position : l.position+2]}
+			l.readChar()
+		default:
+			tok = newToken(token.GT, l.ch)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			tok = token.Token{Type: token.AND, Literal: l.input[l.position : l.position+2]}
+			l.readChar()
+		} else {
+			tok = newToken(token.BIT_AND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			tok = token.Token{Type: token.OR, Literal: l.input[l.position : l.position+2]}
+			l.readChar()
+		} else {
+			tok = newToken(token.BIT_OR, l.ch)
+		}
+	case '^':
+		tok = newToken(token.BIT_XOR, l.ch)
+	case '~':
+		tok = newToken(token.BIT_NOT, l.ch)
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case ';':
@@ -121,11 +362,21 @@ func (l *Lexer) NextToken() token.Token {
 	case '"':
 		stringValue, ok := l.readString()
 		if !ok {
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok = newToken(token.ILLEGAL, '"')
+			tok.Pos = pos
 			return tok
 		}
 		tok.Literal = stringValue
 		tok.Type = token.STRING
+	case '`':
+		stringValue, ok := l.readRawString()
+		if !ok {
+			tok = newToken(token.ILLEGAL, '`')
+			tok.Pos = pos
+			return tok
+		}
+		tok.Literal = stringValue
+		tok.Type = token.RAW_STRING
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -133,28 +384,77 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookUpIdentifierType(tok.Literal)
+			tok.Pos = pos
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Literal = l.readNumber()
-			tok.Type = token.INT
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Pos = pos
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
 		}
 	}
 
+	tok.Pos = pos
 	l.readChar()
 	return tok
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer, which may have a 0x, 0o or 0b base prefix,
+// or a float with an optional fraction and exponent, such as 3.14 or 1e-9.
+// Digits may be separated by underscores. The parser validates the digits.
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	startPosition := l.position
+	tokenType := token.TokenType(token.INT)
 
-	for isDigit(l.ch) {
+	if l.ch == '0' && isBasePrefix(l.peekChar()) {
+		l.readChar()
 		l.readChar()
+		for isHexDigit(l.ch) || l.ch == '_' {
+			l.readChar()
+		}
+		return l.input[startPosition:l.position], tokenType
 	}
 
-	return l.input[startPosition:l.position]
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if isDigit(next) || ((next == '+' || next == '-') && l.readPosition+1 < len(l.input) && isDigit(l.input[l.readPosition+1])) {
+			tokenType = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+
+	return l.input[startPosition:l.position], tokenType
+}
+
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) || l.ch == '_' {
+		l.readChar()
+	}
+}
+
+func isBasePrefix(ch byte) bool {
+	switch ch {
+	case 'x', 'X', 'o', 'O', 'b', 'B':
+		return true
+	}
+	return false
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
 }
 
 func isDigit(ch byte) bool {
@@ -15,7 +15,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -143,3 +143,396 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  x == "ab";
+`
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedLine   int
+		expectedColumn int
+		expectedOffset int
+	}{
+		{token.LET, 1, 1, 0},
+		{token.IDENT, 1, 5, 4},
+		{token.ASSIGN, 1, 7, 6},
+		{token.INT, 1, 9, 8},
+		{token.SEMICOLON, 1, 10, 9},
+		{token.IDENT, 2, 3, 13},
+		{token.EQ, 2, 5, 15},
+		{token.STRING, 2, 8, 18},
+		{token.SEMICOLON, 2, 12, 22},
+		{token.EOF, 3, 1, 24},
+	}
+
+	l := NewFile("test.mk", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos.Filename != "test.mk" {
+			t.Fatalf("tests[%d] - filename wrong. got=%q", i, tok.Pos.Filename)
+		}
+
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+
+		if tok.Pos.Offset != tt.expectedOffset {
+			t.Fatalf("tests[%d] - offset wrong. expected=%d, got=%d",
+				i, tt.expectedOffset, tok.Pos.Offset)
+		}
+	}
+}
+
+func TestShebangIsSkipped(t *testing.T) {
+	input := "#!/usr/bin/env monkey\nlet x = 1;"
+
+	l := New(input)
+
+	tok := l.NextToken()
+	if tok.Type != token.LET {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", token.LET, tok.Type)
+	}
+
+	if tok.Pos.Line != 2 || tok.Pos.Column != 1 {
+		t.Fatalf("position wrong. expected=2:1, got=%d:%d", tok.Pos.Line, tok.Pos.Column)
+	}
+
+	l = New("let x = 1; #!")
+	for tok = l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Type == token.ILLEGAL {
+			return
+		}
+	}
+	t.Fatalf("#! after the first line should not be skipped")
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+let x = 10 / 2; // trailing
+/* block
+   /* nested */ still comment */
+x /**/ * 2;
+`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.COMMENT, "// leading comment"},
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "10"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.COMMENT, "// trailing"},
+		{token.COMMENT, "/* block\n   /* nested */ still comment */"},
+		{token.IDENT, "x"},
+		{token.COMMENT, "/**/"},
+		{token.ASTERISK, "*"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	l.SetMode(ScanComments)
+
+	var withoutComments []token.TokenType
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tt.expectedType != token.COMMENT {
+			withoutComments = append(withoutComments, tt.expectedType)
+		}
+	}
+
+	l = New(input)
+	for i, expected := range withoutComments {
+		tok := l.NextToken()
+		if tok.Type != expected {
+			t.Fatalf("skipping comments: tokens[%d] wrong. expected=%q, got=%q",
+				i, expected, tok.Type)
+		}
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := New("1 /* open /* nested */")
+
+	l.NextToken()
+	tok := l.NextToken()
+
+	if tok.Type != token.ILLEGAL || tok.Literal != "/*" {
+		t.Fatalf("expected ILLEGAL /*, got %s %q", tok.Type, tok.Literal)
+	}
+
+	if tok.Pos.Column != 3 {
+		t.Fatalf("wrong column. expected=3, got=%d", tok.Pos.Column)
+	}
+}
+
+func TestStringLiterals(t *testing.T) {
+	input := "\"a \\\"quoted\\\" \\\\\" \"x ${f(\"}\")} y\" `raw \\n\nline` \"open"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING, `a \"quoted\" \\`},
+		{token.STRING, `x ${f("}")} y`},
+		{token.RAW_STRING, "raw \\n\nline"},
+		{token.ILLEGAL, `"`},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	input := `3 3.14 0.5 1e9 1e-9 2.5E+3 7.e 4e`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "3"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "0.5"},
+		{token.FLOAT, "1e9"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E+3"},
+		{token.INT, "7"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "e"},
+		{token.INT, "4"},
+		{token.IDENT, "e"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestIntegerBasesAndBitwiseOperators(t *testing.T) {
+	input := `0xFF 0o755 0B1010 1_000_000 0x_dead_BEEF a & b | c ^ ~d << 2 >> 1 < >`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "0xFF"},
+		{token.INT, "0o755"},
+		{token.INT, "0B1010"},
+		{token.INT, "1_000_000"},
+		{token.INT, "0x_dead_BEEF"},
+		{token.IDENT, "a"},
+		{token.BIT_AND, "&"},
+		{token.IDENT, "b"},
+		{token.BIT_OR, "|"},
+		{token.IDENT, "c"},
+		{token.BIT_XOR, "^"},
+		{token.BIT_NOT, "~"},
+		{token.IDENT, "d"},
+		{token.SHL, "<<"},
+		{token.INT, "2"},
+		{token.SHR, ">>"},
+		{token.INT, "1"},
+		{token.LT, "<"},
+		{token.GT, ">"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestLogicalAndComparisonOperators(t *testing.T) {
+	input := `a && b || c & d | e <= f >= g < h > i % j`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.AND, "&&"},
+		{token.IDENT, "b"},
+		{token.OR, "||"},
+		{token.IDENT, "c"},
+		{token.BIT_AND, "&"},
+		{token.IDENT, "d"},
+		{token.BIT_OR, "|"},
+		{token.IDENT, "e"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "f"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "g"},
+		{token.LT, "<"},
+		{token.IDENT, "h"},
+		{token.GT, ">"},
+		{token.IDENT, "i"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "j"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestLoopKeywords(t *testing.T) {
+	input := `while (x) { break; } for (i in xs) { continue; } inner macro(x, y) { x + y; }`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.WHILE, "while"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.BREAK, "break"},
+		{token.SEMICOLON, ";"},
+		{token.RBRACE, "}"},
+		{token.FOR, "for"},
+		{token.LPAREN, "("},
+		{token.IDENT, "i"},
+		{token.IN, "in"},
+		{token.IDENT, "xs"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.CONTINUE, "continue"},
+		{token.SEMICOLON, ";"},
+		{token.RBRACE, "}"},
+		{token.IDENT, "inner"},
+		{token.MACRO, "macro"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.COMMA, ","},
+		{token.IDENT, "y"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "x"},
+		{token.PLUS, "+"},
+		{token.IDENT, "y"},
+		{token.SEMICOLON, ";"},
+		{token.RBRACE, "}"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestAssignmentOperators(t *testing.T) {
+	input := `x = 1; x += 2; x -= 3; x *= 4; x /= 5; x %= 6; x == 7; x + 8`
+
+	expected := []token.TokenType{
+		token.IDENT, token.ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.PLUS_ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.MINUS_ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.ASTERISK_ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.SLASH_ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.PERCENT_ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.EQ, token.INT, token.SEMICOLON,
+		token.IDENT, token.PLUS, token.INT,
+		token.EOF,
+	}
+
+	l := New(input)
+
+	for i, tt := range expected {
+		tok := l.NextToken()
+
+		if tok.Type != tt {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt, tok.Type)
+		}
+	}
+}
@@ -2,18 +2,241 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"monkey/diagnostics"
 	"monkey/repl"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 )
 
+// Exit codes reported by the monkey command.
+const (
+	exitOK           = 0
+	exitRuntimeError = 1
+	exitSyntaxError  = 2
+	exitUsage        = 64
+	exitDataError    = 65
+	exitNoInput      = 66
+	exitCantCreate   = 73
+)
+
+const usage = `Usage:
+  monkey                       start the REPL, or run stdin if it is not a terminal
+  monkey run <file> [args...]  run a script, or a program compiled by build
+  monkey <file> [args...]      same as run
+  monkey -e <expr> [args...]   evaluate an expression and print its value
+  monkey build <file> [-o out] compile a script to bytecode, by default in
+                               a file named like it with the extension .mkc
+  monkey disasm <file>         print the bytecode of a script or .mkc file
+  monkey ast [--json] <file>   print the syntax tree of a script, as JSON
+                               with --json
+  monkey fmt [-w] [-d] [files...]
+                               print scripts, or stdin, formatted in the
+                               standard layout; -w writes the result back
+                               to the files, -d prints the changes instead
+  monkey help                  show this message
+
+Flags, given before the command:
+  -engine=eval|vm              run programs with the tree-walking evaluator
+                               (the default) or the bytecode virtual machine
+
+Script arguments are available to the program as the array 'args'.
+Exit status is 1 for runtime errors and 2 for syntax errors.
+`
+
 func main() {
-	usr, err := user.Current()
-	if err != nil {
-		panic(err)
+	os.Exit(run(os.Args[1:]))
+}
+
+// engine runs programs. It is chosen with the -engine flag.
+var engine = engines["eval"]
+
+func run(argv []string) int {
+	if len(argv) > 0 {
+		if flag, name, hasValue := strings.Cut(argv[0], "="); flag == "-engine" || flag == "--engine" {
+			argv = argv[1:]
+			if !hasValue {
+				if len(argv) == 0 {
+					return usageError("-engine requires eval or vm")
+				}
+				name, argv = argv[0], argv[1:]
+			}
+
+			var ok bool
+			if engine, ok = engines[name]; !ok {
+				return usageError("unknown engine " + name)
+			}
+		}
+	}
+
+	if len(argv) == 0 {
+		if !diagnostics.IsTerminal(os.Stdin) {
+			return runStdin(nil)
+		}
+		startREPL()
+		return exitOK
+	}
+
+	switch argv[0] {
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return exitOK
+	case "-e":
+		if len(argv) < 2 {
+			return usageError("-e requires an expression")
+		}
+		return execute("-e", argv[1], argv[2:], true)
+	case "-":
+		return runStdin(argv[1:])
+	case "run":
+		if len(argv) < 2 {
+			return usageError("run requires a file")
+		}
+		return runFile(argv[1], argv[2:])
+	case "build":
+		return runBuild(argv[1:])
+	case "disasm":
+		if len(argv) != 2 {
+			return usageError("disasm requires a file")
+		}
+		return disassemble(argv[1])
+	case "ast":
+		return runAST(argv[1:])
+	case "fmt":
+		return runFmt(argv[1:])
+	default:
+		if strings.HasPrefix(argv[0], "-") {
+			return usageError("unknown flag " + argv[0])
+		}
+		return runFile(argv[0], argv[1:])
 	}
-	fmt.Printf("Hello %s! This is the Monkey programming language!\n",
-		usr.Username)
+}
+
+func usageError(msg string) int {
+	fmt.Fprintf(os.Stderr, "monkey: %s\n\n%s", msg, usage)
+	return exitUsage
+}
+
+func startREPL() {
+	name := "there"
+	if usr, err := user.Current(); err == nil {
+		name = usr.Username
+	} else if env := os.Getenv("USER"); env != "" {
+		name = env
+	}
+
+	fmt.Printf("Hello %s! This is the Monkey programming language!\n", name)
 	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	repl.Start(os.Stdin, os.Stdout, engine)
+}
+
+func runStdin(args []string) int {
+	source, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "monkey: %s\n", err)
+		return exitNoInput
+	}
+
+	return execute("<stdin>", string(source), args, false)
+}
+
+func runFile(filename string, args []string) int {
+	if filepath.Ext(filename) == ".mkc" {
+		return executeBytecode(filename, args)
+	}
+
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "monkey: %s\n", err)
+		return exitNoInput
+	}
+
+	return execute(filename, string(source), args, false)
+}
+
+func runAST(argv []string) int {
+	var filename string
+	asJSON := false
+	for _, arg := range argv {
+		switch {
+		case arg == "-json" || arg == "--json":
+			asJSON = true
+		case filename == "":
+			filename = arg
+		default:
+			return usageError("ast takes one file")
+		}
+	}
+
+	if filename == "" {
+		return usageError("ast requires a file")
+	}
+
+	return printAST(filename, asJSON)
+}
+
+func runFmt(argv []string) int {
+	var files []string
+	write, showDiff := false, false
+	for _, arg := range argv {
+		switch arg {
+		case "-w":
+			write = true
+		case "-d":
+			showDiff = true
+		default:
+			if len(arg) > 1 && arg[0] == '-' {
+				return usageError("unknown fmt flag " + arg)
+			}
+			files = append(files, arg)
+		}
+	}
+
+	if len(files) == 0 {
+		if write {
+			return usageError("fmt -w requires files")
+		}
+		source, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "monkey: %s\n", err)
+			return exitNoInput
+		}
+		return formatSource("<stdin>", string(source), false, showDiff)
+	}
+
+	status := exitOK
+	for _, filename := range files {
+		if s := formatFile(filename, write, showDiff); status == exitOK {
+			status = s
+		}
+	}
+	return status
+}
+
+func runBuild(argv []string) int {
+	var filename, output string
+	for len(argv) > 0 {
+		switch {
+		case argv[0] == "-o":
+			if len(argv) < 2 {
+				return usageError("-o requires a file")
+			}
+			output, argv = argv[1], argv[2:]
+		case filename == "":
+			filename, argv = argv[0], argv[1:]
+		default:
+			return usageError("build takes one file")
+		}
+	}
+
+	if filename == "" {
+		return usageError("build requires a file")
+	}
+	if output == "" {
+		output = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".mkc"
+	}
+
+	return build(filename, output)
 }
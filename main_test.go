@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestRunExitStatus(t *testing.T) {
+	tests := []struct {
+		argv     []string
+		expected int
+	}{
+		{[]string{""}, exitNoInput},
+		{[]string{"-x"}, exitUsage},
+		{[]string{"-e", "1 +"}, exitSyntaxError},
+		{[]string{"-e", "1 + true"}, exitRuntimeError},
+	}
+
+	for _, tt := range tests {
+		if got := run(tt.argv); got != tt.expected {
+			t.Errorf("run(%q) = %d, want %d", tt.argv, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package object
+
+import "unicode/utf8"
+
+// Iterator steps through the elements a for loop visits: the elements of
+// an array as they were when the loop started, the keys of a hash, the
+// characters of a string or the integers of a range.
+type Iterator struct {
+	elements []Object
+	str      string
+	next     int64
+	step     int64
+	left     uint64
+}
+
+// NewIterator returns an iterator over obj. It reports false if obj cannot
+// be iterated over.
+func NewIterator(obj Object) (*Iterator, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		elements := append([]Object(nil), obj.Elements...)
+		return &Iterator{elements: elements, left: uint64(len(elements))}, true
+	case *Hash:
+		keys := make([]Object, 0, obj.Len())
+		for _, pair := range obj.Pairs() {
+			keys = append(keys, pair.Key)
+		}
+		return &Iterator{elements: keys, left: uint64(len(keys))}, true
+	case *String:
+		return &Iterator{str: obj.Value, left: uint64(utf8.RuneCountInString(obj.Value))}, true
+	case *Range:
+		return &Iterator{next: obj.Start, step: obj.Step, left: obj.Len()}, true
+	default:
+		return nil, false
+	}
+}
+
+// Next returns the next element. It reports false when there are none
+// left.
+func (it *Iterator) Next() (Object, bool) {
+	if it.left == 0 {
+		return nil, false
+	}
+	it.left--
+
+	switch {
+	case it.elements != nil:
+		element := it.elements[0]
+		it.elements = it.elements[1:]
+		return element, true
+	case it.str != "":
+		ch, size := utf8.DecodeRuneInString(it.str)
+		it.str = it.str[size:]
+		return &String{Value: string(ch)}, true
+	default:
+		i := it.next
+		it.next += it.step
+		return &Integer{Value: i}, true
+	}
+}
+
+func (it *Iterator) Type() ObjectType {
+	return ITERATOR_OBJ
+}
+
+func (it *Iterator) Inspect() string {
+	return "iterator"
+}
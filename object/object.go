@@ -4,7 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
 	"monkey/ast"
+	"monkey/code"
+	"monkey/token"
+	"strconv"
 	"strings"
 )
 
@@ -12,8 +17,10 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	BIGINT_OBJ       = "BIGINT"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
-	ARRAY_OBJ        = "BOOLEAN"
+	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
@@ -21,6 +28,15 @@ const (
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
 	BUILTIN_OBJ      = "BUILTIN"
+	RANGE_OBJ        = "RANGE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
+	QUOTE_OBJ        = "QUOTE"
+	MACRO_OBJ        = "MACRO"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ           = "CLOSURE"
+	ITERATOR_OBJ          = "ITERATOR"
 )
 
 type Object interface {
@@ -63,6 +79,48 @@ func (i *Integer) Inspect() string {
 	return fmt.Sprintf("%d", i.Value)
 }
 
+// BigInt is an integer outside the range of an int64. Arithmetic that
+// overflows an Integer produces a BigInt, and results that fit in an int64
+// are turned back into Integers by NewInteger.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Type() ObjectType {
+	return BIGINT_OBJ
+}
+
+func (b *BigInt) Inspect() string {
+	return b.Value.String()
+}
+
+// NewInteger returns an Integer if value fits in an int64 and a BigInt
+// otherwise.
+func NewInteger(value *big.Int) Object {
+	if value.IsInt64() {
+		return &Integer{Value: value.Int64()}
+	}
+	return &BigInt{Value: value}
+}
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType {
+	return FLOAT_OBJ
+}
+
+// Inspect formats the float so that whole numbers keep a fractional part
+// and cannot be mistaken for integers.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
 type String struct {
 	Value string
 }
@@ -105,8 +163,27 @@ func (rv *ReturnValue) Inspect() string {
 	return rv.Value.Inspect()
 }
 
+// Break and Continue signal a break or continue statement to the enclosing
+// loop, the way ReturnValue carries a return out of a function.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+
+func (b *Break) Inspect() string {
+	return "break"
+}
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+
+func (c *Continue) Inspect() string {
+	return "continue"
+}
+
 type Error struct {
 	Message string
+	Pos     token.Position
 }
 
 func (e *Error) Type() ObjectType {
@@ -114,12 +191,22 @@ func (e *Error) Type() ObjectType {
 }
 
 func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
 	return "ERROR: " + e.Message
 }
 
+// Environment is a frame of variable slots. The resolver numbers the
+// variables of each scope, so a variable is read by walking out a known
+// number of frames and indexing the slots. Environments made by
+// NewEnvironment can also grow new slots by name, which is how globals are
+// added as a program or REPL session goes on.
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	names  []string
+	values []Object
+	index  map[string]int // slot by name, for environments that grow
+	outer  *Environment
 }
 
 func ExtendEnvironment(outer *Environment) *Environment {
@@ -130,28 +217,146 @@ func ExtendEnvironment(outer *Environment) *Environment {
 
 func NewEnvironment() *Environment {
 	return &Environment{
-		store: make(map[string]Object),
+		index: make(map[string]int),
 		outer: nil,
 	}
 }
 
+// NewFrame returns an environment with one empty slot for each of names,
+// for the scope the resolver laid out as names.
+func NewFrame(outer *Environment, names []string) *Environment {
+	return &Environment{
+		names:  names,
+		values: make([]Object, len(names)),
+		outer:  outer,
+	}
+}
+
+// Define returns the slot for name in this environment, adding an empty
+// one if there is none.
+func (e *Environment) Define(name string) int {
+	if slot, ok := e.slot(name); ok {
+		return slot
+	}
+
+	if e.index == nil {
+		// The names of a frame are shared with its scope; copy them
+		// before growing.
+		e.names = append([]string(nil), e.names...)
+		e.index = make(map[string]int, len(e.names)+1)
+		for i, n := range e.names {
+			e.index[n] = i
+		}
+	}
+
+	slot := len(e.names)
+	e.names = append(e.names, name)
+	e.values = append(e.values, nil)
+	e.index[name] = slot
+
+	return slot
+}
+
+func (e *Environment) slot(name string) (int, bool) {
+	if e.index != nil {
+		slot, ok := e.index[name]
+		return slot, ok
+	}
+
+	for i := len(e.names) - 1; i >= 0; i-- {
+		if e.names[i] == name {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// Lookup returns the value in slot of the environment depth levels out,
+// or nil if that slot has not been set.
+func (e *Environment) Lookup(depth, slot int) Object {
+	env := e
+	for ; depth > 0; depth-- {
+		env = env.outer
+	}
+	return env.values[slot]
+}
+
+// Store sets slot of the environment depth levels out.
+func (e *Environment) Store(depth, slot int, val Object) {
+	env := e
+	for ; depth > 0; depth-- {
+		env = env.outer
+	}
+	env.values[slot] = val
+}
+
+// Name returns the name of slot in the environment depth levels out.
+func (e *Environment) Name(depth, slot int) string {
+	env := e
+	for ; depth > 0; depth-- {
+		env = env.outer
+	}
+	return env.names[slot]
+}
+
+// Outer returns the environment e extends.
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
+// Get looks name up by name, from this environment outwards.
 func (e *Environment) Get(name string) (Object, bool) {
-	obj, ok := e.store[name]
+	for env := e; env != nil; env = env.outer {
+		if slot, ok := env.slot(name); ok && env.values[slot] != nil {
+			return env.values[slot], true
+		}
+	}
+	return nil, false
+}
 
-	if !ok && e.outer != nil {
-		obj, ok = e.outer.store[name]
+// Assign rebinds name in the innermost environment that defines it. It
+// reports false if name is not defined anywhere.
+func (e *Environment) Assign(name string, val Object) bool {
+	for env := e; env != nil; env = env.outer {
+		if slot, ok := env.slot(name); ok && env.values[slot] != nil {
+			env.values[slot] = val
+			return true
+		}
 	}
+	return false
+}
+
+// Names returns the names bound in this environment and the ones it
+// extends.
+func (e *Environment) Names() []string {
+	var names []string
+	for env := e; env != nil; env = env.outer {
+		for slot, name := range env.names {
+			if env.values[slot] != nil {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
 
-	return obj, ok
+// Slots returns the names of the slots of this environment in slot order,
+// whether they are set or not.
+func (e *Environment) Slots() []string {
+	return append([]string(nil), e.names...)
 }
+
+// Set binds name in this environment.
 func (e *Environment) Set(name string, val Object) Object {
-	e.store[name] = val
+	e.values[e.Define(name)] = val
 	return val
 }
 
 type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
+	Locals     ast.Frame
 	Env        *Environment
 }
 
@@ -178,6 +383,116 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// Quote is an unevaluated piece of program, made by quote(...).
+type Quote struct {
+	Node ast.Node
+}
+
+func (q *Quote) Type() ObjectType {
+	return QUOTE_OBJ
+}
+
+func (q *Quote) Inspect() string {
+	return "QUOTE(" + q.Node.String() + ")"
+}
+
+// Macro is a macro literal. It is called with its arguments quoted and
+// returns the quoted code to put in place of the call.
+type Macro struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Locals     ast.Frame
+	Env        *Environment
+}
+
+func (m *Macro) Type() ObjectType {
+	return MACRO_OBJ
+}
+
+func (m *Macro) Inspect() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, param := range m.Parameters {
+		params = append(params, param.String())
+	}
+
+	out.WriteString("macro")
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") ")
+	out.WriteString("{\n")
+	out.WriteString(m.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
+// CompiledFunction is a function literal compiled to bytecode. Its code
+// starts by storing its arguments in the slots of its parameters, and
+// refers to its own constants and loop scopes by index.
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	Positions     code.SourceMap
+	Constants     []Object
+	Scopes        []ast.Frame
+	Locals        ast.Frame
+	NumParameters int
+}
+
+func (cf *CompiledFunction) Type() ObjectType {
+	return COMPILED_FUNCTION_OBJ
+}
+
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure is a compiled function together with the environment it was
+// created in, through which it reaches the variables of enclosing scopes.
+type Closure struct {
+	Fn  *CompiledFunction
+	Env *Environment
+}
+
+func (c *Closure) Type() ObjectType {
+	return CLOSURE_OBJ
+}
+
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Range is the sequence Start, Start+Step, ... up to but not including
+// Stop. Step is never zero.
+type Range struct {
+	Start, Stop, Step int64
+}
+
+func (r *Range) Type() ObjectType {
+	return RANGE_OBJ
+}
+
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("range(%d, %d)", r.Start, r.Stop)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.Stop, r.Step)
+}
+
+// Len returns the number of integers in the range. The widest ranges have
+// more than fit in an int64, so it is unsigned, and so is the arithmetic,
+// whose differences would overflow an int64.
+func (r *Range) Len() uint64 {
+	if r.Step > 0 && r.Start < r.Stop {
+		return (uint64(r.Stop)-uint64(r.Start)-1)/uint64(r.Step) + 1
+	}
+	if r.Step < 0 && r.Start > r.Stop {
+		return (uint64(r.Start)-uint64(r.Stop)-1)/-uint64(r.Step) + 1
+	}
+	return 0
+}
+
 type BuiltinFunction func(args ...Object) Object
 
 type Builtin struct {
@@ -211,6 +526,26 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+func (b *BigInt) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(b.Value.String()))
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
+// HashKey gives a float that is a whole number the key of the integer it
+// equals, so that 1.0 finds what was stored under 1, and -0.0 that of 0.
+func (f *Float) HashKey() HashKey {
+	v := f.Value
+	if v != math.Trunc(v) || math.IsInf(v, 0) {
+		return HashKey{Type: f.Type(), Value: math.Float64bits(v)}
+	}
+	if v >= math.MinInt64 && v < math.MaxInt64 {
+		return (&Integer{Value: int64(v)}).HashKey()
+	}
+	n, _ := big.NewFloat(v).Int(nil)
+	return (&BigInt{Value: n}).HashKey()
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -222,8 +557,51 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash maps keys to values. It remembers the order keys were first set in,
+// which Pairs, Inspect and for loops follow.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	pairs map[HashKey]HashPair
+	keys  []HashKey
+}
+
+// NewHash returns an empty hash with room for size pairs.
+func NewHash(size int) *Hash {
+	return &Hash{
+		pairs: make(map[HashKey]HashPair, size),
+		keys:  make([]HashKey, 0, size),
+	}
+}
+
+// Get returns the pair whose key has the hash key key.
+func (h *Hash) Get(key HashKey) (HashPair, bool) {
+	pair, ok := h.pairs[key]
+	return pair, ok
+}
+
+// Set stores pair under key, the hash key of pair.Key. A key that is
+// already in the hash keeps its place.
+func (h *Hash) Set(key HashKey, pair HashPair) {
+	if h.pairs == nil {
+		h.pairs = make(map[HashKey]HashPair)
+	}
+	if _, ok := h.pairs[key]; !ok {
+		h.keys = append(h.keys, key)
+	}
+	h.pairs[key] = pair
+}
+
+// Len returns the number of pairs in the hash.
+func (h *Hash) Len() int {
+	return len(h.keys)
+}
+
+// Pairs returns the pairs of the hash in insertion order.
+func (h *Hash) Pairs() []HashPair {
+	pairs := make([]HashPair, len(h.keys))
+	for i, key := range h.keys {
+		pairs[i] = h.pairs[key]
+	}
+	return pairs
 }
 
 func (h *Hash) Type() ObjectType {
@@ -234,7 +612,7 @@ func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 	pairs := []string{}
 
-	for _, pair := range h.Pairs {
+	for _, pair := range h.Pairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			pair.Key.Inspect(), pair.Value.Inspect()))
 	}
@@ -1,6 +1,12 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -58,3 +64,197 @@ func TestIntegerHashKey(t *testing.T) {
 		t.Errorf("integers with twoerent content have same hash keys")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	half1 := &Float{Value: 0.5}
+	half2 := &Float{Value: 0.5}
+	other := &Float{Value: 1.5}
+
+	if half1.HashKey() != half2.HashKey() {
+		t.Errorf("floats with same content have different hash keys")
+	}
+
+	if half1.HashKey() == other.HashKey() {
+		t.Errorf("floats with different content have same hash keys")
+	}
+
+	e20, _ := new(big.Int).SetString("100000000000000000000", 10)
+	tests := []struct {
+		float float64
+		same  Hashable
+	}{
+		{1, &Integer{Value: 1}},
+		{math.Copysign(0, -1), &Integer{Value: 0}},
+		{math.Copysign(0, -1), &Float{Value: 0}},
+		{-9223372036854775808, &Integer{Value: math.MinInt64}},
+		{1e20, &BigInt{Value: e20}},
+	}
+
+	for _, tt := range tests {
+		if (&Float{Value: tt.float}).HashKey() != tt.same.HashKey() {
+			t.Errorf("%g and %s have different hash keys", tt.float, tt.same.(Object).Inspect())
+		}
+	}
+}
+
+func TestRangeLen(t *testing.T) {
+	tests := []struct {
+		start, stop, step int64
+		expected          uint64
+	}{
+		{0, 10, 3, 4},
+		{10, 0, -3, 4},
+		{3, 3, 1, 0},
+		{3, 0, 1, 0},
+		{-math.MaxInt64, math.MaxInt64, 1, 1<<64 - 2},
+		{math.MinInt64, math.MaxInt64, 1, 1<<64 - 1},
+		{math.MaxInt64, math.MinInt64, -1, 1<<64 - 1},
+		{math.MaxInt64, math.MinInt64, math.MinInt64, 2},
+		{math.MinInt64, math.MaxInt64, math.MaxInt64, 3},
+	}
+
+	for _, tt := range tests {
+		r := &Range{Start: tt.start, Stop: tt.stop, Step: tt.step}
+		if got := r.Len(); got != tt.expected {
+			t.Errorf("%s has wrong length. expected=%d, got=%d", r.Inspect(), tt.expected, got)
+		}
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{3.14, "3.14"},
+		{2, "2.0"},
+		{-0.5, "-0.5"},
+		{1e-9, "1e-09"},
+		{1e21, "1e+21"},
+	}
+
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).Inspect(); got != tt.expected {
+			t.Errorf("Inspect(%g) wrong. expected=%q, got=%q", tt.value, tt.expected, got)
+		}
+	}
+}
+
+func TestBigIntHashKey(t *testing.T) {
+	big1, _ := new(big.Int).SetString("18446744073709551616", 10)
+	big2 := new(big.Int).Lsh(big.NewInt(1), 64)
+	other := new(big.Int).Add(big2, big.NewInt(1))
+
+	if (&BigInt{Value: big1}).HashKey() != (&BigInt{Value: big2}).HashKey() {
+		t.Errorf("big integers with same value have different hash keys")
+	}
+
+	if (&BigInt{Value: big1}).HashKey() == (&BigInt{Value: other}).HashKey() {
+		t.Errorf("big integers with different values have same hash keys")
+	}
+}
+
+func TestHashKeepsInsertionOrder(t *testing.T) {
+	hash := NewHash(0)
+	for _, s := range []string{"c", "a", "b", "a"} {
+		key := &String{Value: s}
+		hash.Set(key.HashKey(), HashPair{Key: key, Value: &Integer{Value: int64(hash.Len())}})
+	}
+
+	if got := hash.Inspect(); got != "{c: 0, a: 3, b: 2}" {
+		t.Errorf("wrong Inspect. got=%s", got)
+	}
+
+	if pair, ok := hash.Get((&String{Value: "a"}).HashKey()); !ok || pair.Value.(*Integer).Value != 3 {
+		t.Errorf("Get wrong. got=%v, %t", pair, ok)
+	}
+	if _, ok := hash.Get((&String{Value: "d"}).HashKey()); ok {
+		t.Errorf("Get found a key that was never set")
+	}
+}
+
+func TestNewInteger(t *testing.T) {
+	if _, ok := NewInteger(big.NewInt(42)).(*Integer); !ok {
+		t.Errorf("NewInteger(42) is not an Integer")
+	}
+
+	huge := new(big.Int).Lsh(big.NewInt(1), 63)
+	if _, ok := NewInteger(huge).(*BigInt); !ok {
+		t.Errorf("NewInteger(1 << 63) is not a BigInt")
+	}
+}
+
+func TestEnvironmentAssign(t *testing.T) {
+	global := NewEnvironment()
+	global.Set("x", &Integer{Value: 1})
+
+	inner := ExtendEnvironment(ExtendEnvironment(global))
+
+	if !inner.Assign("x", &Integer{Value: 2}) {
+		t.Fatalf("Assign did not find x")
+	}
+
+	if val, ok := global.Get("x"); !ok || val.(*Integer).Value != 2 {
+		t.Errorf("x was not updated in the defining environment. got=%v", val)
+	}
+
+	if val, ok := inner.Get("x"); !ok || val.(*Integer).Value != 2 {
+		t.Errorf("inner environment does not see the new x. got=%v", val)
+	}
+
+	if inner.Assign("y", &Integer{Value: 3}) {
+		t.Errorf("Assign bound an undefined name")
+	}
+
+	if _, ok := global.Get("y"); ok {
+		t.Errorf("y leaked into the global environment")
+	}
+}
+
+func TestEnvironmentFrames(t *testing.T) {
+	global := NewEnvironment()
+	x := global.Define("x")
+	global.Set("y", &Integer{Value: 2})
+
+	if global.Define("x") != x {
+		t.Errorf("Define gave x a second slot")
+	}
+
+	frame := NewFrame(NewFrame(global, []string{"a"}), []string{"b", "c"})
+	frame.Store(2, x, &Integer{Value: 1})
+	frame.Store(0, 1, &Integer{Value: 3})
+
+	if val := global.Lookup(0, x); val == nil || val.(*Integer).Value != 1 {
+		t.Errorf("Store did not reach the global frame. got=%v", val)
+	}
+
+	if val, ok := frame.Get("c"); !ok || val.(*Integer).Value != 3 {
+		t.Errorf("Get by name wrong. got=%v", val)
+	}
+
+	if _, ok := frame.Get("a"); ok {
+		t.Errorf("Get found a, which has not been set")
+	}
+
+	if val := frame.Lookup(2, global.Define("y")); val == nil || val.(*Integer).Value != 2 {
+		t.Errorf("Lookup wrong. got=%v", val)
+	}
+
+	names := frame.Names()
+	sort.Strings(names)
+	if strings.Join(names, " ") != "c x y" {
+		t.Errorf("Names wrong. got=%v", names)
+	}
+}
+
+func TestDefineCopiesSharedFrameNames(t *testing.T) {
+	shared := []string{"a"}
+
+	frame := NewFrame(nil, shared[:1:1])
+	frame.Set("b", &Integer{Value: 1})
+
+	other := NewFrame(nil, shared[:1:1])
+	if len(other.Names()) != 0 || len(shared) != 1 {
+		t.Errorf("growing one frame changed another")
+	}
+}
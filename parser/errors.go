@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"fmt"
+	"monkey/token"
+	"sort"
+)
+
+type ErrorCode string
+
+const (
+	UnexpectedToken     ErrorCode = "E001" // expectPeek saw something else
+	NoPrefixParseFn     ErrorCode = "E002" // token cannot start an expression
+	InvalidIntLiteral   ErrorCode = "E003" // malformed integer literal
+	InvalidEscape       ErrorCode = "E004" // bad escape sequence in a string
+	InvalidFloatLiteral ErrorCode = "E005" // float literal out of range
+	LoopControlOutside  ErrorCode = "E006" // break or continue outside a loop
+	InvalidAssignTarget ErrorCode = "E007" // left of = is not a variable or index
+)
+
+// ParseError is a syntax error anchored at the token the parser was looking
+// at when it gave up.
+type ParseError struct {
+	Code     ErrorCode
+	Message  string
+	Expected []token.TokenType
+	Actual   token.Token
+	Span     token.Span
+}
+
+func (e *ParseError) Pos() token.Position {
+	return e.Span.Start
+}
+
+func (e *ParseError) Error() string {
+	return e.Span.Start.String() + ": " + e.Message
+}
+
+// ErrorList is a list of parse errors in the order they were found. It
+// implements error and sort.Interface, ordering by file and position.
+type ErrorList []*ParseError
+
+func (l ErrorList) Len() int { return len(l) }
+
+func (l ErrorList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+
+func (l ErrorList) Less(i, j int) bool {
+	a, b := l[i].Span.Start, l[j].Span.Start
+	if a.Filename != b.Filename {
+		return a.Filename < b.Filename
+	}
+	if a.Offset != b.Offset {
+		return a.Offset < b.Offset
+	}
+	return l[i].Message < l[j].Message
+}
+
+func (l ErrorList) Sort() {
+	sort.Stable(l)
+}
+
+// RemoveDuplicates sorts the list and drops errors that repeat the code,
+// message and position of the error before them.
+func (l *ErrorList) RemoveDuplicates() {
+	l.Sort()
+
+	var last *ParseError
+	i := 0
+	for _, err := range *l {
+		if last == nil || err.Code != last.Code || err.Message != last.Message ||
+			err.Span.Start != last.Span.Start {
+			last = err
+			(*l)[i] = err
+			i++
+		}
+	}
+
+	*l = (*l)[:i]
+}
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Err returns an error equivalent to this list, or nil if it is empty.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
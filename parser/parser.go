@@ -1,36 +1,62 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"monkey/ast"
 	"monkey/lexer"
 	"monkey/token"
 	"strconv"
+	"strings"
 )
 
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // = or +=
+	LOGICALOR   // ||
+	LOGICALAND  // &&
 	EQUALS      // ==
-	LESSGREATER // > or <
+	LESSGREATER // >, <, >= or <=
+	BITOR       // |
+	BITXOR      // ^
+	BITAND      // &
+	SHIFT       // << or >>
 	SUM         // +
-	PRODUCT     // *
+	PRODUCT     // *, / or %
 	PREFIX      // -X or !X
 	CALL        // myFunction(X)
 	INDEX       // myArray[X]
 )
 
 var predecences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.ASTERISK: PRODUCT,
-	token.SLASH:    PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.PERCENT_ASSIGN:  ASSIGN,
+	token.OR:              LOGICALOR,
+	token.AND:             LOGICALAND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.BIT_OR:          BITOR,
+	token.BIT_XOR:         BITXOR,
+	token.BIT_AND:         BITAND,
+	token.SHL:             SHIFT,
+	token.SHR:             SHIFT,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.ASTERISK:        PRODUCT,
+	token.SLASH:           PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 type (
@@ -40,30 +66,54 @@ type (
 
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors ErrorList
 
 	curToken  token.Token
 	peekToken token.Token
 
+	// braces is the nesting depth of { } up to and including curToken and
+	// blocks holds that depth for each block statement being parsed.
+	braces int
+	blocks []int
+
+	// panicking is set from the first error in a statement until the parser
+	// has resynchronized; errors reported in between are cascades and get
+	// dropped. hold keeps the statement loop from stepping past a `}` that
+	// recovery stopped on because it closes the enclosing block.
+	panicking bool
+	hold      bool
+
+	// loops is the number of loops around curToken within the innermost
+	// function, for rejecting a stray break or continue.
+	loops int
+
+	// comments collects the comments between tokens when the lexer scans
+	// them.
+	comments []*ast.Comment
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, errors: ErrorList{}}
 	p.nextToken()
 	p.nextToken()
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.BIT_NOT, p.parsePrefixExpression)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunction)
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.RAW_STRING, p.parseRawStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
@@ -75,19 +125,44 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PERCENT_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfix(token.SHL, p.parseInfixExpression)
+	p.registerInfix(token.SHR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseArrayExpression)
 	return p
 }
 
-func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+func (p *Parser) addError(code ErrorCode, tok token.Token, expected []token.TokenType, format string, a ...any) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+
+	p.errors = append(p.errors, &ParseError{
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+		Expected: expected,
+		Actual:   tok,
+		Span:     tok.Span(),
+	})
 }
 
-func (p *Parser) noInfixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no infix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+func (p *Parser) noPrefixParseFnError(t token.TokenType) {
+	p.addError(NoPrefixParseFn, p.curToken, nil, "no prefix parse function for %s found", t)
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
@@ -131,10 +206,14 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
-	value, err := strconv.ParseInt(p.curToken.Literal, 10, 64)
+	value, err := parseInt(p.curToken.Literal)
+	if errors.Is(err, strconv.ErrRange) {
+		if value, ok := parseBigInt(p.curToken.Literal); ok {
+			return &ast.BigIntegerLiteral{Token: p.curToken, Value: value}
+		}
+	}
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(InvalidIntLiteral, p.curToken, nil, "could not parse %q as integer", p.curToken.Literal)
 		return nil
 	}
 
@@ -144,10 +223,43 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	}
 }
 
-func (p *Parser) parseStringLiteral() ast.Expression {
-	return &ast.StringLiteral{
+// parseInt parses a decimal, 0x, 0o or 0b integer literal whose digits may
+// be separated by single underscores.
+func parseInt(literal string) (int64, error) {
+	if hasBasePrefix(literal) {
+		return strconv.ParseInt(literal, 0, 64)
+	}
+
+	if strings.Contains(literal, "__") || strings.HasSuffix(literal, "_") {
+		return 0, strconv.ErrSyntax
+	}
+
+	return strconv.ParseInt(strings.ReplaceAll(literal, "_", ""), 10, 64)
+}
+
+// parseBigInt parses an integer literal that is syntactically valid but too
+// large for parseInt.
+func parseBigInt(literal string) (*big.Int, bool) {
+	if hasBasePrefix(literal) {
+		return new(big.Int).SetString(literal, 0)
+	}
+	return new(big.Int).SetString(strings.ReplaceAll(literal, "_", ""), 10)
+}
+
+func hasBasePrefix(literal string) bool {
+	return len(literal) > 1 && literal[0] == '0' && strings.ContainsRune("xXoObB", rune(literal[1]))
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.addError(InvalidFloatLiteral, p.curToken, nil, "could not parse %q as float", p.curToken.Literal)
+		return nil
+	}
+
+	return &ast.FloatLiteral{
 		Token: p.curToken,
-		Value: p.curToken.Literal,
+		Value: value,
 	}
 }
 
@@ -170,34 +282,49 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 	p.infixParseFns[tokenType] = fn
 }
 
-func (p *Parser) Errors() []string {
+func (p *Parser) Errors() ErrorList {
 	return p.errors
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addError(UnexpectedToken, p.peekToken, []token.TokenType{t}, "expected next token to be %s, got %s instead", t, p.peekToken.Type)
 }
 
-func (p *Parser) peekPrecedence() int {
-	if precedence, ok := predecences[p.peekToken.Type]; ok {
+// Precedence returns the binding power of the infix operator op, or LOWEST
+// if op is not one.
+func Precedence(op token.TokenType) int {
+	if precedence, ok := predecences[op]; ok {
 		return precedence
 	}
 
 	return LOWEST
 }
 
-func (p *Parser) curPrecedence() int {
-	if precedence, ok := predecences[p.curToken.Type]; ok {
-		return precedence
-	}
+func (p *Parser) peekPrecedence() int {
+	return Precedence(p.peekToken.Type)
+}
 
-	return LOWEST
+func (p *Parser) curPrecedence() int {
+	return Precedence(p.curToken.Type)
 }
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	for p.peekToken.Type == token.COMMENT {
+		p.comments = append(p.comments, &ast.Comment{Token: p.peekToken})
+		p.peekToken = p.l.NextToken()
+	}
+
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.braces++
+	case token.RBRACE:
+		if p.braces > 0 {
+			p.braces--
+		}
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -212,17 +339,76 @@ func (p *Parser) ParseProgram() *ast.Program {
 		p.nextToken()
 	}
 
+	program.Comments = p.comments
+
 	return program
 }
 
+// ParseStatement parses the statement starting at the current token. If it
+// contains a syntax error, the parser skips ahead to the next point where a
+// statement can start and returns an *ast.BadStatement in its place.
 func (p *Parser) ParseStatement() ast.Statement {
+	start := p.curToken
+
+	var stmt ast.Statement
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStatement()
+		stmt = p.parseLetStatement()
 	case token.RETURN:
-		return p.parseReturnStatement()
+		stmt = p.parseReturnStatement()
+	case token.WHILE:
+		stmt = p.parseWhileStatement()
+	case token.FOR:
+		stmt = p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		stmt = p.parseLoopControlStatement()
 	default:
-		return p.parseExpressionStatement()
+		stmt = p.parseExpressionStatement()
+	}
+
+	if !p.panicking {
+		return stmt
+	}
+
+	p.synchronize()
+	p.panicking = false
+
+	return &ast.BadStatement{Token: start, To: p.curToken}
+}
+
+// synchronize skips tokens until the current one ends a statement: a `;` or
+// a `}` closing a nested block at the level of the enclosing block, or the
+// token before a statement keyword, `fn` or the enclosing block's closing
+// brace.
+func (p *Parser) synchronize() {
+	level := 0
+	if len(p.blocks) > 0 {
+		level = p.blocks[len(p.blocks)-1]
+	}
+
+	if p.braces < level {
+		p.hold = true
+		return
+	}
+
+	for !p.curTokenIs(token.EOF) {
+		if p.braces == level {
+			if p.curTokenIs(token.SEMICOLON) {
+				return
+			}
+
+			if p.curTokenIs(token.RBRACE) && !p.peekTokenIs(token.ELSE) && !p.peekTokenIs(token.SEMICOLON) {
+				return
+			}
+
+			switch p.peekToken.Type {
+			case token.LET, token.RETURN, token.WHILE, token.FOR, token.BREAK, token.CONTINUE,
+				token.FUNCTION, token.MACRO, token.RBRACE, token.EOF:
+				return
+			}
+		}
+
+		p.nextToken()
 	}
 }
 
@@ -282,12 +468,103 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
-	stmt := &ast.ExpressionStatement{
-		Token:      p.curToken,
-		Expression: p.parseExpression(LOWEST),
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
 	}
 
+	p.nextToken()
+
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForStatement() *ast.ForStatement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loops++
+	defer func() { p.loops-- }()
+
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	var stmt ast.Statement
+	if p.curTokenIs(token.BREAK) {
+		stmt = &ast.BreakStatement{Token: p.curToken}
+	} else {
+		stmt = &ast.ContinueStatement{Token: p.curToken}
+	}
+
+	if p.loops == 0 {
+		p.addError(LoopControlOutside, p.curToken, nil, "%s outside of a loop", p.curToken.Literal)
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+
+	stmt.Expression = p.parseExpression(LOWEST)
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -317,6 +594,31 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	return leftExp
 }
 
+// parseAssignExpression parses the value of an assignment. Assignment is
+// right-associative, so a = b = 1 assigns 1 to b and then to a.
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Target:   target,
+	}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	case nil:
+		return nil
+	default:
+		p.addError(InvalidAssignTarget, p.curToken, nil, "cannot assign to %s", target.String())
+		return nil
+	}
+
+	p.nextToken()
+
+	expression.Value = p.parseExpression(ASSIGN - 1)
+
+	return expression
+}
+
 func (p *Parser) parseIfExpression() ast.Expression {
 	expresion := &ast.IfExpression{Token: p.curToken}
 
@@ -356,6 +658,10 @@ func (p *Parser) parseIfExpression() ast.Expression {
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
+
+	p.blocks = append(p.blocks, p.braces)
+	defer func() { p.blocks = p.blocks[:len(p.blocks)-1] }()
+
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
@@ -364,9 +670,20 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 			block.Statements = append(block.Statements, stmt)
 		}
 
+		if p.hold {
+			p.hold = false
+			continue
+		}
 		p.nextToken()
 	}
 
+	if p.curTokenIs(token.RBRACE) {
+		block.Rbrace = p.curToken.Pos
+	} else {
+		p.addError(UnexpectedToken, p.curToken, []token.TokenType{token.RBRACE},
+			"expected %s to close block, got %s instead", token.RBRACE, p.curToken.Type)
+	}
+
 	return block
 }
 
@@ -383,11 +700,35 @@ func (p *Parser) parseFunction() ast.Expression {
 		return nil
 	}
 
+	loops := p.loops
+	p.loops = 0
 	function.Body = p.parseBlockStatement()
+	p.loops = loops
 
 	return function
 }
 
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	macro := &ast.MacroLiteral{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	macro.Parameters = p.parseFunctionParameters()
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	loops := p.loops
+	p.loops = 0
+	macro.Body = p.parseBlockStatement()
+	p.loops = loops
+
+	return macro
+}
+
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	params := []*ast.Identifier{}
 
@@ -428,6 +769,7 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	callExp := &ast.CallExpression{Token: p.curToken, Function: function}
 
 	callExp.Arguments = p.parseExpessionList(token.RPAREN)
+	callExp.Rparen = p.curToken.Pos
 
 	return callExp
 }
@@ -458,12 +800,12 @@ func (p *Parser) parseExpessionList(endToken token.TokenType) []ast.Expression {
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
-	elems := p.parseExpessionList(token.RBRACKET)
+	array := &ast.ArrayLiteral{Token: p.curToken}
 
-	return &ast.ArrayLiteral{
-		Token:    p.curToken,
-		Elements: elems,
-	}
+	array.Elements = p.parseExpessionList(token.RBRACKET)
+	array.Rbracket = p.curToken.Pos
+
+	return array
 }
 
 func (p *Parser) parseArrayExpression(array ast.Expression) ast.Expression {
@@ -484,7 +826,6 @@ func (p *Parser) parseArrayExpression(array ast.Expression) ast.Expression {
 
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
-	hash.Pairs = make(map[ast.Expression]ast.Expression)
 
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
@@ -496,7 +837,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 
 		if !(p.peekTokenIs(token.RBRACE) || p.expectPeek(token.COMMA)) {
 			return nil
@@ -506,6 +847,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.Rbrace = p.curToken.Pos
 
 	return hash
 }
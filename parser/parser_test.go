@@ -1,9 +1,11 @@
 package parser
 
 import (
+	"bytes"
 	"fmt"
 	"monkey/ast"
 	"monkey/lexer"
+	"monkey/token"
 	"testing"
 )
 
@@ -151,6 +153,7 @@ func TestParsingPrefixExpressions(t *testing.T) {
 		{"-foobar;", "-", "foobar"},
 		{"!true;", "!", true},
 		{"!false;", "!", false},
+		{"~5;", "~", 5},
 	}
 
 	for _, tt := range prefixTests {
@@ -345,6 +348,46 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a & b | c ^ d",
+			"((a & b) | (c ^ d))",
+		},
+		{
+			"1 + 2 << 3 & 4",
+			"(((1 + 2) << 3) & 4)",
+		},
+		{
+			"x & mask == 0",
+			"((x & mask) == 0)",
+		},
+		{
+			"a >> 1 < b << 1",
+			"((a >> 1) < (b << 1))",
+		},
+		{
+			"~a & b",
+			"((~a) & b)",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"a < b && c >= d == e",
+			"((a < b) && ((c >= d) == e))",
+		},
+		{
+			"a <= b + c % d",
+			"(a <= (b + (c % d)))",
+		},
+		{
+			"!a || b & c",
+			"((!a) || (b & c))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -552,6 +595,53 @@ func TestFunctionLiteralParsing(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestMacroLiteralParsing(t *testing.T) {
+	input := `macro(x, y) { x + y; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MacroLiteral. got=%T",
+			stmt.Expression)
+	}
+
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("macro literal parameters wrong. want 2, got=%d\n",
+			len(macro.Parameters))
+	}
+
+	testLiteralExpression(t, macro.Parameters[0], "x")
+	testLiteralExpression(t, macro.Parameters[1], "y")
+
+	if len(macro.Body.Statements) != 1 {
+		t.Fatalf("macro.Body.Statements has not 1 statements. got=%d\n",
+			len(macro.Body.Statements))
+	}
+
+	bodyStmt, ok := macro.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("macro body stmt is not ast.ExpressionStatement. got=%T",
+			macro.Body.Statements[0])
+	}
+
+	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
+}
+
 func TestFunctionParameterParsing(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -903,7 +993,8 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
@@ -915,6 +1006,22 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	}
 }
 
+func TestParsingHashLiteralKeepsSourceOrder(t *testing.T) {
+	input := `{"b": 1, "a": 2, "b": 3}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash := stmt.Expression.(*ast.HashLiteral)
+
+	if got := hash.String(); got != "{b : 1, a : 2, b : 3}" {
+		t.Errorf("pairs in wrong order. got=%q", got)
+	}
+}
+
 func TestParsingEmptyHashLiteral(t *testing.T) {
 	input := "{}"
 
@@ -957,7 +1064,8 @@ func TestParsingHashLiteralsBooleanKeys(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		boolean, ok := key.(*ast.Boolean)
 		if !ok {
 			t.Errorf("key is not ast.BooleanLiteral. got=%T", key)
@@ -993,7 +1101,8 @@ func TestParsingHashLiteralsIntegerKeys(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		integer, ok := key.(*ast.IntegerLiteral)
 		if !ok {
 			t.Errorf("key is not ast.IntegerLiteral. got=%T", key)
@@ -1036,7 +1145,8 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		},
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
@@ -1052,3 +1162,581 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let = 5;", "1:5: expected next token to be IDENT, got = instead"},
+		{"let x = 5;\nlet y 10;", "2:7: expected next token to be =, got INT instead"},
+		{"\n  );", "2:3: no prefix parse function for ) found"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q", tt.input)
+		}
+
+		if errors[0].Error() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestStructuredParseErrors(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedCode   ErrorCode
+		expectedTypes  []token.TokenType
+		expectedActual token.TokenType
+		expectedSpan   [2]int
+	}{
+		{"let x 5;", UnexpectedToken, []token.TokenType{token.ASSIGN}, token.INT, [2]int{6, 7}},
+		{"let = 5;", UnexpectedToken, []token.TokenType{token.IDENT}, token.ASSIGN, [2]int{4, 5}},
+		{"if (x) { 1 } else y", UnexpectedToken, []token.TokenType{token.LBRACE}, token.IDENT, [2]int{18, 19}},
+		{"5 + ;", NoPrefixParseFn, nil, token.SEMICOLON, [2]int{4, 5}},
+		{"0b1012", InvalidIntLiteral, nil, token.INT, [2]int{0, 6}},
+		{"break;", LoopControlOutside, nil, token.BREAK, [2]int{0, 5}},
+		{"while (x) { fn() { continue } }", LoopControlOutside, nil, token.CONTINUE, [2]int{19, 27}},
+		{"for (1 in xs) {}", UnexpectedToken, []token.TokenType{token.IDENT}, token.INT, [2]int{5, 6}},
+		{"for (x of xs) {}", UnexpectedToken, []token.TokenType{token.IN}, token.IDENT, [2]int{7, 9}},
+		{"1 + 2 = 3", InvalidAssignTarget, nil, token.ASSIGN, [2]int{6, 7}},
+		{"f() += 1", InvalidAssignTarget, nil, token.PLUS_ASSIGN, [2]int{4, 6}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q", tt.input)
+		}
+
+		err := errors[0]
+		if err.Code != tt.expectedCode {
+			t.Errorf("%q: wrong code. expected=%s, got=%s", tt.input, tt.expectedCode, err.Code)
+		}
+
+		if fmt.Sprint(err.Expected) != fmt.Sprint(tt.expectedTypes) {
+			t.Errorf("%q: wrong expected tokens. expected=%v, got=%v",
+				tt.input, tt.expectedTypes, err.Expected)
+		}
+
+		if err.Actual.Type != tt.expectedActual {
+			t.Errorf("%q: wrong actual token. expected=%s, got=%s",
+				tt.input, tt.expectedActual, err.Actual.Type)
+		}
+
+		if err.Span.Start.Offset != tt.expectedSpan[0] || err.Span.End.Offset != tt.expectedSpan[1] {
+			t.Errorf("%q: wrong span. expected=%v, got=[%d %d]", tt.input,
+				tt.expectedSpan, err.Span.Start.Offset, err.Span.End.Offset)
+		}
+	}
+}
+
+func TestErrorListSortAndRemoveDuplicates(t *testing.T) {
+	at := func(offset int, msg string) *ParseError {
+		pos := token.Position{Offset: offset, Line: 1, Column: offset + 1}
+		return &ParseError{Code: UnexpectedToken, Message: msg, Span: token.Span{Start: pos, End: pos}}
+	}
+
+	list := ErrorList{at(5, "b"), at(1, "a"), at(5, "b"), at(3, "c"), at(1, "a")}
+	list.RemoveDuplicates()
+
+	expected := []string{"1:2: a", "1:4: c", "1:6: b"}
+	if len(list) != len(expected) {
+		t.Fatalf("wrong number of errors. expected=%d, got=%d", len(expected), len(list))
+	}
+
+	for i, msg := range expected {
+		if list[i].Error() != msg {
+			t.Errorf("list[%d] wrong. expected=%q, got=%q", i, msg, list[i].Error())
+		}
+	}
+
+	if list.Error() != "1:2: a (and 2 more errors)" {
+		t.Errorf("wrong list error. got=%q", list.Error())
+	}
+
+	if (ErrorList{}).Err() != nil {
+		t.Errorf("empty list should not be an error")
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	input := `let x = ;
+let y 5;
+let z = 10;
+let f = fn(a) {
+  let b = a +;
+  let c = ;
+  b * 2
+};
+if (x { 1 } else { 2 };
+let g = fn() { let q = }
+z;`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+
+	expectedLines := []int{1, 2, 5, 6, 9, 10}
+	errors := p.Errors()
+	if len(errors) != len(expectedLines) {
+		t.Fatalf("wrong number of errors. expected=%d, got=%d: %v",
+			len(expectedLines), len(errors), errors)
+	}
+
+	for i, line := range expectedLines {
+		if errors[i].Pos().Line != line {
+			t.Errorf("errors[%d] on wrong line. expected=%d, got=%d (%s)",
+				i, line, errors[i].Pos().Line, errors[i])
+		}
+	}
+
+	expectedStatements := []string{
+		"*ast.BadStatement",
+		"*ast.BadStatement",
+		"*ast.LetStatement",
+		"*ast.LetStatement",
+		"*ast.BadStatement",
+		"*ast.LetStatement",
+		"*ast.ExpressionStatement",
+	}
+	if len(program.Statements) != len(expectedStatements) {
+		t.Fatalf("wrong number of statements. expected=%d, got=%d: %q",
+			len(expectedStatements), len(program.Statements), program.String())
+	}
+
+	for i, typ := range expectedStatements {
+		if got := fmt.Sprintf("%T", program.Statements[i]); got != typ {
+			t.Errorf("statements[%d] wrong type. expected=%s, got=%s", i, typ, got)
+		}
+	}
+
+	testLetStatement(t, program.Statements[2], "z")
+
+	body := program.Statements[3].(*ast.LetStatement).Value.(*ast.FunctionLiteral).Body
+	if len(body.Statements) != 3 {
+		t.Fatalf("function body has wrong number of statements. got=%d", len(body.Statements))
+	}
+	if _, ok := body.Statements[2].(*ast.ExpressionStatement); !ok {
+		t.Errorf("last body statement not recovered. got=%T", body.Statements[2])
+	}
+
+	inner := program.Statements[5].(*ast.LetStatement).Value.(*ast.FunctionLiteral).Body
+	if len(inner.Statements) != 1 {
+		t.Fatalf("inner body has wrong number of statements. got=%d", len(inner.Statements))
+	}
+	if _, ok := inner.Statements[0].(*ast.BadStatement); !ok {
+		t.Errorf("inner statement not a BadStatement. got=%T", inner.Statements[0])
+	}
+}
+
+func TestStatementPositions(t *testing.T) {
+	input := "let x = 1;\n  add(x, 2);\nreturn x;"
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := []string{"1:1", "2:3", "3:1"}
+	for i, pos := range expected {
+		if got := program.Statements[i].Pos().String(); got != pos {
+			t.Errorf("statements[%d] wrong position. expected=%s, got=%s", i, pos, got)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"tab\there"`, "tab\there"},
+		{`"line\nbreak"`, "line\nbreak"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"back\\slash"`, `back\slash`},
+		{`"\u{48}\u{1F600}"`, "H\U0001F600"},
+		{`"cost: \${x}"`, "cost: ${x}"},
+		{`"$5"`, "$5"},
+		{"`raw \\n ${x}`", `raw \n ${x}`},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.StringLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.StringLiteral. got=%T", stmt.Expression)
+		}
+
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value wrong. expected=%q, got=%q", tt.expected, literal.Value)
+		}
+	}
+}
+
+func TestStringInterpolationParsing(t *testing.T) {
+	input := `"Hello ${name}! ${1 + 2}"`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	interpolated, ok := stmt.Expression.(*ast.InterpolatedString)
+	if !ok {
+		t.Fatalf("exp not *ast.InterpolatedString. got=%T", stmt.Expression)
+	}
+
+	if len(interpolated.Parts) != 4 {
+		t.Fatalf("wrong number of parts. expected=4, got=%d", len(interpolated.Parts))
+	}
+
+	if part, ok := interpolated.Parts[0].(*ast.StringLiteral); !ok || part.Value != "Hello " {
+		t.Errorf("parts[0] wrong. got=%#v", interpolated.Parts[0])
+	}
+
+	testIdentifier(t, interpolated.Parts[1], "name")
+
+	if part, ok := interpolated.Parts[2].(*ast.StringLiteral); !ok || part.Value != "! " {
+		t.Errorf("parts[2] wrong. got=%#v", interpolated.Parts[2])
+	}
+
+	testInfixExpression(t, interpolated.Parts[3], 1, "+", 2)
+
+	if pos := interpolated.Parts[3].Pos(); pos.Column != 21 || pos.Offset != 20 {
+		t.Errorf("parts[3] wrong position. got=%s offset %d", pos, pos.Offset)
+	}
+}
+
+func TestStringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"bad \q"`, `1:6: unknown escape sequence \q`},
+		{`"\u{110000}"`, `1:2: invalid unicode escape \u{110000}`},
+		{`"\u0041"`, `1:2: expected \u{...} unicode escape`},
+		{`"x ${1 +} y"`, "1:9: no prefix parse function for } found"},
+		{`"x ${a b} y"`, "1:8: expected next token to be }, got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("%s: expected 1 error, got %v", tt.input, errors)
+		}
+
+		if errors[0].Error() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errors[0].Error())
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9;", 1e-9},
+		{"2.5E3;", 2500},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+		if literal.String() != tt.input[:len(tt.input)-1] {
+			t.Errorf("literal.String() wrong. got=%s", literal.String())
+		}
+	}
+
+	p := New(lexer.New("1 + 2.5 * 3"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if program.String() != "(1 + (2.5 * 3))" {
+		t.Errorf("wrong precedence. got=%q", program.String())
+	}
+}
+
+func TestIntegerLiteralBases(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0xff;", 255},
+		{"0XFF;", 255},
+		{"0o17;", 15},
+		{"0b1010;", 10},
+		{"1_000_000;", 1000000},
+		{"0x_7f;", 127},
+		{"007;", 7},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.IntegerLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %d. got=%d", tt.expected, literal.Value)
+		}
+	}
+
+	bigTests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775808;", "9223372036854775808"},
+		{"0xffff_ffff_ffff_ffff_ff;", "4722366482869645213695"},
+		{"1_000_000_000_000_000_000_000;", "1000000000000000000000"},
+	}
+
+	for _, tt := range bigTests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.BigIntegerLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.BigIntegerLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value.String() != tt.expected {
+			t.Errorf("literal.Value not %s. got=%s", tt.expected, literal.Value)
+		}
+	}
+
+	for _, input := range []string{"0b102", "0x", "1__0", "1_", "0o8", "99999999999999999999_"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0].Code != InvalidIntLiteral {
+			t.Errorf("%q: expected one %s error. got=%v", input, InvalidIntLiteral, errors)
+		}
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < 10) { x; break; }`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.WhileStatement. got=%T", program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "x", "<", 10) {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("body does not contain 2 statements. got=%d", len(stmt.Body.Statements))
+	}
+
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("body.Statements[1] is not *ast.BreakStatement. got=%T", stmt.Body.Statements[1])
+	}
+
+	if program.String() != "while ((x < 10)) xbreak;" {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (item in [1, 2]) { if (item == 1) { continue; } item }`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.ForStatement. got=%T", program.Statements[0])
+	}
+
+	if !testIdentifier(t, stmt.Variable, "item") {
+		return
+	}
+
+	if _, ok := stmt.Iterable.(*ast.ArrayLiteral); !ok {
+		t.Errorf("stmt.Iterable is not *ast.ArrayLiteral. got=%T", stmt.Iterable)
+	}
+
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("body does not contain 2 statements. got=%d", len(stmt.Body.Statements))
+	}
+
+	if program.String() != "for (item in [1, 2]) if(item == 1) continue;item" {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		target   string
+		operator string
+		value    string
+	}{
+		{"x = 5;", "x", "=", "5"},
+		{"x += y * 2;", "x", "+=", "(y * 2)"},
+		{"total -= 1", "total", "-=", "1"},
+		{"a[0] *= 3", "(a[0])", "*=", "3"},
+		{`h["k"] = fn(x) { x }`, "(h[k])", "=", "fn (x) x"},
+		{"a = b = 1", "a", "=", "b = 1"},
+		{"x %= 2 || y", "x", "%=", "(2 || y)"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.AssignExpression)
+		if !ok {
+			t.Fatalf("%q: exp not *ast.AssignExpression. got=%T", tt.input, stmt.Expression)
+		}
+
+		if exp.Target.String() != tt.target {
+			t.Errorf("%q: wrong target. expected=%q, got=%q", tt.input, tt.target, exp.Target.String())
+		}
+		if exp.Operator != tt.operator {
+			t.Errorf("%q: wrong operator. expected=%q, got=%q", tt.input, tt.operator, exp.Operator)
+		}
+		if exp.Value.String() != tt.value {
+			t.Errorf("%q: wrong value. expected=%q, got=%q", tt.input, tt.value, exp.Value.String())
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	inputs := []string{
+		`let x = 5; return x; x;`,
+		`let add = fn(a, b) { a + b }; add(1, -2 * 3);`,
+		`if (x < 1 && !y) { 1 } else { 2.5 }`,
+		`while (i <= 10) { i += 1; if (i == 5) { continue; } break; }`,
+		`for (x in [1, 2, 3]) { puts(x) }`,
+		`let h = {"a": [1, 2][0]}; h["a"] = {};`,
+		`let n = 0x7FFFFFFFFFFFFFFFFF; let s = "x is ${x + 1}!"; let r = ` + "`raw`;",
+		`let unless = macro(cond, body) { quote(if (!(unquote(cond))) { unquote(body) }) };`,
+		`true != false; ~1 ^ 2 | 3 & 4 << 5 >> 6 % 7;`,
+	}
+
+	for _, input := range inputs {
+		p := New(lexer.NewFile("test.mk", input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		var encoded bytes.Buffer
+		if err := ast.EncodeJSON(&encoded, program); err != nil {
+			t.Fatalf("EncodeJSON(%q) failed: %s", input, err)
+		}
+
+		decoded, err := ast.DecodeJSON(bytes.NewReader(encoded.Bytes()))
+		if err != nil {
+			t.Fatalf("DecodeJSON(%q) failed: %s", input, err)
+		}
+
+		if decoded.String() != program.String() {
+			t.Errorf("round trip of %q changed the program. want=%q, got=%q",
+				input, program.String(), decoded.String())
+		}
+
+		var reencoded bytes.Buffer
+		if err := ast.EncodeJSON(&reencoded, decoded); err != nil {
+			t.Fatalf("EncodeJSON(decoded %q) failed: %s", input, err)
+		}
+		if reencoded.String() != encoded.String() {
+			t.Errorf("round trip of %q changed the encoding.\nwant=%s\ngot =%s",
+				input, encoded.String(), reencoded.String())
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let x = 1; /* trailing */
+let f = fn() {
+  // inside
+  x
+};`
+
+	l := lexer.New(input)
+	l.SetMode(lexer.ScanComments)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("wrong number of statements. got=%d: %q", len(program.Statements), program.String())
+	}
+
+	expected := []struct {
+		text string
+		pos  string
+	}{
+		{"// leading", "1:1"},
+		{"/* trailing */", "2:12"},
+		{"// inside", "4:3"},
+	}
+	if len(program.Comments) != len(expected) {
+		t.Fatalf("wrong number of comments. want=%d, got=%d", len(expected), len(program.Comments))
+	}
+	for i, c := range expected {
+		comment := program.Comments[i]
+		if comment.Token.Literal != c.text || comment.Pos().String() != c.pos {
+			t.Errorf("comments[%d] wrong. want=%s at %s, got=%s at %s",
+				i, c.text, c.pos, comment.Token.Literal, comment.Pos())
+		}
+	}
+
+	body := program.Statements[1].(*ast.LetStatement).Value.(*ast.FunctionLiteral).Body
+	if body.Rbrace.String() != "6:1" {
+		t.Errorf("body.Rbrace wrong. want=6:1, got=%s", body.Rbrace)
+	}
+
+	p = New(lexer.New(input))
+	if program := p.ParseProgram(); program.Comments != nil {
+		t.Errorf("comments kept without ScanComments. got=%v", program.Comments)
+	}
+}
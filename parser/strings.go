@@ -0,0 +1,154 @@
+package parser
+
+import (
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/token"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func (p *Parser) parseRawStringLiteral() ast.Expression {
+	return &ast.StringLiteral{
+		Token: p.curToken,
+		Value: p.curToken.Literal,
+	}
+}
+
+// parseStringLiteral decodes the escapes in a double-quoted string and
+// parses the expressions in its `${...}` interpolations. Strings without
+// interpolations become an *ast.StringLiteral.
+func (p *Parser) parseStringLiteral() ast.Expression {
+	tok := p.curToken
+	raw := tok.Literal
+
+	// the text starts after the opening quote
+	start := tok.Pos
+	start.Offset++
+	start.Column++
+
+	interpolated := &ast.InterpolatedString{Token: tok}
+
+	var text strings.Builder
+	textStart := 0
+
+	flush := func(end int) {
+		if end > textStart {
+			part := token.Token{Type: token.STRING, Literal: raw[textStart:end], Pos: advance(start, raw[:textStart])}
+			interpolated.Parts = append(interpolated.Parts, &ast.StringLiteral{Token: part, Value: text.String()})
+		}
+		text.Reset()
+	}
+
+	for i := 0; i < len(raw); {
+		switch {
+		case raw[i] == '\\':
+			n := p.decodeEscape(&text, raw, i, advance(start, raw[:i]))
+			if n == 0 {
+				return nil
+			}
+			i += n
+		case raw[i] == '$' && i+1 < len(raw) && raw[i+1] == '{':
+			flush(i)
+
+			exprStart := i + 2
+			expr, end := p.parseInterpolation(raw[exprStart:], advance(start, raw[:exprStart]))
+			if expr == nil {
+				return nil
+			}
+			interpolated.Parts = append(interpolated.Parts, expr)
+
+			i = exprStart + end + 1
+			textStart = i
+		default:
+			text.WriteByte(raw[i])
+			i++
+		}
+	}
+
+	if len(interpolated.Parts) == 0 {
+		return &ast.StringLiteral{Token: tok, Value: text.String()}
+	}
+
+	flush(len(raw))
+
+	return interpolated
+}
+
+// parseInterpolation parses the expression at the start of src, which must
+// be followed by the `}` closing the interpolation. It returns the
+// expression and the index of that brace in src.
+func (p *Parser) parseInterpolation(src string, pos token.Position) (ast.Expression, int) {
+	sub := New(lexer.NewAt(src, pos))
+	expr := sub.parseExpression(LOWEST)
+	sub.expectPeek(token.RBRACE)
+
+	if len(sub.errors) > 0 {
+		if !p.panicking {
+			p.errors = append(p.errors, sub.errors...)
+			p.panicking = true
+		}
+		return nil, 0
+	}
+
+	return expr, sub.curToken.Pos.Offset - pos.Offset
+}
+
+// decodeEscape writes the character for the escape sequence at raw[i] to
+// out and returns the length of the sequence, or 0 if it is invalid.
+func (p *Parser) decodeEscape(out *strings.Builder, raw string, i int, pos token.Position) int {
+	invalid := func(length int, format string, a ...any) int {
+		tok := token.Token{Type: token.ILLEGAL, Literal: raw[i : i+length], Pos: pos}
+		p.addError(InvalidEscape, tok, nil, format, a...)
+		return 0
+	}
+
+	if i+1 >= len(raw) {
+		return invalid(1, "unterminated escape sequence")
+	}
+
+	switch c := raw[i+1]; c {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '0':
+		out.WriteByte(0)
+	case '"', '\\', '$':
+		out.WriteByte(c)
+	case 'u':
+		end := strings.IndexByte(raw[i:], '}')
+		if i+2 >= len(raw) || raw[i+2] != '{' || end < 0 {
+			return invalid(2, "expected \\u{...} unicode escape")
+		}
+
+		digits := raw[i+3 : i+end]
+		code, err := strconv.ParseUint(digits, 16, 32)
+		if err != nil || len(digits) == 0 || len(digits) > 6 || !utf8.ValidRune(rune(code)) {
+			return invalid(end+1, "invalid unicode escape \\u{%s}", digits)
+		}
+
+		out.WriteRune(rune(code))
+		return end + 1
+	default:
+		return invalid(2, "unknown escape sequence \\%c", c)
+	}
+
+	return 2
+}
+
+// advance returns the position reached after text starting at pos.
+func advance(pos token.Position, text string) token.Position {
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			pos.Line++
+			pos.Column = 0
+		}
+		pos.Column++
+	}
+	pos.Offset += len(text)
+	return pos
+}
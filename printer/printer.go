@@ -0,0 +1,645 @@
+// Package printer writes syntax trees as Monkey source in a canonical
+// layout: two-space indentation, one statement per line, opening braces on
+// the line of the construct they belong to, spaces around binary operators
+// and after commas, and only the parentheses operator precedence requires,
+// besides those between two prefix operators.
+package printer
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"monkey/ast"
+	"monkey/parser"
+	"monkey/token"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	indentation = "  "
+
+	// maxWidth is the column past which the elements of a list are put on
+	// lines of their own.
+	maxWidth = 80
+)
+
+// primary is the precedence of operands, which never need parentheses.
+const primary = parser.INDEX + 1
+
+// Fprint writes node to w as formatted source. A *ast.Program is printed
+// with the comments it holds and ends in a newline.
+//
+// Besides the tree, the layout follows the source positions of its nodes
+// in three ways: a blank line between statements is kept, a block written
+// on one line stays on one line if it holds a single simple statement, and
+// a list whose first element starts a new line has each element on a line
+// of its own.
+func Fprint(w io.Writer, node ast.Node) error {
+	p := &printer{first: true}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		p.comments = node.Comments
+		p.statements(node.Statements, math.MaxInt, false)
+		if p.out.Len() > 0 {
+			p.write("\n")
+		}
+	case *ast.BlockStatement:
+		p.block(node, false)
+	case ast.Statement:
+		p.statement(node)
+	case ast.Expression:
+		p.expr(node, parser.LOWEST)
+	}
+
+	_, err := w.Write(p.out.Bytes())
+	return err
+}
+
+type printer struct {
+	out   bytes.Buffer
+	depth int
+	base  int  // the column out starts at
+	flat  bool // set to keep lists on one line
+
+	// comments holds the comments yet to be printed.
+	comments []*ast.Comment
+
+	// line is the last source line printed. first is set at the start of
+	// a block or list, where no blank line goes.
+	line  int
+	first bool
+}
+
+func (p *printer) write(s string) {
+	p.out.WriteString(s)
+}
+
+// newline starts a line for something from source line line, after a blank
+// line if the source has one.
+func (p *printer) newline(line int) {
+	if p.out.Len() > 0 {
+		if !p.first && p.line > 0 && line > p.line+1 {
+			p.write("\n")
+		}
+		p.write("\n")
+	}
+	p.first = false
+	p.write(strings.Repeat(indentation, p.depth))
+}
+
+func (p *printer) column() int {
+	b := p.out.Bytes()
+	if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+		return utf8.RuneCount(b[i+1:])
+	}
+	return p.base + utf8.RuneCount(b)
+}
+
+// render returns what f prints at the current position, leaving comments
+// out.
+func (p *printer) render(f func(q *printer), flat bool) string {
+	q := &printer{depth: p.depth, base: p.column(), flat: flat}
+	f(q)
+	return q.out.String()
+}
+
+// commentsBefore prints the comments before offset on lines of their own.
+func (p *printer) commentsBefore(offset int) {
+	for len(p.comments) > 0 && p.comments[0].Pos().Offset < offset {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+
+		p.newline(c.Pos().Line)
+		p.write(c.Token.Literal)
+		p.line = c.Pos().Line + strings.Count(c.Token.Literal, "\n")
+	}
+}
+
+// trailingComments prints the comments before offset that are on the
+// source line printed last at the end of the output line.
+func (p *printer) trailingComments(offset int) {
+	for len(p.comments) > 0 && p.comments[0].Pos().Line == p.line && p.comments[0].Pos().Offset < offset {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+
+		p.write(" " + c.Token.Literal)
+		p.line += strings.Count(c.Token.Literal, "\n")
+	}
+}
+
+// inlineComments prints the comments before offset, which come between the
+// tokens of an expression, in front of the node at offset. A line comment
+// ends the line, and the expression continues on the next one.
+func (p *printer) inlineComments(offset int) {
+	for len(p.comments) > 0 && p.comments[0].Pos().Offset < offset {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+
+		p.write(c.Token.Literal)
+		p.line = c.Pos().Line + strings.Count(c.Token.Literal, "\n")
+		if strings.HasPrefix(c.Token.Literal, "//") {
+			p.write("\n" + strings.Repeat(indentation, p.depth+1))
+		} else {
+			p.write(" ")
+		}
+	}
+}
+
+// hasComments reports whether a comment is left between the offsets from
+// and to.
+func (p *printer) hasComments(from, to int) bool {
+	for _, c := range p.comments {
+		if offset := c.Pos().Offset; offset > from {
+			return offset < to
+		}
+	}
+	return false
+}
+
+// hasListComments reports whether a comment is left between the offsets
+// from and to, the brackets of a list of elements, that keeps the list from
+// going on one line. Comments in the blocks of the elements go on lines of
+// their own, and /* */ comments before the end of the last element are
+// printed inline, so neither counts.
+func (p *printer) hasListComments(from, to int, elements []element) bool {
+	var blocks []*ast.BlockStatement
+	last := from
+	for _, el := range elements {
+		for _, node := range el.nodes {
+			last = max(last, endOffset(node))
+			ast.Inspect(node, func(n ast.Node) bool {
+				if b, ok := n.(*ast.BlockStatement); ok {
+					blocks = append(blocks, b)
+					return false
+				}
+				return n != nil
+			})
+		}
+	}
+
+comments:
+	for _, c := range p.comments {
+		offset := c.Pos().Offset
+		if offset <= from {
+			continue
+		}
+		if offset >= to {
+			return false
+		}
+		for _, b := range blocks {
+			if offset > b.Token.Pos.Offset && offset < b.Rbrace.Offset {
+				continue comments
+			}
+		}
+		if offset < last && strings.HasPrefix(c.Token.Literal, "/*") {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// statements prints list, one statement per line, and the comments up to
+// end. inBlock is set for the statements of a block, whose last one gives
+// its value.
+func (p *printer) statements(list []ast.Statement, end int, inBlock bool) {
+	for i, stmt := range list {
+		pos := start(stmt)
+		p.commentsBefore(pos.Offset)
+		p.newline(pos.Line)
+
+		p.statement(stmt)
+		if p.needsSemicolon(list, i, inBlock) {
+			p.write(";")
+		}
+
+		p.line = endLine(stmt)
+		next := end
+		if i+1 < len(list) {
+			next = start(list[i+1]).Offset
+		}
+		p.trailingComments(next)
+	}
+
+	p.commentsBefore(end)
+}
+
+// needsSemicolon reports whether list[i] is printed with a semicolon. All
+// statements have one except loops, the last expression of a block and an
+// if expression not followed by something that would continue it, like
+// ( calling it.
+func (p *printer) needsSemicolon(list []ast.Statement, i int, inBlock bool) bool {
+	switch stmt := list[i].(type) {
+	case *ast.WhileStatement, *ast.ForStatement:
+		return false
+	case *ast.ExpressionStatement:
+		if inBlock && i == len(list)-1 {
+			return false
+		}
+		if _, ok := stmt.Expression.(*ast.IfExpression); ok {
+			if i == len(list)-1 {
+				return false
+			}
+			next := p.render(func(q *printer) { q.statement(list[i+1]) }, true)
+			return strings.HasPrefix(next, "(") || strings.HasPrefix(next, "[") || strings.HasPrefix(next, "-")
+		}
+	}
+	return true
+}
+
+func (p *printer) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		p.write("let " + stmt.Name.Value + " = ")
+		p.expr(stmt.Value, parser.LOWEST)
+	case *ast.ReturnStatement:
+		p.write("return ")
+		p.expr(stmt.ReturnValue, parser.LOWEST)
+	case *ast.ExpressionStatement:
+		p.expr(stmt.Expression, parser.LOWEST)
+	case *ast.WhileStatement:
+		p.write("while (")
+		p.expr(stmt.Condition, parser.LOWEST)
+		p.write(") ")
+		p.block(stmt.Body, p.fitsOnOneLine(stmt.Body))
+	case *ast.ForStatement:
+		p.write("for (" + stmt.Variable.Value + " in ")
+		p.expr(stmt.Iterable, parser.LOWEST)
+		p.write(") ")
+		p.block(stmt.Body, p.fitsOnOneLine(stmt.Body))
+	case *ast.BlockStatement:
+		p.block(stmt, false)
+	default:
+		// break, continue and the statements of broken programs
+		p.write(strings.TrimSuffix(stmt.String(), ";"))
+	}
+}
+
+// block prints b, on one line if inline is set.
+func (p *printer) block(b *ast.BlockStatement, inline bool) {
+	if inline {
+		p.write("{ ")
+		p.statement(b.Statements[0])
+		if _, ok := b.Statements[0].(*ast.ExpressionStatement); !ok {
+			p.write(";")
+		}
+		p.write(" }")
+		return
+	}
+
+	if len(b.Statements) == 0 && !p.hasComments(b.Token.Pos.Offset, b.Rbrace.Offset) {
+		p.write("{}")
+		return
+	}
+
+	p.write("{")
+	p.depth++
+	p.first = true
+	p.statements(b.Statements, b.Rbrace.Offset, true)
+	p.depth--
+	p.write("\n" + strings.Repeat(indentation, p.depth) + "}")
+}
+
+// fitsOnOneLine reports whether b is a block written on one line that can
+// stay there: it holds a single statement without blocks or comments that
+// prints on one line.
+func (p *printer) fitsOnOneLine(b *ast.BlockStatement) bool {
+	if len(b.Statements) != 1 || b.Rbrace.Line != b.Token.Pos.Line ||
+		p.hasComments(b.Token.Pos.Offset, b.Rbrace.Offset) {
+		return false
+	}
+
+	simple := true
+	ast.Inspect(b.Statements[0], func(n ast.Node) bool {
+		if _, ok := n.(*ast.BlockStatement); ok {
+			simple = false
+		}
+		return simple
+	})
+	if !simple {
+		return false
+	}
+
+	text := p.render(func(q *printer) { q.block(b, true) }, p.flat)
+	return !strings.Contains(text, "\n")
+}
+
+// precedence returns how tightly exp binds to its operands, as the parser
+// would have parsed it.
+func precedence(exp ast.Expression) int {
+	switch exp := exp.(type) {
+	case *ast.AssignExpression:
+		return parser.ASSIGN
+	case *ast.InfixExpression:
+		return parser.Precedence(token.TokenType(exp.Operator))
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression, *ast.IndexExpression:
+		return parser.CALL
+	default:
+		return primary
+	}
+}
+
+// expr prints exp as an operand of an operator of precedence prec, in
+// parentheses if exp binds less tightly.
+func (p *printer) expr(exp ast.Expression, prec int) {
+	// A node starts no later than its own token, so start only needs to be
+	// found when a comment comes before that.
+	if exp != nil && len(p.comments) > 0 && p.comments[0].Pos().Offset < exp.Pos().Offset {
+		p.inlineComments(start(exp).Offset)
+	}
+
+	if precedence(exp) < prec {
+		p.write("(")
+		p.expr(exp, parser.LOWEST)
+		p.write(")")
+		return
+	}
+
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		p.write(exp.Value)
+	case *ast.StringLiteral:
+		if exp.Token.Type == token.RAW_STRING {
+			p.write("`" + exp.Token.Literal + "`")
+		} else {
+			p.write(`"` + exp.Token.Literal + `"`)
+		}
+	case *ast.InterpolatedString:
+		p.interpolation(exp)
+	case *ast.PrefixExpression:
+		p.write(exp.Operator)
+		if _, ok := exp.Right.(*ast.PrefixExpression); ok {
+			// --1 would read as one operator.
+			p.write("(")
+			p.expr(exp.Right, parser.LOWEST)
+			p.write(")")
+		} else {
+			p.expr(exp.Right, parser.PREFIX)
+		}
+	case *ast.InfixExpression:
+		prec := precedence(exp)
+		p.expr(exp.Left, prec)
+		p.write(" " + exp.Operator + " ")
+		p.expr(exp.Right, prec+1)
+	case *ast.AssignExpression:
+		// Assignment is right-associative.
+		p.expr(exp.Target, parser.CALL)
+		p.write(" " + exp.Operator + " ")
+		p.expr(exp.Value, parser.ASSIGN)
+	case *ast.IfExpression:
+		inline := p.fitsOnOneLine(exp.Consequence) &&
+			(exp.Alternative == nil || p.fitsOnOneLine(exp.Alternative))
+
+		p.write("if (")
+		p.expr(exp.Condition, parser.LOWEST)
+		p.write(") ")
+		p.block(exp.Consequence, inline)
+		if exp.Alternative == nil {
+			break
+		}
+
+		// Comments after the consequence stay there, and else goes on the
+		// next line.
+		next := exp.Alternative.Token.Pos
+		if p.hasComments(exp.Consequence.Rbrace.Offset, next.Offset) {
+			p.line = exp.Consequence.Rbrace.Line
+			p.trailingComments(next.Offset)
+			p.commentsBefore(next.Offset)
+			p.newline(next.Line)
+			p.write("else ")
+		} else {
+			p.write(" else ")
+		}
+		p.block(exp.Alternative, inline)
+	case *ast.FunctionLiteral:
+		p.write("fn")
+		p.function(exp.Parameters, exp.Body)
+	case *ast.MacroLiteral:
+		p.write("macro")
+		p.function(exp.Parameters, exp.Body)
+	case *ast.CallExpression:
+		p.expr(exp.Function, parser.CALL)
+		p.list(exp.Token, "(", expressions(exp.Arguments), ")", exp.Rparen)
+	case *ast.IndexExpression:
+		p.expr(exp.Left, parser.CALL)
+		p.write("[")
+		p.expr(exp.Index, parser.LOWEST)
+		p.write("]")
+	case *ast.ArrayLiteral:
+		p.list(exp.Token, "[", expressions(exp.Elements), "]", exp.Rbracket)
+	case *ast.HashLiteral:
+		p.list(exp.Token, "{", pairs(exp), "}", exp.Rbrace)
+	case nil:
+	default:
+		// identifiers and literals other than strings print as written
+		p.write(exp.String())
+	}
+}
+
+func (p *printer) function(params []*ast.Identifier, body *ast.BlockStatement) {
+	p.write("(")
+	for i, param := range params {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.inlineComments(param.Pos().Offset)
+		p.write(param.Value)
+	}
+	p.write(") ")
+	p.block(body, p.fitsOnOneLine(body))
+}
+
+// interpolation prints s with its interpolated expressions formatted, each
+// on one line.
+func (p *printer) interpolation(s *ast.InterpolatedString) {
+	// The text parts start the string or follow the } of an
+	// interpolation.
+	text := s.Token.Pos.Offset + 1
+	isText := func(part ast.Expression) bool {
+		lit, ok := part.(*ast.StringLiteral)
+		if !ok {
+			return false
+		}
+		i := lit.Pos().Offset - text
+		return i == 0 || i > 0 && i <= len(s.Token.Literal) && s.Token.Literal[i-1] == '}'
+	}
+
+	flat := p.flat
+	p.flat = true
+
+	p.write(`"`)
+	for _, part := range s.Parts {
+		if isText(part) {
+			p.write(part.(*ast.StringLiteral).Token.Literal)
+			continue
+		}
+		p.write("${")
+		p.expr(part, parser.LOWEST)
+		p.write("}")
+	}
+	p.write(`"`)
+
+	p.flat = flat
+}
+
+// An element is an element of a list: an argument of a call, an element of
+// an array or a pair of a hash literal.
+type element struct {
+	nodes   []ast.Node
+	start   token.Position
+	endLine int
+	print   func(p *printer)
+}
+
+func expressions(list []ast.Expression) []element {
+	elements := make([]element, len(list))
+	for i, exp := range list {
+		elements[i] = element{[]ast.Node{exp}, start(exp), endLine(exp), func(p *printer) {
+			p.expr(exp, parser.LOWEST)
+		}}
+	}
+	return elements
+}
+
+// pairs returns the pairs of hash.
+func pairs(hash *ast.HashLiteral) []element {
+	elements := make([]element, len(hash.Pairs))
+	for i, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
+		elements[i] = element{[]ast.Node{key, value}, start(key), endLine(value), func(p *printer) {
+			p.expr(key, parser.LOWEST)
+			p.write(": ")
+			p.expr(value, parser.LOWEST)
+		}}
+	}
+	return elements
+}
+
+// list prints elements between open and close, the brackets at tok and end.
+// They go on one line, unless that line would be too long, there are
+// comments among them, other than in the blocks of function literals, or
+// the first one starts a new line in the source; then each goes on a line
+// of its own. Whether the line is too long is judged with the lists
+// inside the elements on one line too, so that the outermost list is the
+// first to be broken.
+func (p *printer) list(tok token.Token, open string, elements []element, close string, end token.Position) {
+	if len(elements) == 0 && !p.hasComments(tok.Pos.Offset, end.Offset) {
+		p.write(open + close)
+		return
+	}
+
+	oneLine := func(q *printer) {
+		q.write(open)
+		for i, el := range elements {
+			if i > 0 {
+				q.write(", ")
+			}
+			el.print(q)
+		}
+		q.write(close)
+	}
+
+	if p.flat {
+		oneLine(p)
+		return
+	}
+
+	broken := len(elements) == 0 || elements[0].start.Line > tok.Pos.Line ||
+		p.hasListComments(tok.Pos.Offset, end.Offset, elements)
+	if !broken {
+		text, _, _ := strings.Cut(p.render(oneLine, true), "\n")
+		broken = p.column()+utf8.RuneCountInString(text) > maxWidth
+	}
+
+	if !broken {
+		oneLine(p)
+		return
+	}
+
+	p.write(open)
+	p.depth++
+	p.first = true
+	for i, el := range elements {
+		p.commentsBefore(el.start.Offset)
+		p.newline(el.start.Line)
+		el.print(p)
+		if i < len(elements)-1 {
+			p.write(",")
+		}
+
+		p.line = el.endLine
+		next := end.Offset
+		if i < len(elements)-1 {
+			next = elements[i+1].start.Offset
+		}
+		p.trailingComments(next)
+	}
+	p.commentsBefore(end.Offset)
+	p.depth--
+	p.write("\n" + strings.Repeat(indentation, p.depth) + close)
+}
+
+// start returns the position of the first token of node.
+func start(node ast.Node) token.Position {
+	pos := node.Pos()
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n != nil && n.Pos().IsValid() && n.Pos().Offset < pos.Offset {
+			pos = n.Pos()
+		}
+		return n != nil
+	})
+	return pos
+}
+
+// endLine returns the source line node ends on.
+func endLine(node ast.Node) int {
+	line := 0
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case nil:
+			return false
+		case *ast.BlockStatement:
+			line = max(line, n.Rbrace.Line)
+		case *ast.CallExpression:
+			line = max(line, n.Rparen.Line)
+		case *ast.ArrayLiteral:
+			line = max(line, n.Rbracket.Line)
+		case *ast.HashLiteral:
+			line = max(line, n.Rbrace.Line)
+		case *ast.StringLiteral:
+			line = max(line, n.Pos().Line+strings.Count(n.Token.Literal, "\n"))
+		case *ast.InterpolatedString:
+			line = max(line, n.Pos().Line+strings.Count(n.Token.Literal, "\n"))
+		}
+		line = max(line, n.Pos().Line)
+		return true
+	})
+	return line
+}
+
+// endOffset returns the offset of the last token of node that has a
+// position in the tree.
+func endOffset(node ast.Node) int {
+	offset := 0
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case nil:
+			return false
+		case *ast.BlockStatement:
+			offset = max(offset, n.Rbrace.Offset)
+		case *ast.CallExpression:
+			offset = max(offset, n.Rparen.Offset)
+		case *ast.ArrayLiteral:
+			offset = max(offset, n.Rbracket.Offset)
+		case *ast.HashLiteral:
+			offset = max(offset, n.Rbrace.Offset)
+		}
+		offset = max(offset, n.Pos().Offset)
+		return true
+	})
+	return offset
+}
@@ -0,0 +1,152 @@
+package printer
+
+import (
+	"bytes"
+	"monkey/lexer"
+	"monkey/parser"
+	"testing"
+)
+
+func format(t *testing.T, input string) string {
+	t.Helper()
+
+	l := lexer.New(input)
+	l.SetMode(lexer.ScanComments)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	var out bytes.Buffer
+	if err := Fprint(&out, program); err != nil {
+		t.Fatalf("Fprint failed: %s", err)
+	}
+	return out.String()
+}
+
+func TestFprint(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=5", "let x = 5;\n"},
+		{"let   x =  5;  return x", "let x = 5;\nreturn x;\n"},
+		{"puts(1)", "puts(1);\n"},
+		{"", ""},
+		// operators keep only the parentheses they need
+		{"((1 + 2)) * 3 - (4 - 5) - 6", "(1 + 2) * 3 - (4 - 5) - 6;\n"},
+		{"a + (b * c) + -(d + e)", "a + b * c + -(d + e);\n"},
+		{"(a && b) || c && (d || e)", "a && b || c && (d || e);\n"},
+		{"!(!x); -(-1); ~(a & b); - -x", "!(!x);\n-(-1);\n~(a & b);\n-(-x);\n"},
+		{"(f(1))[0]; (a + b)[0]; (a + b)(c)", "f(1)[0];\n(a + b)[0];\n(a + b)(c);\n"},
+		{"x = y = 1; (x = 1) + 2; a[0] += 2", "x = y = 1;\n(x = 1) + 2;\na[0] += 2;\n"},
+		{"1 << 2 >> 3 == 4 != (5 == 6)", "1 << 2 >> 3 == 4 != (5 == 6);\n"},
+		// literals print as written
+		{"0xFF + 1_000 + 1e-9 + 99999999999999999999", "0xFF + 1_000 + 1e-9 + 99999999999999999999;\n"},
+		{"\"a\\tb ${x+1}\" + `raw`", "\"a\\tb ${x + 1}\" + `raw`;\n"},
+		{`"a${1+2}b${ [1,2] }${"s"}${f(x)}\${x}"`, `"a${1 + 2}b${[1, 2]}${"s"}${f(x)}\${x}";` + "\n"},
+		{`"${ {"k":1}["k"] }"`, `"${{"k": 1}["k"]}";` + "\n"},
+		{"[1,2 , 3]; [] ; {} ; {\"a\":1,\"b\" : 2}", "[1, 2, 3];\n[];\n{};\n{\"a\": 1, \"b\": 2};\n"},
+		// blocks
+		{"let f = fn(a,b){a+b}", "let f = fn(a, b) { a + b };\n"},
+		{"let f = fn(a,b){\nlet c = a+b; c}", "let f = fn(a, b) {\n  let c = a + b;\n  c\n};\n"},
+		{"fn(){}", "fn() {};\n"},
+		{"if (x) { return 1 }", "if (x) { return 1; }\n"},
+		{"if (x) {\n1 } else { 2 }", "if (x) {\n  1\n} else {\n  2\n}\n"},
+		{"let max = if (a > b) { a } else { b };", "let max = if (a > b) { a } else { b };\n"},
+		{"while (i < 3) {\ni += 1;\n}", "while (i < 3) {\n  i += 1\n}\n"},
+		{"for (x in [1, 2]) { puts(x); }", "for (x in [1, 2]) { puts(x) }\n"},
+		{"while (true) { break }; for (x in y) {\nif (x) { continue; }\n}",
+			"while (true) { break; }\nfor (x in y) {\n  if (x) { continue; }\n}\n"},
+		{"let m = macro(a) { quote(unquote(a) + 1) };", "let m = macro(a) { quote(unquote(a) + 1) };\n"},
+		{"let f = fn() { if (x) { 1 } }", "let f = fn() {\n  if (x) { 1 }\n};\n"},
+		// semicolons after if expressions only where the next statement
+		// would continue them
+		{"if (x) { 1 }; puts(2)", "if (x) { 1 }\nputs(2);\n"},
+		{"if (x) { 1 }; (a + b)(c); if (x) { 1 }; [1]; if (x) { 1 }; -1; if (x) { 1 }; (a)(b)",
+			"if (x) { 1 };\n(a + b)(c);\nif (x) { 1 };\n[1];\nif (x) { 1 };\n-1;\nif (x) { 1 }\na(b);\n"},
+		// blank lines are kept, but at most one
+		{"let a = 1;\n\n\n\nlet b = 2;\nlet c = 3;", "let a = 1;\n\nlet b = 2;\nlet c = 3;\n"},
+		{"let f = fn() {\n\n  1;\n\n  2\n\n};", "let f = fn() {\n  1;\n\n  2\n};\n"},
+		// long lists are broken, one element per line
+		{"let xs = [\"aaaaaaaaaaaaaaaaaaaa\", \"bbbbbbbbbbbbbbbbbbbbbbbb\", \"cccccccccccccccccccccc\"];",
+			"let xs = [\n  \"aaaaaaaaaaaaaaaaaaaa\",\n  \"bbbbbbbbbbbbbbbbbbbbbbbb\",\n  \"cccccccccccccccccccccc\"\n];\n"},
+		{"call(aaaaaaaaaaaaaaaaaaaaaaaaa, bbbbbbbbbbbbbbbbbbbbbbbbbbbbbb, [cccccccccccccccccccc, 1])",
+			"call(\n  aaaaaaaaaaaaaaaaaaaaaaaaa,\n  bbbbbbbbbbbbbbbbbbbbbbbbbbbbbb,\n  [cccccccccccccccccccc, 1]\n);\n"},
+		{"let h = {\n\"a\": 1, \"b\": fn(x) {\nx\n}}",
+			"let h = {\n  \"a\": 1,\n  \"b\": fn(x) {\n    x\n  }\n};\n"},
+		{"map(xs, fn(x) {\nx * 2\n})", "map(xs, fn(x) {\n  x * 2\n});\n"},
+		// comments are kept
+		{"// a\n// b\nlet x = 1; // c\n/* d */ let y = 2;\n// e",
+			"// a\n// b\nlet x = 1; // c\n/* d */\nlet y = 2;\n// e\n"},
+		{"let f = fn() {\n  // only a comment\n};", "let f = fn() {\n  // only a comment\n};\n"},
+		{"let f = fn() { x // why\n};", "let f = fn() {\n  x // why\n};\n"},
+		{"if (x) {\n1\n} // after\nelse { 2 }", "if (x) {\n  1\n} // after\nelse {\n  2\n}\n"},
+		{"if (x) { 1 } // after if\nelse { 2 }", "if (x) { 1 } // after if\nelse { 2 }\n"},
+		{"if (x) { 1 }\n// before else\nelse { 2 }", "if (x) { 1 }\n// before else\nelse { 2 }\n"},
+		{"f(a, // first\nb)", "f(\n  a, // first\n  b\n);\n"},
+		{"let xs = [\n  // leading\n  1,\n\n  2 /* two */\n];",
+			"let xs = [\n  // leading\n  1,\n\n  2 /* two */\n];\n"},
+		{"map(xs, fn(x) {\n  // double\n  x * 2\n})", "map(xs, fn(x) {\n  // double\n  x * 2\n});\n"},
+		{"f(a, b // last\n)", "f(\n  a,\n  b // last\n);\n"},
+		{"f(a, b\n// after\n)", "f(\n  a,\n  b\n  // after\n);\n"},
+		{"let x = 1 + /* inline */ 2;\nlet y = 3;", "let x = 1 + /* inline */ 2;\nlet y = 3;\n"},
+		{"1 + /* mid */ 2", "1 + /* mid */ 2;\n"},
+		{"fn(a /* p */, b) { a }", "fn(a, /* p */ b) { a };\n"},
+		{"fn(/* first */ a) { a }", "fn(/* first */ a) { a };\n"},
+		{"let f = fn() { return /* r */ 5 }", "let f = fn() {\n  return /* r */ 5;\n};\n"},
+		{"let x = 1 + // one\n2;", "let x = 1 + // one\n  2;\n"},
+		{"f(1 + /* mid */ 2, [/* a */ a])", "f(1 + /* mid */ 2, [/* a */ a]);\n"},
+		{"f(a /* x */, b)", "f(a, /* x */ b);\n"},
+		{"f(a /* x */)", "f(\n  a /* x */\n);\n"},
+	}
+
+	for _, tt := range tests {
+		got := format(t, tt.input)
+		if got != tt.expected {
+			t.Errorf("wrong output for %q.\nwant=%q\ngot =%q", tt.input, tt.expected, got)
+			continue
+		}
+
+		if again := format(t, got); again != got {
+			t.Errorf("output for %q not stable.\nfirst =%q\nsecond=%q", tt.input, got, again)
+		}
+	}
+}
+
+func TestFprintKeepsMeaning(t *testing.T) {
+	inputs := []string{
+		"let a = 1 - (2 - 3) * -(4 + 5) / (6 % (7 * 8));",
+		"let b = !(x == y) && (p || q) || r & (s | t) ^ u << (v >> w);",
+		"let c = (fn(x) { x })(1)[0](2);",
+		"x = a[0] = (b += 1) * 2;",
+		"if (a) { b } else { c }; (d)",
+		"-(-x); -(x)[0]; (-x)[0]; ~~1;",
+		`"${-(-1)} and ${(1 + 2) * 3}";`,
+	}
+
+	for _, input := range inputs {
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+
+		formatted := format(t, input)
+		reparsed := parser.New(lexer.New(formatted)).ParseProgram()
+		if reparsed.String() != program.String() {
+			t.Errorf("formatting %q changed its meaning.\nwant=%q\ngot =%q (%q)",
+				input, program.String(), reparsed.String(), formatted)
+		}
+	}
+}
+
+func TestFprintNode(t *testing.T) {
+	program := parser.New(lexer.New("let f = fn(a) { a * (b + c) };")).ParseProgram()
+
+	var out bytes.Buffer
+	if err := Fprint(&out, program.Statements[0]); err != nil {
+		t.Fatalf("Fprint failed: %s", err)
+	}
+	if got := out.String(); got != "let f = fn(a) { a * (b + c) }" {
+		t.Errorf("wrong output for a statement. got=%q", got)
+	}
+}
@@ -0,0 +1,90 @@
+package readline
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+const DefaultHistorySize = 1000
+
+// History is a list of previously entered lines, oldest first.
+type History struct {
+	entries []string
+	max     int
+}
+
+func NewHistory(max int) *History {
+	return &History{max: max}
+}
+
+// Add appends line unless it is blank or repeats the latest entry.
+func (h *History) Add(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+
+	if n := len(h.entries); n > 0 && h.entries[n-1] == line {
+		return
+	}
+
+	h.entries = append(h.entries, line)
+	if h.max > 0 && len(h.entries) > h.max {
+		h.entries = h.entries[len(h.entries)-h.max:]
+	}
+}
+
+func (h *History) Len() int {
+	return len(h.entries)
+}
+
+func (h *History) At(i int) string {
+	return h.entries[i]
+}
+
+// Load reads one entry per line from r.
+func (h *History) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		h.Add(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+func (h *History) Save(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, entry := range h.entries {
+		bw.WriteString(entry)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// LoadFile loads the history file at path. A missing file is not an error.
+func (h *History) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return h.Load(f)
+}
+
+func (h *History) SaveFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := h.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,366 @@
+package readline
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ErrInterrupt is returned by ReadLine when the user presses Ctrl-C.
+var ErrInterrupt = errors.New("interrupt")
+
+// Completer returns the candidates for the word in front of the cursor.
+// Candidates that do not start with word are ignored.
+type Completer func(word string) []string
+
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlH     = 8
+	keyTab       = 9
+	keyLF        = 10
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyCR        = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+// Editor reads lines from a terminal with emacs-style editing, history and
+// tab completion.
+type Editor struct {
+	fd       int
+	in       *bufio.Reader
+	out      io.Writer
+	raw      bool
+	History  *History
+	Complete Completer
+}
+
+// NewEditor returns an editor for the terminal f. Output goes to out.
+func NewEditor(f *os.File, out io.Writer) *Editor {
+	return &Editor{
+		fd:      int(f.Fd()),
+		in:      bufio.NewReader(f),
+		out:     out,
+		raw:     true,
+		History: NewHistory(DefaultHistorySize),
+	}
+}
+
+// newEditor returns an editor that reads keys from in without touching the
+// terminal mode.
+func newEditor(in io.Reader, out io.Writer) *Editor {
+	return &Editor{
+		fd:      -1,
+		in:      bufio.NewReader(in),
+		out:     out,
+		History: NewHistory(DefaultHistorySize),
+	}
+}
+
+// ReadLine prints prompt and returns the line the user entered, without the
+// trailing newline. It returns io.EOF on Ctrl-D at an empty line and
+// ErrInterrupt on Ctrl-C.
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	if e.raw {
+		state, err := makeRaw(e.fd)
+		if err != nil {
+			return "", err
+		}
+		defer restore(e.fd, state)
+	}
+
+	s := &lineState{editor: e, prompt: prompt, historyIndex: e.History.Len()}
+	s.refresh()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			if err == io.EOF && len(s.buf) > 0 {
+				s.newline()
+				return string(s.buf), nil
+			}
+			return "", err
+		}
+
+		switch r {
+		case keyCR, keyLF:
+			s.newline()
+			line := string(s.buf)
+			e.History.Add(line)
+			return line, nil
+		case keyCtrlC:
+			io.WriteString(e.out, "^C")
+			s.newline()
+			return "", ErrInterrupt
+		case keyCtrlD:
+			if len(s.buf) == 0 {
+				s.newline()
+				return "", io.EOF
+			}
+			s.deleteForward()
+		case keyCtrlA:
+			s.moveTo(0)
+		case keyCtrlE:
+			s.moveTo(len(s.buf))
+		case keyCtrlB:
+			s.moveTo(s.pos - 1)
+		case keyCtrlF:
+			s.moveTo(s.pos + 1)
+		case keyCtrlH, keyBackspace:
+			s.deleteBackward()
+		case keyCtrlK:
+			s.buf = s.buf[:s.pos]
+			s.refresh()
+		case keyCtrlU:
+			s.buf = append([]rune{}, s.buf[s.pos:]...)
+			s.pos = 0
+			s.refresh()
+		case keyCtrlW:
+			s.deleteWord()
+		case keyCtrlL:
+			io.WriteString(e.out, "\x1b[H\x1b[2J")
+			s.refresh()
+		case keyCtrlP:
+			s.historyMove(-1)
+		case keyCtrlN:
+			s.historyMove(1)
+		case keyTab:
+			s.complete()
+		case keyEscape:
+			s.escape()
+		default:
+			if unicode.IsPrint(r) {
+				s.insert(r)
+			}
+		}
+	}
+}
+
+type lineState struct {
+	editor *Editor
+	prompt string
+	buf    []rune
+	pos    int
+
+	historyIndex int
+	pending      []rune // the line being edited before moving into history
+}
+
+func (s *lineState) refresh() {
+	var b strings.Builder
+
+	b.WriteString("\r")
+	b.WriteString(s.prompt)
+	b.WriteString(string(s.buf))
+	b.WriteString("\x1b[K\r")
+
+	if col := len([]rune(s.prompt)) + s.pos; col > 0 {
+		fmt.Fprintf(&b, "\x1b[%dC", col)
+	}
+
+	io.WriteString(s.editor.out, b.String())
+}
+
+func (s *lineState) newline() {
+	io.WriteString(s.editor.out, "\r\n")
+}
+
+func (s *lineState) insert(r rune) {
+	s.buf = append(s.buf, 0)
+	copy(s.buf[s.pos+1:], s.buf[s.pos:])
+	s.buf[s.pos] = r
+	s.pos++
+	s.refresh()
+}
+
+func (s *lineState) insertString(str string) {
+	for _, r := range str {
+		s.buf = append(s.buf, 0)
+		copy(s.buf[s.pos+1:], s.buf[s.pos:])
+		s.buf[s.pos] = r
+		s.pos++
+	}
+	s.refresh()
+}
+
+func (s *lineState) moveTo(pos int) {
+	if pos < 0 || pos > len(s.buf) {
+		return
+	}
+	s.pos = pos
+	s.refresh()
+}
+
+func (s *lineState) deleteBackward() {
+	if s.pos == 0 {
+		return
+	}
+	s.buf = append(s.buf[:s.pos-1], s.buf[s.pos:]...)
+	s.pos--
+	s.refresh()
+}
+
+func (s *lineState) deleteForward() {
+	if s.pos >= len(s.buf) {
+		return
+	}
+	s.buf = append(s.buf[:s.pos], s.buf[s.pos+1:]...)
+	s.refresh()
+}
+
+func (s *lineState) deleteWord() {
+	start := s.pos
+	for start > 0 && s.buf[start-1] == ' ' {
+		start--
+	}
+	for start > 0 && s.buf[start-1] != ' ' {
+		start--
+	}
+
+	s.buf = append(s.buf[:start], s.buf[s.pos:]...)
+	s.pos = start
+	s.refresh()
+}
+
+func (s *lineState) historyMove(delta int) {
+	history := s.editor.History
+	index := s.historyIndex + delta
+	if index < 0 || index > history.Len() {
+		return
+	}
+
+	if s.historyIndex == history.Len() {
+		s.pending = append([]rune{}, s.buf...)
+	}
+
+	s.historyIndex = index
+	if index == history.Len() {
+		s.buf = append([]rune{}, s.pending...)
+	} else {
+		s.buf = []rune(history.At(index))
+	}
+	s.pos = len(s.buf)
+	s.refresh()
+}
+
+// escape handles the ANSI sequences sent by arrow, Home, End and Delete.
+func (s *lineState) escape() {
+	in := s.editor.in
+
+	b, err := in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return
+	}
+
+	var param []byte
+	for {
+		b, err = in.ReadByte()
+		if err != nil {
+			return
+		}
+		if b < '0' || b > '9' {
+			break
+		}
+		param = append(param, b)
+	}
+
+	switch b {
+	case 'A':
+		s.historyMove(-1)
+	case 'B':
+		s.historyMove(1)
+	case 'C':
+		s.moveTo(s.pos + 1)
+	case 'D':
+		s.moveTo(s.pos - 1)
+	case 'H':
+		s.moveTo(0)
+	case 'F':
+		s.moveTo(len(s.buf))
+	case '~':
+		switch string(param) {
+		case "1", "7":
+			s.moveTo(0)
+		case "4", "8":
+			s.moveTo(len(s.buf))
+		case "3":
+			s.deleteForward()
+		}
+	}
+}
+
+func (s *lineState) complete() {
+	if s.editor.Complete == nil {
+		return
+	}
+
+	start := s.pos
+	for start > 0 && isWordRune(s.buf[start-1]) {
+		start--
+	}
+	word := string(s.buf[start:s.pos])
+
+	candidates := matching(word, s.editor.Complete(word))
+	if len(candidates) == 0 {
+		io.WriteString(s.editor.out, "\a")
+		return
+	}
+
+	common := commonPrefix(candidates)
+	if len(common) > len(word) {
+		s.insertString(common[len(word):])
+		return
+	}
+
+	if len(candidates) > 1 {
+		s.newline()
+		io.WriteString(s.editor.out, strings.Join(candidates, "  "))
+		s.newline()
+		s.refresh()
+	}
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || r == ':' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// matching returns the sorted, de-duplicated candidates that extend word.
+func matching(word string, candidates []string) []string {
+	seen := map[string]bool{}
+	var result []string
+
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) && !seen[c] {
+			seen[c] = true
+			result = append(result, c)
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,133 @@
+package readline
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadLineEditing(t *testing.T) {
+	tests := []struct {
+		keys     string
+		expected string
+	}{
+		{"hello\r", "hello"},
+		{"helo\x1b[Dl\r", "hello"},
+		{"world\x01hello \r", "hello world"},
+		{"hello world\x17\r", "hello "},
+		{"hello world\x01\x06\x06\x06\x06\x06\x0b\r", "hello"},
+		{"hello world\x01\x06\x06\x06\x06\x06\x15\r", " world"},
+		{"abc\x7f\x7fx\r", "ax"},
+		{"abc\x01\x1b[3~\r", "bc"},
+		{"abc\x02\x02\x04\x05d\r", "acd"},
+		{"abc\x1b[H>\x1b[F<\r", ">abc<"},
+	}
+
+	for _, tt := range tests {
+		e := newEditor(strings.NewReader(tt.keys), io.Discard)
+
+		line, err := e.ReadLine(">> ")
+		if err != nil {
+			t.Fatalf("ReadLine(%q) returned error: %s", tt.keys, err)
+		}
+
+		if line != tt.expected {
+			t.Errorf("ReadLine(%q) wrong. expected=%q, got=%q", tt.keys, tt.expected, line)
+		}
+	}
+}
+
+func TestReadLineControlKeys(t *testing.T) {
+	e := newEditor(strings.NewReader("\x04"), io.Discard)
+	if _, err := e.ReadLine(">> "); err != io.EOF {
+		t.Errorf("Ctrl-D on empty line should return io.EOF. got=%v", err)
+	}
+
+	e = newEditor(strings.NewReader("abc\x03"), io.Discard)
+	if _, err := e.ReadLine(">> "); err != ErrInterrupt {
+		t.Errorf("Ctrl-C should return ErrInterrupt. got=%v", err)
+	}
+}
+
+func TestReadLineHistory(t *testing.T) {
+	e := newEditor(strings.NewReader("first\rsecond\r\x1b[A\x1b[A\r\x10\x10\x10\x0e\r"), io.Discard)
+
+	expected := []string{"first", "second", "first", "second"}
+	for _, want := range expected {
+		line, err := e.ReadLine(">> ")
+		if err != nil {
+			t.Fatalf("ReadLine returned error: %s", err)
+		}
+		if line != want {
+			t.Errorf("wrong line. expected=%q, got=%q", want, line)
+		}
+	}
+
+	if e.History.Len() != 4 {
+		t.Errorf("wrong number of history entries. got=%d", e.History.Len())
+	}
+}
+
+func TestReadLineCompletion(t *testing.T) {
+	names := []string{"puts", "push", "let", "len", "length"}
+
+	tests := []struct {
+		keys     string
+		expected string
+		listed   string
+	}{
+		{"pu\t\r", "pu", "push  puts"},
+		{"pus\t\r", "push", ""},
+		{"x = le\tn\t\r", "x = len", "len  length"},
+		{"zz\t\r", "zz", ""},
+	}
+
+	for _, tt := range tests {
+		out := &bytes.Buffer{}
+		e := newEditor(strings.NewReader(tt.keys), out)
+		e.Complete = func(word string) []string { return names }
+
+		line, err := e.ReadLine(">> ")
+		if err != nil {
+			t.Fatalf("ReadLine(%q) returned error: %s", tt.keys, err)
+		}
+
+		if line != tt.expected {
+			t.Errorf("ReadLine(%q) wrong. expected=%q, got=%q", tt.keys, tt.expected, line)
+		}
+
+		if tt.listed != "" && !strings.Contains(out.String(), tt.listed) {
+			t.Errorf("ReadLine(%q) did not list %q. got=%q", tt.keys, tt.listed, out.String())
+		}
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+
+	h := NewHistory(2)
+	h.Add("one")
+	h.Add("two")
+	h.Add("  ")
+	h.Add("three")
+	h.Add("three")
+
+	if err := h.SaveFile(path); err != nil {
+		t.Fatalf("SaveFile returned error: %s", err)
+	}
+
+	loaded := NewHistory(DefaultHistorySize)
+	if err := loaded.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile returned error: %s", err)
+	}
+
+	if loaded.Len() != 2 || loaded.At(0) != "two" || loaded.At(1) != "three" {
+		t.Errorf("wrong history loaded. got=%v", loaded.entries)
+	}
+
+	if err := NewHistory(1).LoadFile(filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Errorf("missing history file should not be an error. got=%s", err)
+	}
+}
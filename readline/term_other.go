@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package readline
+
+import "errors"
+
+type termState struct{}
+
+func IsTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (*termState, error) {
+	return nil, errors.New("readline: raw mode not supported on this platform")
+}
+
+func restore(fd int, state *termState) error {
+	return nil
+}
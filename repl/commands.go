@@ -0,0 +1,189 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/token"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+type command struct {
+	name string
+	args string
+	help string
+	run  func(s *session, arg string)
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{":tokens", "<src>", "print the tokens the lexer produces for src", (*session).cmdTokens},
+		{":ast", "<src>", "print the syntax tree of src", (*session).cmdAST},
+		{":env", "", "list the bindings in the session", (*session).cmdEnv},
+		{":type", "<expr>", "evaluate expr and print its type", (*session).cmdType},
+		{":disasm", "<src>", "print the bytecode src compiles to", (*session).cmdDisasm},
+		{":load", "<file>", "evaluate a file into the session", (*session).cmdLoad},
+		{":reset", "", "clear all bindings", (*session).cmdReset},
+		{":time", "<expr>", "evaluate expr and report time and allocations", (*session).cmdTime},
+		{":help", "", "show this message", (*session).cmdHelp},
+		{":quit", "", "leave the REPL", nil},
+	}
+}
+
+// runCommand runs a colon command line. It returns false when the REPL
+// should stop.
+func (s *session) runCommand(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		if cmd.run == nil {
+			return false
+		}
+
+		if cmd.args != "" && arg == "" {
+			fmt.Fprintf(s.out, "usage: %s %s\n", cmd.name, cmd.args)
+			return true
+		}
+
+		cmd.run(s, arg)
+		return true
+	}
+
+	fmt.Fprintf(s.out, "unknown command %s, type :help for a list\n", name)
+	return true
+}
+
+func (s *session) cmdTokens(src string) {
+	l := lexer.New(src)
+	for tok := l.NextToken(); ; tok = l.NextToken() {
+		fmt.Fprintf(s.out, "%-6s %-10s %q\n", tok.Pos, tok.Type, tok.Literal)
+		if tok.Type == token.EOF {
+			return
+		}
+	}
+}
+
+func (s *session) cmdAST(src string) {
+	program := s.parse("", src)
+	if program == nil {
+		return
+	}
+
+	ast.Fprint(s.out, program)
+}
+
+func (s *session) cmdEnv(string) {
+	names := s.env.Names()
+	sort.Strings(names)
+
+	for _, name := range names {
+		val, _ := s.env.Get(name)
+		fmt.Fprintf(s.out, "%s: %s = %s\n", name, val.Type(), summary(val))
+	}
+}
+
+// summary is the first line of an object's Inspect output, so that
+// functions fit on one line.
+func summary(obj object.Object) string {
+	text := obj.Inspect()
+	if first, _, found := strings.Cut(text, "\n"); found {
+		return first + " ...}"
+	}
+	return text
+}
+
+func (s *session) cmdType(src string) {
+	evaluated := s.evaluate("", src)
+	if evaluated == nil {
+		return
+	}
+
+	fmt.Fprintln(s.out, evaluated.Type())
+}
+
+func (s *session) cmdDisasm(src string) {
+	// Macros and globals src defines go into scratch environments, so
+	// that disassembling it leaves the session as it was.
+	scratch := *s
+	scratch.macros = object.ExtendEnvironment(s.macros)
+
+	program := scratch.parse("", src)
+	if program != nil {
+		program = scratch.expand(program, src)
+	}
+	if program == nil {
+		return
+	}
+
+	globals := object.NewEnvironment()
+	for _, name := range s.env.Slots() {
+		globals.Define(name)
+	}
+
+	comp := compiler.New(globals)
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintln(s.out, err)
+		return
+	}
+
+	compiler.Disassemble(s.out, comp.Bytecode(), src)
+}
+
+func (s *session) cmdLoad(filename string) {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return
+	}
+
+	s.evaluate(filename, string(source))
+}
+
+func (s *session) cmdReset(string) {
+	s.env = object.NewEnvironment()
+	s.macros = object.NewEnvironment()
+}
+
+func (s *session) cmdTime(src string) {
+	var before, after runtime.MemStats
+
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	evaluated := s.evaluate("", src)
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	if evaluated != nil {
+		io.WriteString(s.out, evaluated.Inspect())
+		io.WriteString(s.out, "\n")
+	}
+
+	fmt.Fprintf(s.out, "time: %s, allocations: %d (%d bytes)\n", elapsed,
+		after.Mallocs-before.Mallocs, after.TotalAlloc-before.TotalAlloc)
+}
+
+func (s *session) cmdHelp(string) {
+	for _, cmd := range commands {
+		usage := cmd.name
+		if cmd.args != "" {
+			usage += " " + cmd.args
+		}
+		fmt.Fprintf(s.out, "  %-16s %s\n", usage, cmd.help)
+	}
+}
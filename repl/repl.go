@@ -4,64 +4,248 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"monkey/ast"
+	"monkey/diagnostics"
 	"monkey/evaluator"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/readline"
+	"monkey/token"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 const PROMPT = ">> "
 
-const MONKEY_FACE = `            __,__
-   .--.  .-"     "-.  .--.
-  / .. \/  .-. .-.  \/ .. \
- | |  '|  /   Y   \  |'  | |
- | \   \  \ 0 | 0 /  /   / |
-  \ '- ,\.-"""""""-./, -' /
-   ''-' /_   ^ ^   _\ '-''
-       |  \._   _./  |
-       \   \ '~' /   /
-        '._ '-=-' _.'
-           '-----'
-`
-
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+const CONTINUATION_PROMPT = ".. "
+
+const HISTORY_FILE = ".monkey_history"
+
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+type scannerReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (r *scannerReader) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+// Engine runs a program in an environment and returns its value or the
+// *object.Error that stopped it.
+type Engine func(program *ast.Program, env *object.Environment) object.Object
+
+// Start runs the REPL, running input with engine or, if it is nil, the
+// evaluator. When in is a terminal, lines are read with a line editor that
+// keeps its history in ~/.monkey_history.
+func Start(in io.Reader, out io.Writer, engine Engine) {
+	if engine == nil {
+		engine = func(program *ast.Program, env *object.Environment) object.Object {
+			return evaluator.Eval(program, env)
+		}
+	}
+
+	s := &session{
+		env:     object.NewEnvironment(),
+		macros:  object.NewEnvironment(),
+		engine:  engine,
+		out:     out,
+		printer: diagnostics.NewPrinter(out),
+	}
+
+	var reader lineReader = &scannerReader{scanner: bufio.NewScanner(in), out: out}
+	saveHistory := func() {}
+
+	if f, ok := in.(*os.File); ok && readline.IsTerminal(int(f.Fd())) {
+		editor := readline.NewEditor(f, out)
+		editor.Complete = func(word string) []string { return s.completions() }
+
+		if path := historyPath(); path != "" {
+			editor.History.LoadFile(path)
+			saveHistory = func() { editor.History.SaveFile(path) }
+		}
+
+		reader = editor
+	}
+
+	var lines []string
 
 	for {
-		fmt.Fprint(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
-			return
+		prompt := PROMPT
+		if len(lines) > 0 {
+			prompt = CONTINUATION_PROMPT
 		}
 
-		input := scanner.Text()
-		l := lexer.New(input)
-		p := parser.New(l)
+		line, err := reader.ReadLine(prompt)
+		if err == readline.ErrInterrupt {
+			lines = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		saveHistory()
 
-		program := p.ParseProgram()
+		if len(lines) == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			if !s.runCommand(strings.TrimSpace(line)) {
+				return
+			}
+			continue
+		}
 
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		// An empty continuation line submits the input as it is, so a
+		// construct that will never be complete can still be reported.
+		if len(lines) > 0 && strings.TrimSpace(line) == "" {
+			input := strings.Join(lines, "\n")
+			lines = nil
+			s.eval("", input)
 			continue
 		}
 
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
+		lines = append(lines, line)
+		input := strings.Join(lines, "\n")
+		if isIncomplete(input) {
+			continue
 		}
+
+		lines = nil
+		s.eval("", input)
 	}
 
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	io.WriteString(out, MONKEY_FACE)
-	io.WriteString(out, "Whoops! We ran into some monkey business here!\n")
-	io.WriteString(out, "parse errors:\n")
+type session struct {
+	env     *object.Environment
+	macros  *object.Environment
+	engine  Engine
+	out     io.Writer
+	printer *diagnostics.Printer
+}
+
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, HISTORY_FILE)
+}
 
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+func (s *session) completions() []string {
+	var names []string
+	names = append(names, s.env.Names()...)
+	names = append(names, evaluator.BuiltinNames()...)
+	names = append(names, token.Keywords()...)
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
 	}
+	return names
+}
+
+// parse parses input and reports any syntax errors. It returns nil if there
+// were errors.
+func (s *session) parse(filename, input string) *ast.Program {
+	p := parser.New(lexer.NewFile(filename, input))
+
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		s.printer.PrintAll(input, diagnostics.FromParseErrors(p.Errors()))
+		return nil
+	}
+
+	return program
+}
+
+// expand defines the macros in program and expands the calls to them,
+// with the macros defined earlier in the session. It returns nil if an
+// expansion failed.
+func (s *session) expand(program *ast.Program, input string) *ast.Program {
+	evaluator.DefineMacros(program, s.macros)
+
+	if _, errObj := evaluator.ExpandMacros(program, s.macros); errObj != nil {
+		s.printer.Print(input, diagnostics.FromRuntimeError(errObj))
+		return nil
+	}
+
+	return program
+}
+
+// evaluate evaluates input in the session environment, reporting syntax and
+// runtime errors. It returns nil if there were errors.
+func (s *session) evaluate(filename, input string) object.Object {
+	program := s.parse(filename, input)
+	if program == nil {
+		return nil
+	}
+
+	program = s.expand(program, input)
+	if program == nil {
+		return nil
+	}
+
+	evaluated := s.engine(program, s.env)
+	if errObj, ok := evaluated.(*object.Error); ok {
+		s.printer.Print(input, diagnostics.FromRuntimeError(errObj))
+		return nil
+	}
+
+	return evaluated
+}
+
+func (s *session) eval(filename, input string) {
+	evaluated := s.evaluate(filename, input)
+	if evaluated != nil {
+		io.WriteString(s.out, evaluated.Inspect())
+		io.WriteString(s.out, "\n")
+	}
+}
+
+// isIncomplete reports whether input stops in the middle of a construct:
+// inside unclosed delimiters, a string or a block comment, or where the
+// parser ran into the end of the input.
+func isIncomplete(input string) bool {
+	if strings.TrimSpace(input) == "" {
+		return false
+	}
+
+	depth := 0
+	l := lexer.New(input)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACKET, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACKET, token.RBRACE:
+			depth--
+		case token.ILLEGAL:
+			if tok.Literal == `"` || tok.Literal == "`" || tok.Literal == "/*" {
+				return true
+			}
+		}
+	}
+
+	if depth > 0 {
+		return true
+	}
+
+	p := parser.New(lexer.New(input))
+	p.ParseProgram()
+	for _, err := range p.Errors() {
+		if err.Actual.Type == token.EOF {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,152 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"", false},
+		{"1 + 2", false},
+		{"let add = fn(x, y) {", true},
+		{"let add = fn(x, y) {\n  x + y\n};", false},
+		{"[1, 2,", true},
+		{"add(1,", true},
+		{`"hello`, true},
+		{"1 /* comment", true},
+		{"1 // comment", false},
+		{"let x =", true},
+		{"1 +", true},
+		{"if (x) { 1 } else", true},
+		{"let = 5", false},
+		{")", false},
+	}
+
+	for _, tt := range tests {
+		if got := isIncomplete(tt.input); got != tt.expected {
+			t.Errorf("isIncomplete(%q) wrong. expected=%t, got=%t", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStartMultiLineInput(t *testing.T) {
+	input := `let add = fn(x, y) {
+  x + y
+};
+add(1,
+  2)
+let broken = fn() {
+
+`
+
+	out := &bytes.Buffer{}
+	Start(strings.NewReader(input), out, nil)
+
+	expected := ">> .. .. >> .. 3\n>> .. "
+	if !strings.HasPrefix(out.String(), expected) {
+		t.Fatalf("wrong output. expected prefix %q, got=%q", expected, out.String())
+	}
+
+	if !strings.Contains(out.String(), "expected } to close block") {
+		t.Errorf("expected incomplete input to be reported on an empty line. got=%q", out.String())
+	}
+}
+
+func TestMetaCommands(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "lib.mk")
+	if err := os.WriteFile(script, []byte("let loaded = 42;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{":tokens let y = \"a\";", []string{
+			`1:1    LET        "let"`,
+			`1:9    STRING     "a"`,
+			`1:13   EOF        ""`,
+		}},
+		{":ast add(1, 2)", []string{
+			"Program 1:1",
+			"0: ExpressionStatement 1:1",
+			"Expression: CallExpression 1:4",
+			"Function: Identifier 1:1 Value=\"add\"",
+			"0: IntegerLiteral 1:5 Value=1",
+		}},
+		{`:ast {"a": 1}`, []string{
+			"Expression: HashLiteral 1:1",
+			"Pairs: (len = 1)",
+			"0: HashPair",
+			`Key: StringLiteral 1:2 Value="a"`,
+			"Value: IntegerLiteral 1:7 Value=1",
+		}},
+		{"let x = [1];\nlet f = fn(a) {\na\n};\n:env", []string{
+			"f: FUNCTION = fn(a) { ...}",
+			"x: ARRAY = [1]",
+		}},
+		{":type [1, 2]\n:type \"s\"\n:type fn() {}", []string{"ARRAY", "STRING", "FUNCTION"}},
+		{"let x = 1;\n:disasm x += 2", []string{
+			"0000  1:6     OpConstant 0           ; 2",
+			"0003  1:1     OpAssign 0 0 5         ; x +=",
+		}},
+		{":load " + script + "\nloaded", []string{"42"}},
+		{"let unless = macro(c, a, b) { quote(if (unquote(c)) { unquote(b) } else { unquote(a) }) };\nunless(false, 1, 2)\n:reset\nunless(false, 1, 2)", []string{
+			"1\n",
+			"identifier not found: unless",
+		}},
+		{"let x = 1;\n:reset\nx", []string{"identifier not found: x"}},
+		{":time 1 + 2", []string{"3\n", "time: ", "allocations: "}},
+		{":nope", []string{"unknown command :nope"}},
+		{":type", []string{"usage: :type <expr>"}},
+		{":help", []string{":load <file>", "evaluate a file into the session"}},
+	}
+
+	for _, tt := range tests {
+		out := &bytes.Buffer{}
+		Start(strings.NewReader(tt.input), out, nil)
+
+		for _, want := range tt.expected {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("output of %q does not contain %q. got=\n%s", tt.input, want, out.String())
+			}
+		}
+	}
+}
+
+func TestDisasmLeavesSessionAlone(t *testing.T) {
+	input := "let a = 1;\n:disasm let zz = 5\n:disasm let m = macro(x) { x }; m(a)\n:disasm a\nm(1)\nzz"
+
+	out := &bytes.Buffer{}
+	Start(strings.NewReader(input), out, nil)
+
+	for _, want := range []string{
+		"globals: a zz\n",
+		"globals: a\n",
+		"identifier not found: m",
+		"identifier not found: zz",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q. got=\n%s", want, out.String())
+		}
+	}
+	if strings.Count(out.String(), "globals: a zz") != 1 {
+		t.Errorf("a :disasm saw the globals of an earlier one. got=\n%s", out.String())
+	}
+}
+
+func TestQuitCommand(t *testing.T) {
+	out := &bytes.Buffer{}
+	Start(strings.NewReader(":quit\n1 + 1"), out, nil)
+
+	if strings.Contains(out.String(), "2") {
+		t.Errorf("REPL kept evaluating after :quit. got=%q", out.String())
+	}
+}
@@ -0,0 +1,198 @@
+// Package resolver works out, before a program runs, which environment
+// slot each variable lives in, so that the evaluator can reach it without
+// looking its name up.
+package resolver
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+// Resolve fills in the Binding of every identifier under node and the
+// Locals of every function literal and for loop. Top-level variables get
+// slots in globals.
+//
+// The body of a function inside another function or a loop is resolved
+// once the whole enclosing body has been, so that it can use a local
+// declared after it, like a second mutually recursive function. A name
+// that no enclosing scope declares is given a global slot, so that a
+// function can call a global defined after it and an undefined name or a
+// builtin is reported or found when it is used, as before.
+func Resolve(node ast.Node, globals *object.Environment) {
+	r := &resolver{scope: &scope{globals: globals}}
+	r.resolve(node)
+}
+
+type resolver struct {
+	scope *scope
+}
+
+// scope is a function body or a loop body during resolution. The outermost
+// scope stands for the global environment.
+type scope struct {
+	outer   *scope
+	names   ast.Frame
+	slots   map[string]int
+	globals *object.Environment
+
+	// functions holds the function and macro literals whose bodies wait
+	// for the scope to be fully declared.
+	functions []ast.Node
+}
+
+func (s *scope) declare(name string) int {
+	if s.globals != nil {
+		return s.globals.Define(name)
+	}
+
+	if slot, ok := s.slots[name]; ok {
+		return slot
+	}
+
+	slot := len(s.names)
+	s.names = append(s.names, name)
+	s.slots[name] = slot
+
+	return slot
+}
+
+func (s *scope) lookup(name string) (int, bool) {
+	if s.globals != nil {
+		return s.globals.Define(name), true
+	}
+
+	slot, ok := s.slots[name]
+	return slot, ok
+}
+
+func (r *resolver) push() {
+	r.scope = &scope{outer: r.scope, slots: make(map[string]int)}
+}
+
+func (r *resolver) pop() ast.Frame {
+	// Bodies resolved here can add functions of their own to the list.
+	for i := 0; i < len(r.scope.functions); i++ {
+		r.function(r.scope.functions[i])
+	}
+
+	names := r.scope.names
+	r.scope = r.scope.outer
+	return names
+}
+
+func (r *resolver) declare(ident *ast.Identifier) {
+	ident.Binding = ast.Binding{Resolved: true, Slot: r.scope.declare(ident.Value)}
+}
+
+func (r *resolver) use(ident *ast.Identifier) {
+	depth := 0
+	for s := r.scope; s != nil; s = s.outer {
+		if slot, ok := s.lookup(ident.Value); ok {
+			ident.Binding = ast.Binding{Resolved: true, Depth: depth, Slot: slot}
+			return
+		}
+		depth++
+	}
+}
+
+func (r *resolver) resolve(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, stmt := range node.Statements {
+			r.resolve(stmt)
+		}
+	case *ast.BlockStatement:
+		for _, stmt := range node.Statements {
+			r.resolve(stmt)
+		}
+	case *ast.LetStatement:
+		// A function can refer to itself by the name it is bound to, but
+		// any other value is resolved first so that let x = x + 1 can read
+		// an outer x.
+		if _, ok := node.Value.(*ast.FunctionLiteral); ok {
+			r.declare(node.Name)
+			r.resolve(node.Value)
+		} else {
+			r.resolve(node.Value)
+			r.declare(node.Name)
+		}
+	case *ast.ReturnStatement:
+		r.resolve(node.ReturnValue)
+	case *ast.ExpressionStatement:
+		r.resolve(node.Expression)
+	case *ast.WhileStatement:
+		r.resolve(node.Condition)
+		r.resolve(node.Body)
+	case *ast.ForStatement:
+		r.resolve(node.Iterable)
+		r.push()
+		r.declare(node.Variable)
+		r.resolve(node.Body)
+		node.Locals = r.pop()
+	case *ast.Identifier:
+		r.use(node)
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			r.resolve(part)
+		}
+	case *ast.PrefixExpression:
+		r.resolve(node.Right)
+	case *ast.InfixExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Right)
+	case *ast.AssignExpression:
+		r.resolve(node.Value)
+		r.resolve(node.Target)
+	case *ast.IfExpression:
+		r.resolve(node.Condition)
+		r.resolve(node.Consequence)
+		if node.Alternative != nil {
+			r.resolve(node.Alternative)
+		}
+	case *ast.FunctionLiteral, *ast.MacroLiteral:
+		// Globals are defined as they are used, so only local scopes have
+		// to be declared in full first.
+		if r.scope.globals != nil {
+			r.function(node)
+		} else {
+			r.scope.functions = append(r.scope.functions, node)
+		}
+	case *ast.CallExpression:
+		r.resolve(node.Function)
+		for _, arg := range node.Arguments {
+			r.resolve(arg)
+		}
+	case *ast.ArrayLiteral:
+		for _, elem := range node.Elements {
+			r.resolve(elem)
+		}
+	case *ast.IndexExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Index)
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			r.resolve(pair.Key)
+			r.resolve(pair.Value)
+		}
+	}
+}
+
+// function resolves the parameters and body of a function or macro
+// literal.
+func (r *resolver) function(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.FunctionLiteral:
+		node.Locals = r.body(node.Parameters, node.Body)
+	case *ast.MacroLiteral:
+		node.Locals = r.body(node.Parameters, node.Body)
+	}
+}
+
+func (r *resolver) body(params []*ast.Identifier, body *ast.BlockStatement) ast.Frame {
+	r.push()
+	for _, param := range params {
+		r.declare(param)
+	}
+	r.resolve(body)
+	return r.pop()
+}
@@ -0,0 +1,192 @@
+package resolver
+
+import (
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return program
+}
+
+// identifiers returns the identifiers under node named name, in source
+// order.
+func identifiers(node ast.Node, name string) []*ast.Identifier {
+	var found []*ast.Identifier
+
+	var visit func(ast.Node)
+	visit = func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.Program:
+			for _, s := range node.Statements {
+				visit(s)
+			}
+		case *ast.BlockStatement:
+			for _, s := range node.Statements {
+				visit(s)
+			}
+		case *ast.LetStatement:
+			visit(node.Name)
+			visit(node.Value)
+		case *ast.ExpressionStatement:
+			visit(node.Expression)
+		case *ast.ReturnStatement:
+			visit(node.ReturnValue)
+		case *ast.ForStatement:
+			visit(node.Variable)
+			visit(node.Iterable)
+			visit(node.Body)
+		case *ast.FunctionLiteral:
+			for _, p := range node.Parameters {
+				visit(p)
+			}
+			visit(node.Body)
+		case *ast.CallExpression:
+			visit(node.Function)
+			for _, a := range node.Arguments {
+				visit(a)
+			}
+		case *ast.InfixExpression:
+			visit(node.Left)
+			visit(node.Right)
+		case *ast.IfExpression:
+			visit(node.Condition)
+			visit(node.Consequence)
+			if node.Alternative != nil {
+				visit(node.Alternative)
+			}
+		case *ast.AssignExpression:
+			visit(node.Target)
+			visit(node.Value)
+		case *ast.Identifier:
+			if node.Value == name {
+				found = append(found, node)
+			}
+		}
+	}
+	visit(node)
+
+	return found
+}
+
+func TestResolveBindings(t *testing.T) {
+	tests := []struct {
+		input    string
+		name     string
+		expected []ast.Binding
+	}{
+		{
+			"let a = 1; let b = 2; b",
+			"b",
+			[]ast.Binding{{Resolved: true, Slot: 1}, {Resolved: true, Slot: 1}},
+		},
+		{
+			"let g = 1; fn(x) { fn(y) { fn(z) { g + x } } }",
+			"g",
+			[]ast.Binding{{Resolved: true}, {Resolved: true, Depth: 3}},
+		},
+		{
+			"let g = 1; fn(x) { fn(y) { fn(z) { g + x } } }",
+			"x",
+			[]ast.Binding{{Resolved: true}, {Resolved: true, Depth: 2}},
+		},
+		{
+			"fn(a, b) { let c = a; c + b }",
+			"c",
+			[]ast.Binding{{Resolved: true, Slot: 2}, {Resolved: true, Slot: 2}},
+		},
+		{
+			"let x = 1; fn() { let x = x + 1; x }",
+			"x",
+			[]ast.Binding{{Resolved: true}, {Resolved: true}, {Resolved: true, Depth: 1}, {Resolved: true}},
+		},
+		{
+			"fn() { let f = fn(n) { f(n) } }",
+			"f",
+			[]ast.Binding{{Resolved: true}, {Resolved: true, Depth: 1}},
+		},
+		{
+			"fn() { let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } }; " +
+				"let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } } }",
+			"isOdd",
+			[]ast.Binding{{Resolved: true, Depth: 1, Slot: 1}, {Resolved: true, Slot: 1}},
+		},
+		{
+			"let t = 0; for (i in xs) { let sq = i * i; t += sq }",
+			"sq",
+			[]ast.Binding{{Resolved: true, Slot: 1}, {Resolved: true, Slot: 1}},
+		},
+		{
+			"let t = 0; for (i in xs) { let sq = i * i; t += sq }",
+			"t",
+			[]ast.Binding{{Resolved: true}, {Resolved: true, Depth: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		Resolve(program, object.NewEnvironment())
+
+		idents := identifiers(program, tt.name)
+		if len(idents) != len(tt.expected) {
+			t.Fatalf("%q: found %d uses of %s, want %d", tt.input, len(idents), tt.name, len(tt.expected))
+		}
+
+		for i, ident := range idents {
+			if ident.Binding != tt.expected[i] {
+				t.Errorf("%q: %s #%d bound to %+v, want %+v", tt.input, tt.name, i, ident.Binding, tt.expected[i])
+			}
+		}
+	}
+}
+
+func TestResolveFrames(t *testing.T) {
+	program := parse(t, "fn(a, b) { let c = 1; if (a) { let d = 2 }; for (i in b) { let e = i } }")
+	Resolve(program, object.NewEnvironment())
+
+	fn := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if got := strings.Join(fn.Locals, " "); got != "a b c d" {
+		t.Errorf("function locals wrong. got=%q", got)
+	}
+
+	loop := fn.Body.Statements[2].(*ast.ForStatement)
+	if got := strings.Join(loop.Locals, " "); got != "i e" {
+		t.Errorf("loop locals wrong. got=%q", got)
+	}
+}
+
+func TestResolveGlobals(t *testing.T) {
+	globals := object.NewEnvironment()
+	globals.Set("args", &object.Array{})
+
+	program := parse(t, "let f = fn() { later + args }; let later = 1")
+	Resolve(program, globals)
+
+	later := identifiers(program, "later")
+	if later[0].Binding.Slot != later[1].Binding.Slot {
+		t.Errorf("forward reference bound to %+v, definition to %+v", later[0].Binding, later[1].Binding)
+	}
+
+	args := identifiers(program, "args")[0]
+	if args.Binding != (ast.Binding{Resolved: true, Depth: 1, Slot: 0}) {
+		t.Errorf("args bound to %+v", args.Binding)
+	}
+
+	// A second program in the same globals, as in the REPL, sees the
+	// first one's variables.
+	next := parse(t, "f")
+	Resolve(next, globals)
+
+	if got := identifiers(next, "f")[0].Binding; got != (ast.Binding{Resolved: true, Slot: 1}) {
+		t.Errorf("f bound to %+v in a later program", got)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/diagnostics"
+	"monkey/diff"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/printer"
+	"monkey/repl"
+	"monkey/vm"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// execute parses and evaluates source, reporting errors on stderr, and
+// returns the process exit code. When printResult is set the value of the
+// program is written to stdout.
+func execute(filename, source string, args []string, printResult bool) int {
+	printer := diagnostics.NewPrinter(os.Stderr)
+
+	p := parser.New(lexer.NewFile(filename, source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printer.PrintAll(source, diagnostics.FromParseErrors(p.Errors()))
+		return exitSyntaxError
+	}
+
+	if !expandMacros(program, source, printer) {
+		return exitRuntimeError
+	}
+
+	env := object.NewEnvironment()
+	env.Set("args", scriptArgs(args))
+
+	evaluated := engine(program, env)
+	if errObj, ok := evaluated.(*object.Error); ok {
+		printer.Print(source, diagnostics.FromRuntimeError(errObj))
+		return exitRuntimeError
+	}
+
+	if printResult && evaluated != nil && evaluated != evaluator.NULL {
+		fmt.Println(evaluated.Inspect())
+	}
+
+	return exitOK
+}
+
+// compile compiles the script in filename to bytecode, reporting errors on
+// stderr. If it fails it returns a nil bytecode and the exit code.
+func compile(filename string) (*compiler.Bytecode, string, int) {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "monkey: %s\n", err)
+		return nil, "", exitNoInput
+	}
+
+	p := parser.New(lexer.NewFile(filename, string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		diagnostics.NewPrinter(os.Stderr).PrintAll(string(source), diagnostics.FromParseErrors(p.Errors()))
+		return nil, "", exitSyntaxError
+	}
+
+	if !expandMacros(program, string(source), diagnostics.NewPrinter(os.Stderr)) {
+		return nil, "", exitRuntimeError
+	}
+
+	// Give args the slot it has when the script is run from source.
+	env := object.NewEnvironment()
+	env.Define("args")
+
+	comp := compiler.New(env)
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintf(os.Stderr, "monkey: %s: %s\n", filename, err)
+		return nil, "", exitSyntaxError
+	}
+
+	return comp.Bytecode(), string(source), exitOK
+}
+
+// expandMacros defines the macros of program and expands the calls to them,
+// reporting a failed expansion. Macros see each other but none of the
+// program's own variables.
+func expandMacros(program *ast.Program, source string, printer *diagnostics.Printer) bool {
+	macros := object.NewEnvironment()
+	evaluator.DefineMacros(program, macros)
+
+	if _, errObj := evaluator.ExpandMacros(program, macros); errObj != nil {
+		printer.Print(source, diagnostics.FromRuntimeError(errObj))
+		return false
+	}
+
+	return true
+}
+
+// build compiles the script in filename and writes the bytecode to output.
+func build(filename, output string) int {
+	bytecode, _, status := compile(filename)
+	if bytecode == nil {
+		return status
+	}
+
+	data, err := bytecode.MarshalBinary()
+	if err == nil {
+		err = os.WriteFile(output, data, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "monkey: %s\n", err)
+		return exitCantCreate
+	}
+
+	return exitOK
+}
+
+// disassemble prints the bytecode of a script, or of a program compiled by
+// build.
+func disassemble(filename string) int {
+	if filepath.Ext(filename) != ".mkc" {
+		bytecode, source, status := compile(filename)
+		if bytecode == nil {
+			return status
+		}
+		compiler.Disassemble(os.Stdout, bytecode, source)
+		return exitOK
+	}
+
+	bytecode, status := load(filename)
+	if bytecode == nil {
+		return status
+	}
+	compiler.Disassemble(os.Stdout, bytecode, "")
+	return exitOK
+}
+
+// printAST prints the syntax tree of a script, as parsed, or its JSON
+// encoding.
+func printAST(filename string, asJSON bool) int {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "monkey: %s\n", err)
+		return exitNoInput
+	}
+
+	p := parser.New(lexer.NewFile(filename, string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		diagnostics.NewPrinter(os.Stderr).PrintAll(string(source), diagnostics.FromParseErrors(p.Errors()))
+		return exitSyntaxError
+	}
+
+	if asJSON {
+		err = ast.EncodeJSON(os.Stdout, program)
+	} else {
+		err = ast.Fprint(os.Stdout, program)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "monkey: %s\n", err)
+		return exitRuntimeError
+	}
+
+	return exitOK
+}
+
+// formatFile formats the script in filename.
+func formatFile(filename string, write, showDiff bool) int {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "monkey: %s\n", err)
+		return exitNoInput
+	}
+
+	return formatSource(filename, string(source), write, showDiff)
+}
+
+// formatSource formats source, the script in filename. It prints the
+// result, or with write replaces the file by it if it differs; with
+// showDiff it prints the changes made instead of the result.
+func formatSource(filename, source string, write, showDiff bool) int {
+	l := lexer.NewFile(filename, source)
+	l.SetMode(lexer.ScanComments)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		diagnostics.NewPrinter(os.Stderr).PrintAll(source, diagnostics.FromParseErrors(p.Errors()))
+		return exitSyntaxError
+	}
+
+	var out strings.Builder
+	// The lexer skips the #! line of an executable script.
+	if strings.HasPrefix(source, "#!") {
+		shebang, _, _ := strings.Cut(source, "\n")
+		out.WriteString(shebang + "\n")
+	}
+	printer.Fprint(&out, program)
+	formatted := out.String()
+
+	if showDiff {
+		fmt.Print(diff.Unified(filename+".orig", filename, source, formatted))
+	} else if !write {
+		fmt.Print(formatted)
+	}
+
+	if write && formatted != source {
+		info, err := os.Stat(filename)
+		if err == nil {
+			err = os.WriteFile(filename, []byte(formatted), info.Mode().Perm())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "monkey: %s\n", err)
+			return exitCantCreate
+		}
+	}
+
+	return exitOK
+}
+
+// load reads a program compiled by build.
+func load(filename string) (*compiler.Bytecode, int) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "monkey: %s\n", err)
+		return nil, exitNoInput
+	}
+
+	bytecode := &compiler.Bytecode{}
+	if err := bytecode.UnmarshalBinary(data); err != nil {
+		fmt.Fprintf(os.Stderr, "monkey: %s: %s\n", filename, err)
+		return nil, exitDataError
+	}
+
+	return bytecode, exitOK
+}
+
+// executeBytecode runs a program compiled by build on the vm, whatever the
+// engine.
+func executeBytecode(filename string, args []string) int {
+	bytecode, status := load(filename)
+	if bytecode == nil {
+		return status
+	}
+
+	env := object.NewEnvironment()
+	for _, name := range bytecode.Globals {
+		env.Define(name)
+	}
+	env.Set("args", scriptArgs(args))
+
+	if errObj, ok := vm.New(bytecode, env).Run().(*object.Error); ok {
+		diagnostics.NewPrinter(os.Stderr).Print("", diagnostics.FromRuntimeError(errObj))
+		return exitRuntimeError
+	}
+
+	return exitOK
+}
+
+var engines = map[string]repl.Engine{
+	"eval": func(program *ast.Program, env *object.Environment) object.Object {
+		return evaluator.Eval(program, env)
+	},
+	"vm": vm.Eval,
+}
+
+func scriptArgs(args []string) *object.Array {
+	elems := make([]object.Object, len(args))
+	for i, arg := range args {
+		elems[i] = &object.String{Value: arg}
+	}
+	return &object.Array{Elements: elems}
+}
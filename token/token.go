@@ -1,14 +1,20 @@
 package token
 
+import "fmt"
+
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	COMMENT = "COMMENT" // only produced when the lexer scans comments
 
 	// Identifiers + literals
 	IDENT  = "IDENT"  // add, foobar, x, y, ...
-	INT    = "INT"    // 1343456
+	INT    = "INT"    // 1343456, 0xFF, 0o755, 0b1010, 1_000
+	FLOAT  = "FLOAT"  // 3.14, 1e-9
 	STRING = "STRING" // "makarena"
 
+	RAW_STRING = "RAW_STRING" // `makarena`
+
 	// Operators
 	ASSIGN   = "="
 	PLUS     = "+"
@@ -16,9 +22,28 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
+
+	LT    = "<"
+	GT    = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
+
+	AND = "&&"
+	OR  = "||"
 
-	LT = "<"
-	GT = ">"
+	BIT_AND = "&"
+	BIT_OR  = "|"
+	BIT_XOR = "^"
+	BIT_NOT = "~"
+	SHL     = "<<"
+	SHR     = ">>"
+
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+	PERCENT_ASSIGN  = "%="
 
 	EQ     = "=="
 	NOT_EQ = "!="
@@ -44,23 +69,95 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	MACRO    = "MACRO"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"macro":    MACRO,
 }
 
 type TokenType string
 
+func Keywords() []string {
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Position describes a location in the source. Line and Column are
+// 1-based, Offset is the 0-based byte offset into the input.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.Filename != "" {
+			return p.Filename
+		}
+		return "-"
+	}
+
+	if p.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+	}
+
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Span is the half-open source range [Start, End).
+type Span struct {
+	Start Position
+	End   Position
+}
+
+func (s Span) Len() int {
+	return s.End.Offset - s.Start.Offset
+}
+
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position
+}
+
+// Span returns the source range covered by the token's literal.
+func (t Token) Span() Span {
+	length := len(t.Literal)
+	if t.Type == STRING || t.Type == RAW_STRING {
+		length += 2
+	}
+
+	end := t.Pos
+	end.Offset += length
+	end.Column += length
+	return Span{Start: t.Pos, End: end}
 }
 
 func LookUpIdentifierType(identifier string) TokenType {
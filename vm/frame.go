@@ -0,0 +1,28 @@
+package vm
+
+import (
+	"monkey/code"
+	"monkey/object"
+)
+
+// Frame is a call in progress. Its variables live in env, which loops
+// replace with environments of their own while they run.
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+	env         *object.Environment
+}
+
+func NewFrame(cl *object.Closure, basePointer int, env *object.Environment) *Frame {
+	return &Frame{
+		cl:          cl,
+		ip:          -1,
+		basePointer: basePointer,
+		env:         env,
+	}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
@@ -0,0 +1,578 @@
+// Package vm runs the bytecode the compiler produces. It gives the same
+// results as the evaluator, sharing its operators and builtins.
+package vm
+
+import (
+	"fmt"
+	"math"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/object"
+	"strings"
+)
+
+// The stack and the call frames grow as needed up to these limits.
+const StackSize = 1 << 20
+const MaxFrames = 1 << 16
+
+var (
+	True  = evaluator.TRUE
+	False = evaluator.FALSE
+	Null  = evaluator.NULL
+)
+
+// operators holds the operator each operator instruction applies.
+var operators = [...]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShiftLeft:    "<<",
+	code.OpShiftRight:   ">>",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpLessThan:     "<",
+	code.OpGreaterThan:  ">",
+	code.OpLessEqual:    "<=",
+	code.OpGreaterEqual: ">=",
+	code.OpMinus:        "-",
+	code.OpBang:         "!",
+	code.OpBitNot:       "~",
+}
+
+type VM struct {
+	stack []object.Object
+	sp    int // Always points to the next free slot. Top of stack is stack[sp-1]
+
+	frames      []Frame
+	framesIndex int
+}
+
+// New returns a vm that runs bytecode with globals as its global
+// environment, which must be the one the bytecode was compiled for.
+func New(bytecode *compiler.Bytecode, globals *object.Environment) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
+		Constants:    bytecode.Constants,
+		Scopes:       bytecode.Scopes,
+	}
+	mainClosure := &object.Closure{Fn: mainFn, Env: globals}
+
+	frames := make([]Frame, 1, 64)
+	frames[0] = *NewFrame(mainClosure, 0, globals)
+
+	return &VM{
+		stack:       make([]object.Object, 1024),
+		sp:          0,
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// Eval compiles program for env and runs it there. Like evaluator.Eval it
+// returns the value of the program or the *object.Error that stopped it.
+func Eval(program *ast.Program, env *object.Environment) object.Object {
+	comp := compiler.New(env)
+	if err := comp.Compile(program); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+
+	return New(comp.Bytecode(), env).Run()
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return &vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) bool {
+	if vm.framesIndex >= MaxFrames {
+		return false
+	}
+
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, *f)
+	} else {
+		vm.frames[vm.framesIndex] = *f
+	}
+	vm.framesIndex++
+
+	return true
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return &vm.frames[vm.framesIndex]
+}
+
+// Run runs the program. It returns the value of the program, or the
+// *object.Error that stopped it with the position of the code that failed.
+func (vm *VM) Run() object.Object {
+	var ip int
+	var op code.Opcode
+
+	frame := vm.currentFrame()
+	ins := frame.Instructions()
+	constants := frame.cl.Fn.Constants
+
+	for frame.ip < len(ins)-1 {
+		frame.ip++
+
+		ip = frame.ip
+		op = code.Opcode(ins[ip])
+
+		var err *object.Error
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			err = vm.push(constants[constIndex])
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpTrue:
+			err = vm.push(True)
+
+		case code.OpFalse:
+			err = vm.push(False)
+
+		case code.OpNull:
+			err = vm.push(Null)
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight,
+			code.OpEqual, code.OpNotEqual, code.OpLessThan, code.OpGreaterThan,
+			code.OpLessEqual, code.OpGreaterEqual:
+			err = vm.executeBinaryOperation(op)
+
+		case code.OpMinus, code.OpBang, code.OpBitNot:
+			operand := vm.pop()
+			err = vm.pushResult(evaluator.Prefix(operators[op], operand))
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			condition := vm.pop()
+			if !evaluator.IsTruthy(condition) {
+				frame.ip = pos - 1
+			}
+
+		case code.OpAnd, code.OpOr:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			if evaluator.IsTruthy(vm.stack[vm.sp-1]) == (op == code.OpOr) {
+				frame.ip = pos - 1
+			} else {
+				vm.pop()
+			}
+
+		case code.OpGetVar:
+			depth := int(code.ReadUint8(ins[ip+1:]))
+			slot := int(code.ReadUint16(ins[ip+2:]))
+			frame.ip += 3
+
+			err = vm.getVar(frame.env, depth, slot)
+
+		case code.OpDefine:
+			slot := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			frame.env.Store(0, slot, vm.pop())
+
+		case code.OpAssign:
+			depth := int(code.ReadUint8(ins[ip+1:]))
+			slot := int(code.ReadUint16(ins[ip+2:]))
+			operator := code.Opcode(code.ReadUint8(ins[ip+4:]))
+			frame.ip += 4
+
+			err = vm.assign(frame.env, depth, slot, operator)
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+
+			err = vm.push(array)
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			hash, hashErr := vm.buildHash(vm.sp-numElements, vm.sp)
+			if hashErr != nil {
+				err = hashErr
+				break
+			}
+			vm.sp = vm.sp - numElements
+
+			err = vm.push(hash)
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.Index(left, index))
+
+		case code.OpSetIndex:
+			operator := code.Opcode(code.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+
+			err = vm.setIndex(operator)
+
+		case code.OpInterpolate:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			var out strings.Builder
+			for _, part := range vm.stack[vm.sp-numParts : vm.sp] {
+				out.WriteString(part.Inspect())
+			}
+			vm.sp = vm.sp - numParts
+
+			err = vm.push(&object.String{Value: out.String()})
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+
+			err = vm.executeCall(int(numArgs))
+
+			frame = vm.currentFrame()
+			ins = frame.Instructions()
+			constants = frame.cl.Fn.Constants
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+			if vm.framesIndex == 1 {
+				return returnValue
+			}
+
+			vm.sp = vm.popFrame().basePointer - 1
+			frame = vm.currentFrame()
+			ins = frame.Instructions()
+			constants = frame.cl.Fn.Constants
+
+			err = vm.push(returnValue)
+
+		case code.OpReturn:
+			if vm.framesIndex == 1 {
+				return nil
+			}
+
+			vm.sp = vm.popFrame().basePointer - 1
+			frame = vm.currentFrame()
+			ins = frame.Instructions()
+			constants = frame.cl.Fn.Constants
+
+			err = vm.push(Null)
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			fn := constants[constIndex].(*object.CompiledFunction)
+			err = vm.push(&object.Closure{Fn: fn, Env: frame.env})
+
+		case code.OpQuote:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numArgs := int(code.ReadUint16(ins[ip+3:]))
+			frame.ip += 4
+
+			template := constants[constIndex].(*object.Quote)
+			args := vm.stack[vm.sp-numArgs : vm.sp]
+			quoted := evaluator.Quote(template.Node, func(i int, _ ast.Expression) object.Object {
+				return args[i]
+			})
+			vm.sp = vm.sp - numArgs
+
+			err = vm.pushResult(quoted)
+
+		case code.OpIter:
+			iterable := vm.stack[vm.sp-1]
+			it, ok := object.NewIterator(iterable)
+			if !ok {
+				err = newError("cannot iterate over %s", iterable.Type())
+				break
+			}
+			vm.stack[vm.sp-1] = it
+
+		case code.OpNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			element, ok := vm.stack[vm.sp-1].(*object.Iterator).Next()
+			if !ok {
+				frame.ip = pos - 1
+				break
+			}
+			err = vm.push(element)
+
+		case code.OpEnterScope:
+			scopeIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			frame.env = object.NewFrame(frame.env, frame.cl.Fn.Scopes[scopeIndex])
+
+		case code.OpLeaveScope:
+			frame.env = frame.env.Outer()
+
+		default:
+			err = newError("unknown opcode %d", op)
+		}
+
+		if err != nil {
+			return vm.fail(err, ip)
+		}
+	}
+
+	return nil
+}
+
+// fail attributes err to the source of the instruction at ip in the
+// current frame, unless it already has a position.
+func (vm *VM) fail(err *object.Error, ip int) *object.Error {
+	if !err.Pos.IsValid() {
+		err.Pos = vm.currentFrame().cl.Fn.Positions.Lookup(ip)
+	}
+	return err
+}
+
+func (vm *VM) executeBinaryOperation(op code.Opcode) *object.Error {
+	right := vm.pop()
+	left := vm.pop()
+
+	return vm.pushResult(binaryOperation(op, left, right))
+}
+
+func binaryOperation(op code.Opcode, left, right object.Object) object.Object {
+	if l, ok := left.(*object.Integer); ok {
+		if r, ok := right.(*object.Integer); ok {
+			if result := integerOperation(op, l.Value, r.Value); result != nil {
+				return result
+			}
+		}
+	}
+
+	return evaluator.Infix(operators[op], left, right)
+}
+
+// integerOperation is a fast path for the most common operations on
+// integers. It returns nil for anything else, including results that
+// overflow, which evaluator.Infix handles.
+func integerOperation(op code.Opcode, l, r int64) object.Object {
+	switch op {
+	case code.OpAdd:
+		if sum := l + r; (sum > l) == (r > 0) {
+			return &object.Integer{Value: sum}
+		}
+	case code.OpSub:
+		if diff := l - r; (diff < l) == (r > 0) {
+			return &object.Integer{Value: diff}
+		}
+	case code.OpMul:
+		if product := l * r; r != 0 && product/r == l && !(l == math.MinInt64 && r == -1) {
+			return &object.Integer{Value: product}
+		}
+	case code.OpDiv:
+		if r != 0 && !(l == math.MinInt64 && r == -1) {
+			return &object.Integer{Value: l / r}
+		}
+	case code.OpMod:
+		if r != 0 {
+			return &object.Integer{Value: l % r}
+		}
+	case code.OpEqual:
+		return nativeBoolToBooleanObject(l == r)
+	case code.OpNotEqual:
+		return nativeBoolToBooleanObject(l != r)
+	case code.OpLessThan:
+		return nativeBoolToBooleanObject(l < r)
+	case code.OpGreaterThan:
+		return nativeBoolToBooleanObject(l > r)
+	case code.OpLessEqual:
+		return nativeBoolToBooleanObject(l <= r)
+	case code.OpGreaterEqual:
+		return nativeBoolToBooleanObject(l >= r)
+	}
+	return nil
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
+}
+
+func (vm *VM) getVar(env *object.Environment, depth, slot int) *object.Error {
+	if val := env.Lookup(depth, slot); val != nil {
+		return vm.push(val)
+	}
+
+	name := env.Name(depth, slot)
+	if builtin, ok := evaluator.Builtin(name); ok {
+		return vm.push(builtin)
+	}
+
+	return newError("identifier not found: %s", name)
+}
+
+// assign stores the value on top of the stack in a variable, first
+// applying operator to the variable's current value and it if this is a
+// compound assignment.
+func (vm *VM) assign(env *object.Environment, depth, slot int, operator code.Opcode) *object.Error {
+	current := env.Lookup(depth, slot)
+	if current == nil {
+		return newError("identifier not found: %s", env.Name(depth, slot))
+	}
+
+	val := vm.stack[vm.sp-1]
+	if operator != 0 {
+		val = binaryOperation(operator, current, val)
+		if err, ok := val.(*object.Error); ok {
+			return err
+		}
+		vm.stack[vm.sp-1] = val
+	}
+
+	env.Store(depth, slot, val)
+	return nil
+}
+
+func (vm *VM) setIndex(operator code.Opcode) *object.Error {
+	val := vm.pop()
+	index := vm.pop()
+	left := vm.pop()
+
+	if operator != 0 {
+		current := evaluator.Index(left, index)
+		if err, ok := current.(*object.Error); ok {
+			return err
+		}
+
+		val = binaryOperation(operator, current, val)
+		if err, ok := val.(*object.Error); ok {
+			return err
+		}
+	}
+
+	return vm.pushResult(evaluator.SetIndex(left, index, val))
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, *object.Error) {
+	hash := object.NewHash((endIndex - startIndex) / 2)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		pair := object.HashPair{Key: key, Value: value}
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, newError("unusable as hash key: %s", key.Type())
+		}
+
+		hash.Set(hashKey.HashKey(), pair)
+	}
+
+	return hash, nil
+}
+
+func (vm *VM) executeCall(numArgs int) *object.Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return newError("not a function: %s", callee.Type())
+	}
+}
+
+// callClosure starts a call. The arguments stay on the stack for the code
+// of the function to store in its environment.
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) *object.Error {
+	if numArgs != cl.Fn.NumParameters {
+		return newError("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+
+	env := object.NewFrame(cl.Env, cl.Fn.Locals)
+	if !vm.pushFrame(NewFrame(cl, vm.sp-numArgs, env)) {
+		return newError("stack overflow")
+	}
+
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) *object.Error {
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+
+	result := builtin.Fn(args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if result == nil {
+		result = Null
+	}
+	return vm.pushResult(result)
+}
+
+func (vm *VM) push(o object.Object) *object.Error {
+	if vm.sp >= len(vm.stack) {
+		if vm.sp >= StackSize {
+			return newError("stack overflow")
+		}
+		vm.stack = append(vm.stack, make([]object.Object, len(vm.stack))...)
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+// pushResult pushes the result of an operation, unless it is an error.
+func (vm *VM) pushResult(o object.Object) *object.Error {
+	if err, ok := o.(*object.Error); ok {
+		return err
+	}
+	return vm.push(o)
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func newError(format string, a ...any) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,442 @@
+package vm
+
+import (
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"testing"
+)
+
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1", 1},
+		{"1 + 2", 3},
+		{"50 / 2 * 2 + 10 - 5", 55},
+		{"5 * (2 + 10)", 60},
+		{"-50 + 100 + -50", 0},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"-7 % 3", -1},
+		{"0xf0 & 0x3c | 1", 49},
+		{"12 ^ 10", 6},
+		{"~5", -6},
+		{"1 << 10 >> 2", 256},
+		{"9223372036854775807 + 1 - 1", 9223372036854775807},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", true},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"2 <= 2", true},
+		{"1 >= 2", false},
+		{"1 == 1", true},
+		{"1 != 1", false},
+		{"true == false", false},
+		{"(1 < 2) == true", true},
+		{"!true", false},
+		{"!!5", true},
+		{"!(if (false) { 5; })", true},
+		{"1.5 < 2", true},
+		{"true && false", false},
+		{"false || 2 > 1", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLogicalOperatorsShortCircuit(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 && 2", 2},
+		{"first([]) && 2", nil},
+		{"0 || 2", 0},
+		{"false || \"x\"", "x"},
+		{"let n = 0; let bump = fn() { n += 1; true }; false && bump(); true || bump(); n", 0},
+		{"let n = 0; let bump = fn() { n += 1; true }; true && bump(); false || bump(); n", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (true) { 10 } else { 20 }", 10},
+		{"if (false) { 10 } else { 20 } ", 20},
+		{"if (1 > 2) { 10 }", nil},
+		{"if (false) { 10 }", nil},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"if (true) { let a = 1 }", nil},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestVariables(t *testing.T) {
+	tests := []vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = one + one; one + two", 3},
+		{"let x = 1; x = x + 1; x", 2},
+		{"let x = 10; x -= 3; x *= 2", 14},
+		{"let x = 1; let x = x + 1; x", 2},
+		{"let x = 5; let f = fn() { x = 6 }; f(); x", 6},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{`"monkey"`, "monkey"},
+		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`let name = "world"; "hello, ${name}! ${1 + 2}"`, "hello, world! 3"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestArraysAndHashes(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1 + 2, 3 * 4, 5 + 6]", []int{3, 12, 11}},
+		{"[1, 2, 3][1]", 2},
+		{"[[1, 1, 1]][0][0]", 1},
+		{"[1, 2, 3][99]", nil},
+		{"{1: 2, 2: 3}[2]", 3},
+		{"{1: 1}[0]", nil},
+		{"let a = [1, 2]; a[0] = 5; a", []int{5, 2}},
+		{"let a = [1, 2]; let b = a; a[1] += 10; b", []int{1, 12}},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] + h["b"]`, 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn() { 5 + 10 }; f()", 15},
+		{"let one = fn() { 1 }; let two = fn() { 2 }; one() + two()", 3},
+		{"let early = fn() { return 99; 100; }; early()", 99},
+		{"let noReturn = fn() { }; noReturn()", nil},
+		{"let sum = fn(a, b) { let c = a + b; c }; sum(1, 2) + sum(3, 4)", 10},
+		{"let g = 50; let minus = fn(n) { g - n }; minus(8)", 42},
+		{"fn(a, a) { a }(1, 2)", 2},
+		{`len("four") + len([1, 2])`, 6},
+		{"first(rest(push([1, 2], 3)))", 2},
+		{"len(range(10))", 10},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`let newClosure = fn(a) { fn() { a; }; };
+			let closure = newClosure(99);
+			closure();`,
+			99,
+		},
+		{
+			`let newAdder = fn(a, b) { fn(c) { a + b + c }; };
+			let adder = newAdder(1, 2);
+			adder(8);`,
+			11,
+		},
+		{
+			`let newAdderOuter = fn(a, b) {
+				let c = a + b;
+				fn(d) {
+					let e = d + c;
+					fn(f) { e + f; };
+				};
+			};
+			let newAdderInner = newAdderOuter(1, 2)
+			let adder = newAdderInner(3);
+			adder(8);`,
+			14,
+		},
+		{
+			`let counter = fn() { let n = 0; fn() { n += 1 } };
+			let a = counter();
+			let b = counter();
+			a(); a(); b();
+			a() * 10 + b()`,
+			32,
+		},
+		{
+			`let fns = [];
+			for (i in range(3)) { fns = push(fns, fn() { i }) }
+			fns[0]() + fns[1]() * 10 + fns[2]() * 100`,
+			210,
+		},
+		{
+			`let countDown = fn(x) { if (x == 0) { return 0; } else { countDown(x - 1); } };
+			let wrapper = fn() { countDown(1); };
+			wrapper();`,
+			0,
+		},
+		{
+			`let wrapper = fn() {
+				let countDown = fn(x) { if (x == 0) { return 0; } else { countDown(x - 1); } };
+				countDown(1);
+			};
+			wrapper();`,
+			0,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 10) { i += 1 }; i", 10},
+		{"let i = 0; while (true) { i += 1; if (i == 5) { break } }; i", 5},
+		{"let s = 0; for (x in [1, 2, 3]) { s += x }; s", 6},
+		{"let s = 0; for (i in range(10)) { if (i % 2 == 0) { continue } s += i }; s", 25},
+		{"let s = 0; for (i in range(10)) { if (i == 3) { break } s += i }; s", 3},
+		{`let s = ""; for (c in "abc") { s = c + s }; s`, "cba"},
+		{"let s = 0; for (k in {1: 0, 2: 0}) { s += k }; s", 3},
+		{
+			`let s = 0;
+			for (i in range(3)) { for (j in range(3)) { if (j > i) { break } s += 1 } }
+			s`,
+			6,
+		},
+		{
+			`let s = 0;
+			for (i in range(5)) { s += [i, if (i == 3) { break } else { 0 }][0] }
+			s`,
+			3,
+		},
+		{"let f = fn() { for (i in range(10)) { if (i == 4) { return i } } }; f()", 4},
+		{"let a = [1, 2]; for (x in a) { a = push(a, x) }; len(a)", 4},
+		{"for (x in [1]) { x }", nil},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFibonacci(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`let fibonacci = fn(x) {
+				if (x == 0) { return 0; }
+				if (x == 1) { return 1; }
+				fibonacci(x - 1) + fibonacci(x - 2);
+			};
+			fibonacci(15);`,
+			610,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + true;", "1:3: type mismatch: INTEGER + BOOLEAN"},
+		{"-true", "1:1: unknown operator: -BOOLEAN"},
+		{"foobar", "1:1: identifier not found: foobar"},
+		{"x = 1", "1:1: identifier not found: x"},
+		{"1 / 0", "1:3: division by zero"},
+		{`len(1)`, "1:4: argument to `len` not supported, got INTEGER"},
+		{"{[1]: 2}", "1:1: unusable as hash key: ARRAY"},
+		{"let a = [1]; a[5] = 2", "1:16: index out of range: 5"},
+		{"1[0]", "1:2: index operator not supported: INTEGER"},
+		{"for (x in 1) { x }", "1:11: cannot iterate over INTEGER"},
+		{"5()", "1:2: not a function: INTEGER"},
+		{"fn(a) { a }()", "1:12: wrong number of arguments: want=1, got=0"},
+		{"let f = fn() {\n  1 + \"a\"\n};\nf()", "2:5: type mismatch: INTEGER + STRING"},
+		{"let f = fn() { f() }; f()", "1:17: stack overflow"},
+	}
+
+	for _, tt := range tests {
+		result := run(t, tt.input)
+
+		errObj, ok := result.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, result, result)
+			continue
+		}
+
+		if got := errObj.Pos.String() + ": " + errObj.Message; got != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// TestAgreesWithEvaluator runs programs on both engines and compares the
+// results.
+func TestAgreesWithEvaluator(t *testing.T) {
+	inputs := []string{
+		"2 * 4611686018427387904",
+		"-(-9223372036854775807 - 1)",
+		"1 << 100 >> 99",
+		"7.5 % 2 + 1",
+		"int(\"123456789012345678901234567890\") / 3",
+		"float(1) / 3",
+		`let a = [3, 1, 2]; let i = 0; while (i < len(a)) { a[i] *= 2; i += 1 }; a`,
+		`let h = {"k": [1]}; h["k"][0] += 1; h["k"]`,
+		`"${[1, "a"]} ${{1: true}} ${null}"`,
+		`let h = {"b": 1, "a": 2, 3: 4, "b": 5}; h["c"] = 6; h`,
+		`let r = []; for (k in {"z": 1, "y": 2, "x": 3}) { r = push(r, k) }; r`,
+		`let s = ""; let f = fn(x) { s = s + x; x }; {f("b"): f("1"), f("a"): f("2")}; s`,
+		"range(1, 10, 3)",
+		"let r = []; for (i in range(10, 0, -3)) { r = push(r, i) }; r",
+		"let x = 1; let f = fn() { let x = x + 1; x }; [f(), x]",
+		"let x = 1; for (x in [5]) { x }; x",
+		"first([]) == null",
+		"1 == true",
+		"\"a\" != \"a\"",
+		"let f = fn(x) { quote(unquote(x) + unquote(x * 2)) }; [f(1), f(2)]",
+		"quote(unquote([1.5, \"s\", quote(a + b)]) + {1: 2})",
+		"quote(unquote({}))",
+		"fn() { macro(x) { x } }()",
+		"let f = fn(a, b) { a }; f(1)",
+		"let f = fn(a) { a }; f(1, 2)",
+		"let c = 0; for (i in range(5)) { let t = if (i == 3) { break; }; c += 1 }; c",
+		"let r = []; for (i in range(3)) { r = push(r, if (i == 1) { continue; } else { i }) }; r",
+		"let r = []; for (i in range(3)) { r = push(r, [i, if (i == 1) { continue; }]) }; r",
+		"let r = []; for (i in range(3)) { r = push(r, {i: if (i == 1) { break; } else { i }}) }; r",
+		"let c = 0; for (i in range(4)) { c = c + if (i == 2) { continue; } else { i } }; c",
+		"let a = [1, 2]; let c = 0; while (c < 5) { c += 1; a[if (c == 2) { break; } else { 0 }] }; c",
+		"let f = fn() { for (i in range(3)) { let x = -if (i == 1) { return i; } else { i } } }; f()",
+	}
+
+	for _, input := range inputs {
+		want := evaluator.Eval(parse(input), object.NewEnvironment())
+		got := run(t, input)
+
+		if got.Inspect() != want.Inspect() {
+			t.Errorf("%s: want=%s, got=%s", input, want.Inspect(), got.Inspect())
+		}
+	}
+}
+
+func TestRunAcrossPrograms(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Set("args", &object.Array{Elements: []object.Object{&object.Integer{Value: 10}}})
+
+	inputs := []string{
+		"let counter = args[0]; let bump = fn() { counter += 1 };",
+		"bump(); bump();",
+		"let total = counter * 10;",
+	}
+	for _, input := range inputs {
+		if result, ok := Eval(parse(input), env).(*object.Error); ok {
+			t.Fatalf("error running %q: %s", input, result.Message)
+		}
+	}
+
+	val, ok := env.Get("total")
+	if !ok {
+		t.Fatalf("total not bound")
+	}
+	testIntegerObject(t, val, 120)
+}
+
+func BenchmarkFibonacci(b *testing.B) {
+	program := parse(`
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+fib(20)`)
+
+	for i := 0; i < b.N; i++ {
+		Eval(program, object.NewEnvironment())
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func run(t *testing.T, input string) object.Object {
+	t.Helper()
+
+	program := parse(input)
+	result := Eval(program, object.NewEnvironment())
+	if result == nil {
+		return Null
+	}
+	return result
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		result := run(t, tt.input)
+		if err, ok := result.(*object.Error); ok {
+			t.Errorf("%s: vm error: %s", tt.input, err.Inspect())
+			continue
+		}
+
+		testExpectedObject(t, tt.input, tt.expected, result)
+	}
+}
+
+func testExpectedObject(t *testing.T, input string, expected interface{}, actual object.Object) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		if !testIntegerObject(t, actual, int64(expected)) {
+			t.Errorf("in %s", input)
+		}
+	case bool:
+		result, ok := actual.(*object.Boolean)
+		if !ok || result.Value != expected {
+			t.Errorf("%s: want %t, got %s", input, expected, actual.Inspect())
+		}
+	case string:
+		result, ok := actual.(*object.String)
+		if !ok || result.Value != expected {
+			t.Errorf("%s: want %q, got %s", input, expected, actual.Inspect())
+		}
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok || len(array.Elements) != len(expected) {
+			t.Errorf("%s: want %v, got %s", input, expected, actual.Inspect())
+			return
+		}
+		for i, el := range expected {
+			testIntegerObject(t, array.Elements[i], int64(el))
+		}
+	case nil:
+		if actual != Null {
+			t.Errorf("%s: object is not Null: %T (%+v)", input, actual, actual)
+		}
+	}
+}
+
+func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
+	t.Helper()
+
+	result, ok := obj.(*object.Integer)
+	if !ok {
+		t.Errorf("object is not Integer. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%d, want=%d", result.Value, expected)
+		return false
+	}
+
+	return true
+}